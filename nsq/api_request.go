@@ -25,6 +25,12 @@ func (c *deadlinedConn) Write(b []byte) (n int, err error) {
 }
 
 func ApiRequest(endpoint string) (*simplejson.Json, error) {
+	return ApiRequestWithHeader(endpoint, nil)
+}
+
+// ApiRequestWithHeader is ApiRequest with additional request headers
+// (ie. to forward the user on whose behalf a request is made)
+func ApiRequestWithHeader(endpoint string, header http.Header) (*simplejson.Json, error) {
 	transport := &http.Transport{
 		Dial: func(netw, addr string) (net.Conn, error) {
 			c, err := net.DialTimeout(netw, addr, time.Second*2)
@@ -40,6 +46,9 @@ func ApiRequest(endpoint string) (*simplejson.Json, error) {
 	if err != nil {
 		return nil, err
 	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
 
 	resp, err := httpclient.Do(req)
 	if err != nil {
@@ -31,17 +31,20 @@ func Announce(topic string, channel string, port int, ips []string) *Command {
 }
 
 // Identify is the first message sent to the Lookupd and provides information about the client
-func Identify(version string, tcpPort int, httpPort int, address string) *Command {
+// (lookupd refuses a client whose worker id is in use by another with E_WORKER_ID_CONFLICT)
+func Identify(version string, tcpPort int, httpPort int, address string, workerId int64) *Command {
 	body, err := json.Marshal(struct {
 		Version  string `json:"version"`
 		TcpPort  int    `json:"tcp_port"`
 		HttpPort int    `json:"http_port"`
 		Address  string `json:"address"`
+		WorkerId int64  `json:"worker_id"`
 	}{
 		version,
 		tcpPort,
 		httpPort,
 		address,
+		workerId,
 	})
 	if err != nil {
 		log.Fatal("failed to create json %s", err.Error())
@@ -79,6 +82,59 @@ func Publish(topic string, body []byte) *Command {
 	return &Command{[]byte("PUB"), params, body}
 }
 
+// PublishWithKey creates a new Command to write a message to a given topic
+// with a partition key, messages with the same key are delivered in order
+// on ordered channels
+func PublishWithKey(topic string, key []byte, body []byte) *Command {
+	var params = [][]byte{[]byte(topic), key}
+	return &Command{[]byte("PUB"), params, body}
+}
+
+// PublishWithAttributes creates a new Command to write a message to a given
+// topic with the attributes of a request (replyTo) or a reply (correlationId)
+func PublishWithAttributes(topic string, replyTo string, correlationId string, body []byte) *Command {
+	var params = [][]byte{[]byte(topic)}
+	if replyTo != "" {
+		params = append(params, []byte("reply_to="+replyTo))
+	}
+	if correlationId != "" {
+		params = append(params, []byte("correlation_id="+correlationId))
+	}
+	return &Command{[]byte("PUB"), params, body}
+}
+
+// IdentifyMessageAttributes creates a new Command to negotiate receiving
+// messages with their attributes (as FrameTypeMessageWithAttributes),
+// it must be sent before subscribing
+func IdentifyMessageAttributes() *Command {
+	body, err := json.Marshal(struct {
+		MessageAttributes bool `json:"message_attributes"`
+	}{
+		true,
+	})
+	if err != nil {
+		log.Fatalf("failed to create json %s", err.Error())
+	}
+	return &Command{[]byte("IDENTIFY"), nil, body}
+}
+
+// IdentifyOutputBuffer creates a new Command to negotiate how nsqd buffers
+// writes to the client, it must be sent before subscribing.
+// NOTE: 0 keeps nsqd's default and -1 disables buffering
+func IdentifyOutputBuffer(size int, timeoutMs int) *Command {
+	body, err := json.Marshal(struct {
+		OutputBufferSize    int `json:"output_buffer_size"`
+		OutputBufferTimeout int `json:"output_buffer_timeout"`
+	}{
+		size,
+		timeoutMs,
+	})
+	if err != nil {
+		log.Fatalf("failed to create json %s", err.Error())
+	}
+	return &Command{[]byte("IDENTIFY"), nil, body}
+}
+
 // Subscribe creates a new Command to subscribe
 // to the given topic/channel
 func Subscribe(topic string, channel string, shortIdentifier string, longIdentifier string) *Command {
@@ -86,6 +142,15 @@ func Subscribe(topic string, channel string, shortIdentifier string, longIdentif
 	return &Command{[]byte("SUB"), params, nil}
 }
 
+// SubscribeWithWeight creates a new Command to subscribe to the given
+// topic/channel declaring the client's weight, on channels using the
+// weighted dispatch policy clients receive messages in proportion to their weight
+func SubscribeWithWeight(topic string, channel string, shortIdentifier string, longIdentifier string, weight int) *Command {
+	var params = [][]byte{[]byte(topic), []byte(channel), []byte(shortIdentifier), []byte(longIdentifier),
+		[]byte(strconv.Itoa(weight))}
+	return &Command{[]byte("SUB"), params, nil}
+}
+
 // Ready creates a new Command to specify
 // the number of messages a client is willing to receive
 func Ready(count int) *Command {
@@ -4,11 +4,17 @@ package nsq
 // E_BAD_PROTOCOL
 // E_BAD_TOPIC
 // E_BAD_CHANNEL
+// E_CHANNEL_EXCLUSIVE
+// E_TOO_MANY_CLIENTS
 // E_BAD_BODY
+// E_BAD_KEY
 // E_REQ_FAILED
 // E_FIN_FAILED
 // E_PUT_FAILED
+// E_TOPIC_FULL
+// E_DISK_FULL
 // E_MISSING_PARAMS
+// E_WORKER_ID_CONFLICT
 
 type ClientErr struct {
 	Err  string
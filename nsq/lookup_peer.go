@@ -33,7 +33,7 @@ func NewLookupPeer(addr string, connectCallback func(*LookupPeer)) *LookupPeer {
 
 func (lp *LookupPeer) Connect() error {
 	log.Printf("LOOKUP connecting to %s", lp.addr)
-	conn, err := net.DialTimeout("tcp", lp.addr, time.Second)
+	conn, err := Dial(lp.addr, time.Second)
 	if err != nil {
 		return err
 	}
@@ -56,6 +56,10 @@ func (lp *LookupPeer) Write(data []byte) (int, error) {
 }
 
 func (lp *LookupPeer) Close() error {
+	if lp.conn == nil {
+		// never connected
+		return nil
+	}
 	return lp.conn.Close()
 }
 
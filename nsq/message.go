@@ -3,6 +3,7 @@ package nsq
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
 	"io/ioutil"
 	"time"
@@ -10,6 +11,9 @@ import (
 
 const MsgIdLength = 16
 
+// the max length of each message attribute
+const MaxAttributeLength = 255
+
 // Message is the fundamental data type containing
 // the id, body, and meta-data
 type Message struct {
@@ -17,15 +21,30 @@ type Message struct {
 	Body      []byte
 	Timestamp int64
 	Attempts  uint16
+
+	// Key is an optional partition key used for ordered delivery
+	// (it is not part of the encoded message sent to clients)
+	Key []byte
+
+	// TimestampNano is Timestamp with sub-second precision, it is not part
+	// of the encoded message so a decoded message only has second precision
+	TimestampNano int64
+
+	// attributes for request/reply (see Requester), they are only part of
+	// the message as encoded by EncodeWithAttributes
+	ReplyTo       string // the topic a reply is published to
+	CorrelationId string // identifies the request a reply is for
 }
 
 // NewMessage creates a Message, initializes some meta-data, 
 // and returns a pointer
 func NewMessage(id []byte, body []byte) *Message {
+	now := time.Now()
 	return &Message{
-		Id:        id,
-		Body:      body,
-		Timestamp: time.Now().Unix(),
+		Id:            id,
+		Body:          body,
+		Timestamp:     now.Unix(),
+		TimestampNano: now.UnixNano(),
 	}
 }
 
@@ -64,6 +83,77 @@ func (m *Message) Encode(w io.Writer) error {
 	return nil
 }
 
+// HasAttributes returns true if the message has a ReplyTo or CorrelationId
+func (m *Message) HasAttributes() bool {
+	return m.ReplyTo != "" || m.CorrelationId != ""
+}
+
+// EncodeWithAttributes serializes the message including its attributes
+// (as sent in a FrameTypeMessageWithAttributes), ie:
+//
+//	[ 8-byte timestamp ][ 2-byte attempts ][ 16-byte id ]
+//	[ 1-byte length ][ reply_to ][ 1-byte length ][ correlation_id ][ body ]
+func (m *Message) EncodeWithAttributes(w io.Writer) error {
+	if len(m.ReplyTo) > MaxAttributeLength || len(m.CorrelationId) > MaxAttributeLength {
+		return errors.New("message attribute too long")
+	}
+
+	err := binary.Write(w, binary.BigEndian, &m.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.BigEndian, &m.Attempts)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(m.Id)
+	if err != nil {
+		return err
+	}
+
+	for _, attribute := range []string{m.ReplyTo, m.CorrelationId} {
+		_, err = w.Write(append([]byte{byte(len(attribute))}, attribute...))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.Write(m.Body)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DecodeMessageWithAttributes is the inverse of EncodeWithAttributes
+func DecodeMessageWithAttributes(byteBuf []byte) (*Message, error) {
+	headerLen := 8 + 2 + MsgIdLength
+	if len(byteBuf) < headerLen {
+		return nil, errors.New("invalid message")
+	}
+
+	var attributes [2]string
+	data := byteBuf[headerLen:]
+	for i := range attributes {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return nil, errors.New("invalid message attributes")
+		}
+		attributes[i] = string(data[1 : 1+int(data[0])])
+		data = data[1+int(data[0]):]
+	}
+
+	msg, err := DecodeMessage(append(byteBuf[:headerLen:headerLen], data...))
+	if err != nil {
+		return nil, err
+	}
+	msg.ReplyTo = attributes[0]
+	msg.CorrelationId = attributes[1]
+	return msg, nil
+}
+
 // DecodeMessage deseralizes data (as []byte) and creates/returns
 // a pointer to a new Message
 func DecodeMessage(byteBuf []byte) (*Message, error) {
@@ -95,6 +185,7 @@ func DecodeMessage(byteBuf []byte) (*Message, error) {
 
 	msg := NewMessage(id, body)
 	msg.Timestamp = timestamp
+	msg.TimestampNano = timestamp * int64(time.Second)
 	msg.Attempts = attempts
 
 	return msg, nil
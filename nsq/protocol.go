@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -20,11 +21,15 @@ const (
 	FrameTypeResponse int32 = 0
 	FrameTypeError    int32 = 1
 	FrameTypeMessage  int32 = 2
+
+	// a message encoded with its attributes (see Message.EncodeWithAttributes),
+	// only sent to clients that IDENTIFY with message_attributes
+	FrameTypeMessageWithAttributes int32 = 3
 )
 
 const DefaultClientTimeout = 60 * time.Second
 
-var validTopicNameRegex = regexp.MustCompile(`^[\.a-zA-Z0-9_-]+$`)
+var validTopicNameRegex = regexp.MustCompile(`^[\.a-zA-Z0-9_-]+(#ephemeral)?$`)
 var validChannelNameRegex = regexp.MustCompile(`^[\.a-zA-Z0-9_-]+(#ephemeral)?$`)
 
 func IsValidTopicName(name string) bool {
@@ -46,6 +51,15 @@ type Protocol interface {
 	IOLoop(conn net.Conn) error
 }
 
+// Dial connects to an nsqd or nsqlookupd, addr is either a TCP <addr>:<port>
+// or the path of a unix socket prefixed with "unix:"
+func Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		return net.DialTimeout("unix", strings.TrimPrefix(addr, "unix:"), timeout)
+	}
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
 func ReadMagic(r io.Reader) (int32, error) {
 	var protocolMagic int32
 
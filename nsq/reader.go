@@ -64,8 +64,8 @@ type nsqConn struct {
 }
 
 func newNSQConn(addr string, readTimeout time.Duration, writeTimeout time.Duration) (*nsqConn, error) {
-	// conn, err := net.DialTimeout("tcp", addr, time.Second)
-	_, err := net.DialTimeout("tcp", addr, time.Second)
+	// conn, err := Dial(addr, time.Second)
+	_, err := Dial(addr, time.Second)
 	if err != nil {
 		return nil, err
 	}
@@ -114,6 +114,7 @@ type Reader struct {
 	VerboseLogging      bool
 	ShortIdentifier     string // an identifier to send to nsqd when connecting (defaults: short hostname)
 	LongIdentifier      string // an identifier to send to nsqd when connecting (defaults: long hostname)
+	Weight              int    // relative share of messages to ask nsqd for (0 is the default weight)
 	ReadTimeout         time.Duration
 	WriteTimeout        time.Duration
 	MessagesReceived    uint64
@@ -316,7 +317,17 @@ func (q *Reader) ConnectToNSQ(addr string) error {
 		return err
 	}
 
-	err = connection.sendCommand(Subscribe(q.TopicName, q.ChannelName, q.ShortIdentifier, q.LongIdentifier))
+	err = connection.sendCommand(IdentifyMessageAttributes())
+	if err != nil {
+		connection.Close()
+		return fmt.Errorf("[%s] failed to identify - %s", addr, err.Error())
+	}
+
+	cmd := Subscribe(q.TopicName, q.ChannelName, q.ShortIdentifier, q.LongIdentifier)
+	if q.Weight > 0 {
+		cmd = SubscribeWithWeight(q.TopicName, q.ChannelName, q.ShortIdentifier, q.LongIdentifier, q.Weight)
+	}
+	err = connection.sendCommand(cmd)
 	if err != nil {
 		connection.Close()
 		return fmt.Errorf("[%s] failed to subscribe to %s:%s - %s", q.TopicName, q.ChannelName, err.Error())
@@ -379,8 +390,13 @@ func (q *Reader) readLoop(c *nsqConn) {
 		}
 
 		switch frameType {
-		case FrameTypeMessage:
-			msg, err := DecodeMessage(data)
+		case FrameTypeMessage, FrameTypeMessageWithAttributes:
+			var msg *Message
+			if frameType == FrameTypeMessageWithAttributes {
+				msg, err = DecodeMessageWithAttributes(data)
+			} else {
+				msg, err = DecodeMessage(data)
+			}
 			if err != nil {
 				handleError(q, c, fmt.Sprintf("[%s] error (%s) decoding message %s", c, err.Error(), data))
 				continue
@@ -0,0 +1,250 @@
+package nsq
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrRequestTimeout = errors.New("request timed out")
+
+// Requester publishes requests and waits for the matching reply
+//
+// requests carry the Requester's own (ephemeral) reply topic and a
+// correlation id as message attributes, so each Requester only receives
+// its own replies. The reply topic is deleted once the Requester stops.
+type Requester struct {
+	ReplyTopic string
+	Timeout    time.Duration // default time to wait for a reply
+
+	nsqdAddr  string
+	id        string
+	nextId    uint64
+	reader    *Reader
+	publisher *rpcPublisher
+
+	sync.Mutex
+	pending map[string]chan []byte
+}
+
+// NewRequester creates a Requester that publishes to and receives
+// replies from the nsqd at the given TCP address
+func NewRequester(nsqdAddr string) (*Requester, error) {
+	idBytes := make([]byte, 8)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(idBytes)
+
+	replyTopic := "rpc." + id + "#ephemeral"
+	reader, err := NewReader(replyTopic, "rpc#ephemeral")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Requester{
+		ReplyTopic: replyTopic,
+		Timeout:    10 * time.Second,
+		nsqdAddr:   nsqdAddr,
+		id:         id,
+		reader:     reader,
+		publisher:  &rpcPublisher{addr: nsqdAddr},
+		pending:    make(map[string]chan []byte),
+	}
+
+	reader.SetMaxInFlight(100)
+	reader.AddHandler(r)
+	err = reader.ConnectToNSQ(nsqdAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Request publishes body to topic and returns the reply payload
+func (r *Requester) Request(topic string, body []byte) ([]byte, error) {
+	return r.RequestTimeout(topic, body, r.Timeout)
+}
+
+// RequestTimeout publishes body to topic and returns the reply payload,
+// or ErrRequestTimeout if it isn't received within timeout
+func (r *Requester) RequestTimeout(topic string, body []byte, timeout time.Duration) ([]byte, error) {
+	correlationId := strconv.FormatUint(atomic.AddUint64(&r.nextId, 1), 10)
+
+	replyChan := make(chan []byte, 1)
+	r.Lock()
+	r.pending[correlationId] = replyChan
+	r.Unlock()
+
+	defer func() {
+		r.Lock()
+		delete(r.pending, correlationId)
+		r.Unlock()
+	}()
+
+	err := r.publisher.publish(PublishWithAttributes(topic, r.ReplyTopic, correlationId, body))
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replyChan:
+		return reply, nil
+	case <-time.After(timeout):
+		return nil, ErrRequestTimeout
+	}
+}
+
+// HandleMessage implements the Handler interface for replies
+func (r *Requester) HandleMessage(message *Message) error {
+	r.Lock()
+	replyChan, ok := r.pending[message.CorrelationId]
+	r.Unlock()
+	if ok {
+		// the channel is buffered, a duplicate reply is dropped
+		select {
+		case replyChan <- message.Body:
+		default:
+		}
+	}
+
+	// replies that arrived after a timeout are discarded
+	return nil
+}
+
+// Stop stops receiving replies and closes the publishing connection
+func (r *Requester) Stop() {
+	r.reader.Stop()
+	r.publisher.close()
+}
+
+// RequestHandler processes a request and returns the reply payload
+type RequestHandler interface {
+	HandleRequest(body []byte) ([]byte, error)
+}
+
+// Responder adapts a RequestHandler into a Handler (for use with a Reader)
+// that publishes the reply to the request's reply_to topic
+//
+// the reply topic only exists on the nsqd the Requester is connected to
+// (a reply published anywhere else, or after the Requester stopped, is
+// discarded) so the Responder must publish to that nsqd
+//
+// if the RequestHandler returns an error the request is requeued as usual
+type Responder struct {
+	handler   RequestHandler
+	publisher *rpcPublisher
+}
+
+// NewResponder creates a Responder that publishes replies to the nsqd
+// at the given TCP address
+func NewResponder(nsqdAddr string, handler RequestHandler) *Responder {
+	return &Responder{
+		handler:   handler,
+		publisher: &rpcPublisher{addr: nsqdAddr},
+	}
+}
+
+// HandleMessage implements the Handler interface
+func (r *Responder) HandleMessage(message *Message) error {
+	if !IsValidTopicName(message.ReplyTo) {
+		log.Printf("ERROR: responder received request %s with invalid reply_to '%s'", message.Id, message.ReplyTo)
+		return nil
+	}
+
+	reply, err := r.handler.HandleRequest(message.Body)
+	if err != nil {
+		return err
+	}
+
+	return r.publisher.publish(PublishWithAttributes(message.ReplyTo, "", message.CorrelationId, reply))
+}
+
+// Close closes the publishing connection
+func (r *Responder) Close() {
+	r.publisher.close()
+}
+
+// rpcPublisher is a minimal, lazily (re)connected, V2 publishing connection
+type rpcPublisher struct {
+	sync.Mutex
+	addr string
+	conn net.Conn
+}
+
+func (p *rpcPublisher) publish(cmd *Command) error {
+	p.Lock()
+	defer p.Unlock()
+
+	// nsqd closes idle connections so retry once on a fresh connection
+	var err error
+	for i := 0; i < 2; i++ {
+		err = p.doPublish(cmd)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*ClientErr); ok {
+			return err
+		}
+		if p.conn != nil {
+			p.conn.Close()
+			p.conn = nil
+		}
+	}
+	return err
+}
+
+func (p *rpcPublisher) doPublish(cmd *Command) error {
+	if p.conn == nil {
+		conn, err := Dial(p.addr, time.Second)
+		if err != nil {
+			return err
+		}
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		_, err = conn.Write(MagicV2)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("[%s] failed to write magic - %s", p.addr, err.Error())
+		}
+		p.conn = conn
+	}
+
+	p.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	err := SendCommand(p.conn, cmd)
+	if err != nil {
+		return err
+	}
+
+	resp, err := ReadResponse(p.conn)
+	if err != nil {
+		return err
+	}
+
+	frameType, data, err := UnpackResponse(resp)
+	if err != nil {
+		return err
+	}
+	if frameType == FrameTypeError {
+		return NewClientErr(string(data), "publish failed")
+	}
+
+	return nil
+}
+
+func (p *rpcPublisher) close() {
+	p.Lock()
+	defer p.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
@@ -0,0 +1,49 @@
+package nsq
+
+import (
+	"bytes"
+	"github.com/bmizerany/assert"
+	"testing"
+)
+
+func TestMessageAttributes(t *testing.T) {
+	msg := NewMessage([]byte("0123456789abcdef"), []byte("payload"))
+	msg.ReplyTo = "rpc.replies#ephemeral"
+	msg.CorrelationId = "1"
+	assert.Equal(t, msg.HasAttributes(), true)
+
+	var buf bytes.Buffer
+	err := msg.EncodeWithAttributes(&buf)
+	assert.Equal(t, err, nil)
+
+	decodedMsg, err := DecodeMessageWithAttributes(buf.Bytes())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, decodedMsg.Id, msg.Id)
+	assert.Equal(t, decodedMsg.Body, []byte("payload"))
+	assert.Equal(t, decodedMsg.ReplyTo, "rpc.replies#ephemeral")
+	assert.Equal(t, decodedMsg.CorrelationId, "1")
+
+	_, err = DecodeMessageWithAttributes(buf.Bytes()[:30])
+	assert.NotEqual(t, err, nil)
+
+	assert.Equal(t, NewMessage([]byte("0123456789abcdef"), nil).HasAttributes(), false)
+}
+
+func TestRequesterHandleMessage(t *testing.T) {
+	r := &Requester{pending: make(map[string]chan []byte)}
+	replyChan := make(chan []byte, 1)
+	r.pending["1"] = replyChan
+
+	msg := NewMessage([]byte("0123456789abcdef"), []byte("reply"))
+	msg.CorrelationId = "1"
+	err := r.HandleMessage(msg)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, <-replyChan, []byte("reply"))
+
+	// unknown correlation ids are discarded
+	msg = NewMessage([]byte("0123456789abcdef"), []byte("reply"))
+	msg.CorrelationId = "2"
+	err = r.HandleMessage(msg)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(replyChan), 0)
+}
@@ -60,8 +60,12 @@ func httpServer(listener net.Listener) {
 	handler.HandleFunc("/empty_channel", emptyChannelHandler)
 	handler.HandleFunc("/pause_channel", pauseChannelHandler)
 	handler.HandleFunc("/unpause_channel", pauseChannelHandler)
+	handler.HandleFunc("/kick_client", clientActionHandler)
+	handler.HandleFunc("/drain_client", clientActionHandler)
+	handler.HandleFunc("/undrain_client", clientActionHandler)
 	handler.HandleFunc("/counter/data", counterDataHandler)
 	handler.HandleFunc("/counter", counterHandler)
+	handler.HandleFunc("/audit", auditHandler)
 
 	server := &http.Server{
 		Handler: handler,
@@ -82,10 +86,11 @@ func pingHandler(w http.ResponseWriter, req *http.Request) {
 
 func indexHandler(w http.ResponseWriter, req *http.Request) {
 	var topics []string
-	if len(lookupdHTTPAddrs) != 0 {
-		topics, _ = getLookupdTopics(lookupdHTTPAddrs)
+	lookupdAddrs := getLookupdHTTPAddrs()
+	if len(lookupdAddrs) != 0 {
+		topics, _ = getLookupdTopics(lookupdAddrs)
 	} else {
-		topics, _ = getNSQDTopics(nsqdHTTPAddrs)
+		topics, _ = getNSQDTopics(getNSQDHTTPAddrs())
 	}
 	p := struct {
 		Title   string
@@ -117,12 +122,7 @@ func topicHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	var producers []string
-	if len(lookupdHTTPAddrs) != 0 {
-		producers, _ = getLookupdTopicProducers(topic, lookupdHTTPAddrs)
-	} else {
-		producers, _ = getNsqdTopicProducers(topic, nsqdHTTPAddrs)
-	}
+	producers := getTopicProducers(topic)
 	topicHostStats, channelStats, _ := getNSQDStats(producers, topic)
 
 	globalTopicStats := &TopicHostStats{HostAddress: "Total"}
@@ -154,13 +154,21 @@ func topicHandler(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func channelHandler(w http.ResponseWriter, req *http.Request, topic string, channel string) {
+// getTopicProducers returns the HTTP addresses of the nsqd that have the topic
+// (from nsqlookupd or the --nsqd-http-address list)
+func getTopicProducers(topic string) []string {
 	var producers []string
-	if len(lookupdHTTPAddrs) != 0 {
-		producers, _ = getLookupdTopicProducers(topic, lookupdHTTPAddrs)
+	lookupdAddrs := getLookupdHTTPAddrs()
+	if len(lookupdAddrs) != 0 {
+		producers, _ = getLookupdTopicProducers(topic, lookupdAddrs)
 	} else {
-		producers, _ = getNsqdTopicProducers(topic, nsqdHTTPAddrs)
+		producers, _ = getNsqdTopicProducers(topic, getNSQDHTTPAddrs())
 	}
+	return producers
+}
+
+func channelHandler(w http.ResponseWriter, req *http.Request, topic string, channel string) {
+	producers := getTopicProducers(topic)
 	_, allChannelStats, _ := getNSQDStats(producers, topic)
 	channelStats := allChannelStats[channel]
 
@@ -202,10 +210,10 @@ func deleteTopicHandler(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// for topic removal, you need to get all the producers *first*
-	producers, _ := getLookupdTopicProducers(topicName, lookupdHTTPAddrs)
+	producers, _ := getLookupdTopicProducers(topicName, getLookupdHTTPAddrs())
 
 	// remove the topic from all the lookupds
-	for _, addr := range lookupdHTTPAddrs {
+	for _, addr := range getLookupdHTTPAddrs() {
 		endpoint := fmt.Sprintf("http://%s/delete_topic?topic=%s", addr, url.QueryEscape(topicName))
 		log.Printf("LOOKUPD: querying %s", endpoint)
 
@@ -220,7 +228,7 @@ func deleteTopicHandler(w http.ResponseWriter, req *http.Request) {
 	for _, addr := range producers {
 		endpoint := fmt.Sprintf("http://%s/delete_topic?topic=%s", addr, url.QueryEscape(topicName))
 		log.Printf("NSQD: querying %s", endpoint)
-		_, err := nsq.ApiRequest(endpoint)
+		_, err := nsq.ApiRequestWithHeader(endpoint, actorHeader(req))
 		if err != nil {
 			log.Printf("ERROR: nsqd %s - %s", endpoint, err.Error())
 			continue
@@ -244,7 +252,7 @@ func deleteChannelHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	for _, addr := range lookupdHTTPAddrs {
+	for _, addr := range getLookupdHTTPAddrs() {
 		endpoint := fmt.Sprintf("http://%s/delete_channel?topic=%s&channel=%s", addr, url.QueryEscape(topicName), url.QueryEscape(channelName))
 		log.Printf("LOOKUPD: querying %s", endpoint)
 
@@ -255,11 +263,11 @@ func deleteChannelHandler(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	producers, _ := getLookupdTopicProducers(topicName, lookupdHTTPAddrs)
+	producers, _ := getLookupdTopicProducers(topicName, getLookupdHTTPAddrs())
 	for _, addr := range producers {
 		endpoint := fmt.Sprintf("http://%s/delete_channel?topic=%s&channel=%s", addr, url.QueryEscape(topicName), url.QueryEscape(channelName))
 		log.Printf("NSQD: querying %s", endpoint)
-		_, err := nsq.ApiRequest(endpoint)
+		_, err := nsq.ApiRequestWithHeader(endpoint, actorHeader(req))
 		if err != nil {
 			log.Printf("ERROR: nsqd %s - %s", endpoint, err.Error())
 			continue
@@ -283,12 +291,12 @@ func emptyChannelHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	producers, _ := getLookupdTopicProducers(topicName, lookupdHTTPAddrs)
+	producers, _ := getLookupdTopicProducers(topicName, getLookupdHTTPAddrs())
 	for _, addr := range producers {
 		endpoint := fmt.Sprintf("http://%s/empty_channel?topic=%s&channel=%s", addr, url.QueryEscape(topicName), url.QueryEscape(channelName))
 		log.Printf("NSQD: calling %s", endpoint)
 
-		_, err := nsq.ApiRequest(endpoint)
+		_, err := nsq.ApiRequestWithHeader(endpoint, actorHeader(req))
 		if err != nil {
 			log.Printf("ERROR: nsqd %s - %s", endpoint, err.Error())
 			continue
@@ -312,12 +320,12 @@ func pauseChannelHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	producers, _ := getLookupdTopicProducers(topicName, lookupdHTTPAddrs)
+	producers, _ := getLookupdTopicProducers(topicName, getLookupdHTTPAddrs())
 	for _, addr := range producers {
 		endpoint := fmt.Sprintf("http://%s%s?topic=%s&channel=%s", addr, req.URL.Path, url.QueryEscape(topicName), url.QueryEscape(channelName))
 		log.Printf("NSQD: calling %s", endpoint)
 
-		_, err := nsq.ApiRequest(endpoint)
+		_, err := nsq.ApiRequestWithHeader(endpoint, actorHeader(req))
 		if err != nil {
 			log.Printf("ERROR: nsqd %s - %s", endpoint, err.Error())
 			continue
@@ -327,8 +335,130 @@ func pauseChannelHandler(w http.ResponseWriter, req *http.Request) {
 	http.Redirect(w, req, fmt.Sprintf("/topic/%s/%s", url.QueryEscape(topicName), url.QueryEscape(channelName)), 302)
 }
 
+// clientActionHandler proxies kick/drain/undrain of a client to
+// the nsqd it's connected to
+func clientActionHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		http.Error(w, "INVALID_REQUEST", 500)
+		return
+	}
+
+	topicName, channelName, err := util.GetTopicChannelArgs(reqParams)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	addr, err := reqParams.Query("host")
+	if err != nil {
+		http.Error(w, "MISSING_ARG_HOST", 500)
+		return
+	}
+
+	// only ever proxy to a nsqd that has the topic
+	if util.StringIndex(getTopicProducers(topicName), addr) == -1 {
+		http.Error(w, "INVALID_ARG_HOST", 500)
+		return
+	}
+
+	id, err := reqParams.Query("id")
+	if err != nil {
+		http.Error(w, "MISSING_ARG_ID", 500)
+		return
+	}
+
+	// /kick_client => /channel/client/kick
+	action := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/"), "_client")
+	endpoint := fmt.Sprintf("http://%s/channel/client/%s?topic=%s&channel=%s&id=%s", addr, action,
+		url.QueryEscape(topicName), url.QueryEscape(channelName), url.QueryEscape(id))
+	log.Printf("NSQD: calling %s", endpoint)
+
+	_, err = nsq.ApiRequestWithHeader(endpoint, actorHeader(req))
+	if err != nil {
+		log.Printf("ERROR: nsqd %s - %s", endpoint, err.Error())
+	}
+
+	http.Redirect(w, req, fmt.Sprintf("/topic/%s/%s", url.QueryEscape(topicName), url.QueryEscape(channelName)), 302)
+}
+
+// actorHeader identifies who is performing an action proxied to nsqd (for
+// its audit log), the user is taken from HTTP basic auth or the
+// X-Forwarded-User header set by an authenticating proxy in front of nsqadmin
+//
+// nsqadmin doesn't authenticate anyone itself so these (and X-Forwarded-For)
+// are only believed from a --trusted-proxy
+func actorHeader(req *http.Request) http.Header {
+	header := make(http.Header)
+
+	remoteAddr, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remoteAddr = req.RemoteAddr
+	}
+	if !trustedProxies.Contains(req.RemoteAddr) {
+		header.Set("X-Forwarded-For", remoteAddr)
+		return header
+	}
+
+	if forwardedFor := req.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		remoteAddr = forwardedFor + ", " + remoteAddr
+	}
+	header.Set("X-Forwarded-For", remoteAddr)
+
+	user, _, ok := req.BasicAuth()
+	if !ok {
+		user = req.Header.Get("X-Forwarded-User")
+	}
+	if user != "" {
+		header.Set("X-NSQ-User", user)
+	}
+
+	return header
+}
+
+func auditHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		http.Error(w, "INVALID_REQUEST", 500)
+		return
+	}
+
+	topicName, _ := reqParams.Query("topic")
+
+	var addresses []string
+	lookupdAddrs := getLookupdHTTPAddrs()
+	if len(lookupdAddrs) != 0 {
+		producers, _ := getLookupdProducers(lookupdAddrs)
+		for _, p := range producers {
+			addresses = append(addresses, p.HTTPAddress())
+		}
+	} else {
+		addresses = getNSQDHTTPAddrs()
+	}
+	entries, _ := getNSQDAuditEntries(addresses, topicName, 100)
+
+	p := struct {
+		Title   string
+		Version string
+		Topic   string
+		Entries []*AuditEntry
+	}{
+		Title:   "NSQ Audit Log",
+		Version: util.BINARY_VERSION,
+		Topic:   topicName,
+		Entries: entries,
+	}
+	err = templates.ExecuteTemplate(w, "audit.html", p)
+	if err != nil {
+		log.Printf("Template Error %s", err.Error())
+		http.Error(w, "Template Error", 500)
+	}
+}
+
 func nodesHandler(w http.ResponseWriter, req *http.Request) {
-	producers, _ := getLookupdProducers(lookupdHTTPAddrs)
+	producers, _ := getLookupdProducers(getLookupdHTTPAddrs())
 
 	p := struct {
 		Title     string
@@ -391,7 +521,7 @@ func counterDataHandler(w http.ResponseWriter, req *http.Request) {
 	newStats := make(map[string]int64)
 	newStats["time"] = now.Unix()
 
-	producers, _ := getLookupdProducers(lookupdHTTPAddrs)
+	producers, _ := getLookupdProducers(getLookupdHTTPAddrs())
 	addresses := make([]string, len(producers))
 	for i, p := range producers {
 		addresses[i] = p.HTTPAddress()
@@ -242,6 +242,9 @@ func getNSQDStats(nsqdHTTPAddrs []string, selectedTopic string) ([]*TopicHostSta
 	for _, addr := range nsqdHTTPAddrs {
 		wg.Add(1)
 		endpoint := fmt.Sprintf("http://%s/stats?format=json", addr)
+		if selectedTopic != "" {
+			endpoint += "&topic=" + url.QueryEscape(selectedTopic)
+		}
 		log.Printf("NSQD: querying %s", endpoint)
 
 		go func(endpoint string, addr string) {
@@ -307,6 +310,17 @@ func getNSQDStats(nsqdHTTPAddrs []string, selectedTopic string) ([]*TopicHostSta
 					h.MessageCount = int64(c["message_count"].(float64))
 					h.RequeueCount = int64(c["requeue_count"].(float64))
 					h.TimeoutCount = int64(c["timeout_count"].(float64))
+					h.E2eProcessingLatency = parseLatencyStats(c["e2e_processing_latency"])
+					h.InFlightLatency = parseLatencyStats(c["in_flight_latency"])
+					memoryAge, _ := c["oldest_memory_age"].(float64)
+					backendAge, _ := c["oldest_backend_age"].(float64)
+					h.OldestAge = time.Duration(memoryAge)
+					if backendAge > memoryAge {
+						h.OldestAge = time.Duration(backendAge)
+					}
+					h.FinishRate, _ = c["finish_rate"].(float64)
+					drainETA, _ := c["drain_eta"].(float64)
+					h.DrainETA = time.Duration(drainETA)
 					clients := c["clients"].([]interface{})
 					// TODO: this is sort of wrong; client's should be de-duped
 					// client A that connects to NSQD-a and NSQD-b should only be counted once. right?
@@ -315,6 +329,7 @@ func getNSQDStats(nsqdHTTPAddrs []string, selectedTopic string) ([]*TopicHostSta
 
 					// "clients": [
 					//   {
+					//     "id": 12,
 					//     "version": "V2",
 					//     "remote_address": "127.0.0.1:49700",
 					//     "name": "jehiah-air",
@@ -324,14 +339,24 @@ func getNSQDStats(nsqdHTTPAddrs []string, selectedTopic string) ([]*TopicHostSta
 					//     "message_count": 0,
 					//     "finish_count": 0,
 					//     "requeue_count": 0,
-					//     "connect_ts": 1347150965
+					//     "connect_ts": 1347150965,
+					//     "drained": false
 					//   }
 					// ]
 					for _, client := range clients {
 						client := client.(map[string]interface{})
 						connected := time.Unix(int64(client["connect_ts"].(float64)), 0)
 						connectedDuration := time.Now().Sub(connected).Seconds()
+						var id int64
+						if idInterface, ok := client["id"]; ok {
+							id = int64(idInterface.(float64))
+						}
+						var drained bool
+						if drainedInterface, ok := client["drained"]; ok {
+							drained = drainedInterface.(bool)
+						}
 						clientInfo := &ClientInfo{
+							ID:                id,
 							HostAddress:       addr,
 							ClientVersion:     client["version"].(string),
 							ClientIdentifier:  fmt.Sprintf("%s:%s", client["name"].(string), strings.Split(client["remote_address"].(string), ":")[1]),
@@ -341,6 +366,7 @@ func getNSQDStats(nsqdHTTPAddrs []string, selectedTopic string) ([]*TopicHostSta
 							FinishCount:       int64(client["finish_count"].(float64)),
 							RequeueCount:      int64(client["requeue_count"].(float64)),
 							MessageCount:      int64(client["message_count"].(float64)),
+							Drained:           drained,
 						}
 						channel.Clients = append(channel.Clients, clientInfo)
 					}
@@ -357,3 +383,78 @@ func getNSQDStats(nsqdHTTPAddrs []string, selectedTopic string) ([]*TopicHostSta
 	return topicHostStats, channelStats, nil
 
 }
+
+// parseLatencyStats parses the latency percentiles of a channel's stats
+// (nil for a nsqd that doesn't report them)
+func parseLatencyStats(v interface{}) *LatencyStats {
+	latency, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	stats := &LatencyStats{}
+	if count, ok := latency["count"].(float64); ok {
+		stats.Count = int64(count)
+	}
+	percentiles, _ := latency["percentiles"].([]interface{})
+	for _, p := range percentiles {
+		p, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		quantile, _ := p["quantile"].(float64)
+		value, _ := p["value"].(float64)
+		stats.Percentiles = append(stats.Percentiles, LatencyPercentile{quantile, time.Duration(value)})
+	}
+	return stats
+}
+
+// getNSQDAuditEntries returns the (at most `limit` per nsqd) most recent
+// audit entries of each nsqd, optionally only those for selectedTopic
+func getNSQDAuditEntries(nsqdHTTPAddrs []string, selectedTopic string, limit int) ([]*AuditEntry, error) {
+	entries := make([]*AuditEntry, 0)
+	success := false
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, addr := range nsqdHTTPAddrs {
+		wg.Add(1)
+		endpoint := fmt.Sprintf("http://%s/audit?topic=%s&limit=%d", addr, url.QueryEscape(selectedTopic), limit)
+		log.Printf("NSQD: querying %s", endpoint)
+
+		go func(endpoint string, addr string) {
+			data, err := nsq.ApiRequest(endpoint)
+			lock.Lock()
+			defer lock.Unlock()
+			defer wg.Done()
+			if err != nil {
+				log.Printf("ERROR: nsqd %s - %s", endpoint, err.Error())
+				return
+			}
+			success = true
+			entryList, _ := data.Get("entries").Array()
+			for _, entryInfo := range entryList {
+				entryInfo := entryInfo.(map[string]interface{})
+				entry := &AuditEntry{
+					HostAddress:   addr,
+					Timestamp:     int64(entryInfo["timestamp"].(float64)),
+					RemoteAddress: entryInfo["remote_address"].(string),
+					Action:        entryInfo["action"].(string),
+					Topic:         entryInfo["topic"].(string),
+					StatusCode:    int(entryInfo["status_code"].(float64)),
+					Result:        entryInfo["result"].(string),
+				}
+				// omitted when empty
+				entry.Via, _ = entryInfo["via"].(string)
+				entry.User, _ = entryInfo["user"].(string)
+				entry.Channel, _ = entryInfo["channel"].(string)
+				entry.Client, _ = entryInfo["client"].(string)
+				entries = append(entries, entry)
+			}
+		}(endpoint, addr)
+	}
+	wg.Wait()
+	sort.Sort(AuditEntriesByTime(entries))
+	if success == false {
+		return nil, errors.New("unable to query any nsqd")
+	}
+	return entries, nil
+}
@@ -2,24 +2,75 @@ package main
 
 import (
 	"../util"
+	"errors"
 	"flag"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 var (
-	showVersion      = flag.Bool("version", false, "print version string")
-	httpAddress      = flag.String("http-address", "0.0.0.0:4171", "<addr>:<port> to listen on for HTTP clients")
-	templateDir      = flag.String("template-dir", "", "path to templates directory")
-	lookupdHTTPAddrs = util.StringArray{}
-	nsqdHTTPAddrs    = util.StringArray{}
+	showVersion       = flag.Bool("version", false, "print version string")
+	httpAddress       = flag.String("http-address", "0.0.0.0:4171", "<addr>:<port> to listen on for HTTP clients")
+	templateDir       = flag.String("template-dir", "", "path to templates directory")
+	config            = flag.String("config", "", "path to a JSON config file (SIGHUP reloads it)")
+	lagThreshold      = flag.Duration("lag-threshold", time.Minute, "highlight channels whose oldest message (or time to drain) is over this")
+	lookupdHTTPAddrs  = util.StringArray{}
+	nsqdHTTPAddrs     = util.StringArray{}
+	trustedProxyAddrs = util.StringArray{}
 )
 
+// the proxies in front of nsqadmin whose forwarding headers are believed
+var trustedProxies util.TrustedProxies
+
 func init() {
 	flag.Var(&lookupdHTTPAddrs, "lookupd-http-address", "lookupd HTTP address (may be given multiple times)")
 	flag.Var(&nsqdHTTPAddrs, "nsqd-http-address", "nsqd HTTP address (may be given multiple times)")
+	flag.Var(&trustedProxyAddrs, "trusted-proxy", "IP or CIDR range of an authenticating proxy whose X-Forwarded-For, X-Forwarded-User and basic auth are forwarded to nsqd (may be given multiple times)")
+}
+
+// the flags a config reload applies at runtime
+var reloadableFlags = []string{"lookupd-http-address", "nsqd-http-address"}
+
+// the addresses the HTTP handlers query, the flags are only
+// read by main (a config reload changes them)
+var httpAddrs struct {
+	sync.RWMutex
+	lookupd []string
+	nsqd    []string
+}
+
+func getLookupdHTTPAddrs() []string {
+	httpAddrs.RLock()
+	defer httpAddrs.RUnlock()
+	return httpAddrs.lookupd
+}
+
+func getNSQDHTTPAddrs() []string {
+	httpAddrs.RLock()
+	defer httpAddrs.RUnlock()
+	return httpAddrs.nsqd
+}
+
+func setHTTPAddrs() {
+	httpAddrs.Lock()
+	httpAddrs.lookupd = append([]string{}, lookupdHTTPAddrs...)
+	httpAddrs.nsqd = append([]string{}, nsqdHTTPAddrs...)
+	httpAddrs.Unlock()
+}
+
+func validateHTTPAddrs() error {
+	if len(nsqdHTTPAddrs) == 0 && len(lookupdHTTPAddrs) == 0 {
+		return errors.New("--nsqd-http-address or --lookupd-http-address required.")
+	}
+	if len(nsqdHTTPAddrs) != 0 && len(lookupdHTTPAddrs) != 0 {
+		return errors.New("use --nsqd-http-address or --lookupd-http-address not both")
+	}
+	return nil
 }
 
 func main() {
@@ -32,6 +83,15 @@ func main() {
 		return
 	}
 
+	var configFile *util.ConfigFile
+	if *config != "" {
+		configFile = util.NewConfigFile(*config, flag.CommandLine)
+		err := configFile.Load()
+		if err != nil {
+			log.Fatalf("FATAL: failed to load config - %s", err.Error())
+		}
+	}
+
 	if *templateDir == "" {
 		for _, defaultPath := range []string{"templates", "/usr/local/share/nsqadmin/templates"} {
 			if info, err := os.Stat(defaultPath); err == nil && info.IsDir() {
@@ -45,12 +105,15 @@ func main() {
 		log.Fatalf("--template-dir must be specified (or install the templates to /usr/local/share/nsqadmin/templates)")
 	}
 
-	if len(nsqdHTTPAddrs) == 0 && len(lookupdHTTPAddrs) == 0 {
-		log.Fatalf("--nsqd-http-address or --lookupd-http-address required.")
+	err := validateHTTPAddrs()
+	if err != nil {
+		log.Fatal(err)
 	}
+	setHTTPAddrs()
 
-	if len(nsqdHTTPAddrs) != 0 && len(lookupdHTTPAddrs) != 0 {
-		log.Fatalf("use --nsqd-http-address or --lookupd-http-address not both")
+	trustedProxies, err = util.NewTrustedProxies(trustedProxyAddrs)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	exitChan := make(chan int)
@@ -73,9 +136,34 @@ func main() {
 	}
 	waitGroup.Wrap(func() { httpServer(httpListener) })
 
+	if configFile != nil {
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		go func() {
+			for _ = range hupChan {
+				reloadConfig(configFile)
+			}
+		}()
+	}
+
 	<-exitChan
 
 	httpListener.Close()
 
 	waitGroup.Wait()
 }
+
+// reloadConfig re-reads the config file, applying the settings that can be
+// changed at runtime (the others require a restart)
+func reloadConfig(configFile *util.ConfigFile) {
+	log.Printf("NSQADMIN: reloading config")
+	err := configFile.Reload(reloadableFlags)
+	if err == nil {
+		err = validateHTTPAddrs()
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to reload config - %s", err.Error())
+		return
+	}
+	setHTTPAddrs()
+}
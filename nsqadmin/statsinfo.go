@@ -50,9 +50,55 @@ type ChannelStats struct {
 	HostStats     []*ChannelStats
 	Clients       []*ClientInfo
 	Paused        bool
+
+	// only set for a single nsqd (percentiles can't be summed)
+	E2eProcessingLatency *LatencyStats
+	InFlightLatency      *LatencyStats
+
+	// the oldest queued message and time to drain of the slowest nsqd
+	// (DrainETA is -1 when a nsqd with messages queued isn't finishing any)
+	OldestAge  time.Duration
+	FinishRate float64
+	DrainETA   time.Duration
+}
+
+// LatencyStats are the latency percentiles a nsqd estimated for a channel
+type LatencyStats struct {
+	Count       int64
+	Percentiles []LatencyPercentile
+}
+
+type LatencyPercentile struct {
+	Quantile float64
+	Value    time.Duration
+}
+
+func (l *LatencyStats) String() string {
+	parts := make([]string, len(l.Percentiles))
+	for i, p := range l.Percentiles {
+		parts[i] = fmt.Sprintf("p%v: %s", p.Quantile*100, roundDuration(p.Value))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// roundDuration keeps ~3 significant digits of a duration
+func roundDuration(d time.Duration) time.Duration {
+	var unit time.Duration
+	switch {
+	case d >= 100*time.Second:
+		unit = time.Second
+	case d >= time.Second:
+		unit = 10 * time.Millisecond
+	case d >= time.Millisecond:
+		unit = 10 * time.Microsecond
+	default:
+		unit = time.Microsecond
+	}
+	return d / unit * unit
 }
 
 type ClientInfo struct {
+	ID                int64
 	HostAddress       string
 	ClientVersion     string
 	ClientIdentifier  string
@@ -62,6 +108,26 @@ type ClientInfo struct {
 	FinishCount       int64
 	RequeueCount      int64
 	MessageCount      int64
+	Drained           bool
+}
+
+// AuditEntry is an administrative action recorded by a nsqd
+type AuditEntry struct {
+	HostAddress   string
+	Timestamp     int64
+	RemoteAddress string
+	Via           string
+	User          string
+	Action        string
+	Topic         string
+	Channel       string
+	Client        string
+	StatusCode    int
+	Result        string
+}
+
+func (a *AuditEntry) Time() time.Time {
+	return time.Unix(a.Timestamp, 0)
 }
 
 type ChannelStatsList []*ChannelStats
@@ -77,6 +143,7 @@ type TopicHostStatsByHost struct {
 	TopicHostStatsList
 }
 type ProducerList []*Producer
+type AuditEntriesByTime []*AuditEntry
 type ProducersByHost struct {
 	ProducerList
 }
@@ -90,6 +157,11 @@ func (t TopicHostStatsList) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
 func (t ProducerList) Len() int            { return len(t) }
 func (t ProducerList) Swap(i, j int)       { t[i], t[j] = t[j], t[i] }
 
+// AuditEntriesByTime sorts the most recent first
+func (a AuditEntriesByTime) Len() int           { return len(a) }
+func (a AuditEntriesByTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a AuditEntriesByTime) Less(i, j int) bool { return a[i].Timestamp > a[j].Timestamp }
+
 func (c ChannelStatsByHost) Less(i, j int) bool {
 	return c.ChannelStatsList[i].HostAddress < c.ChannelStatsList[j].HostAddress
 }
@@ -119,10 +191,55 @@ func (c *ChannelStats) AddHostStats(a *ChannelStats) {
 	if a.Paused {
 		c.Paused = a.Paused
 	}
+	if a.OldestAge > c.OldestAge {
+		c.OldestAge = a.OldestAge
+	}
+	c.FinishRate += a.FinishRate
+	if c.DrainETA >= 0 && (a.DrainETA < 0 || a.DrainETA > c.DrainETA) {
+		c.DrainETA = a.DrainETA
+	}
 	c.HostStats = append(c.HostStats, a)
 	sort.Sort(ChannelStatsByHost{c.HostStats})
 }
 
+// Lagging returns true if the channel's oldest queued message, or the time it
+// would take to drain, is over --lag-threshold (or it isn't draining at all)
+func (c *ChannelStats) Lagging() bool {
+	if c.Depth == 0 {
+		return false
+	}
+	return c.DrainETA < 0 || c.OldestAge > *lagThreshold || c.DrainETA > *lagThreshold
+}
+
+// OldestAgeString rounds OldestAge for display
+func (c *ChannelStats) OldestAgeString() string {
+	if c.Depth == 0 {
+		return "-"
+	}
+	return roundDuration(c.OldestAge).String()
+}
+
+// DrainETAString rounds DrainETA for display
+func (c *ChannelStats) DrainETAString() string {
+	switch {
+	case c.Depth == 0:
+		return "-"
+	case c.DrainETA < 0:
+		return "never"
+	}
+	return roundDuration(c.DrainETA).String()
+}
+
+// HasLatency returns true if any nsqd reported the channel's latency percentiles
+func (c *ChannelStats) HasLatency() bool {
+	for _, h := range c.HostStats {
+		if h.E2eProcessingLatency != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *TopicHostStats) AddHostStats(a *TopicHostStats) {
 	t.Depth += a.Depth
 	t.MemoryDepth += a.MemoryDepth
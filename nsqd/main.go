@@ -1,42 +1,74 @@
 package main
 
 import (
-	"../nsq"
 	"../util"
-	"crypto/md5"
+	"./nsqd"
 	"flag"
 	"fmt"
-	"hash/crc32"
-	"io"
 	"log"
-	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
 
 var (
-	showVersion     = flag.Bool("version", false, "print version string")
-	httpAddress     = flag.String("http-address", "0.0.0.0:4151", "<addr>:<port> to listen on for HTTP clients")
-	tcpAddress      = flag.String("tcp-address", "0.0.0.0:4150", "<addr>:<port> to listen on for TCP clients")
-	debugMode       = flag.Bool("debug", false, "enable debug mode")
-	memQueueSize    = flag.Int64("mem-queue-size", 10000, "number of messages to keep in memory (per topic)")
-	maxBytesPerFile = flag.Int64("max-bytes-per-file", 104857600, "number of bytes per diskqueue file before rolling")
-	syncEvery       = flag.Int64("sync-every", 2500, "number of messages between diskqueue syncs")
-	msgTimeoutMs    = flag.Int64("msg-timeout", 60000, "time (ms) to wait before auto-requeing a message")
-	dataPath        = flag.String("data-path", "", "path to store disk-backed messages")
-	workerId        = flag.Int64("worker-id", 0, "unique identifier (int) for this worker (will default to a hash of hostname)")
-	verbose         = flag.Bool("verbose", false, "enable verbose logging")
-	lookupdTCPAddrs = util.StringArray{}
+	showVersion       = flag.Bool("version", false, "print version string")
+	config            = flag.String("config", "", "path to a JSON config file (SIGHUP reloads it)")
+	httpAddress       = flag.String("http-address", "0.0.0.0:4151", "<addr>:<port> to listen on for HTTP clients")
+	tcpAddress        = flag.String("tcp-address", "0.0.0.0:4150", "<addr>:<port> to listen on for TCP clients")
+	httpSocket        = flag.String("http-socket", "", "path of a unix socket to listen on for HTTP clients")
+	tcpSocket         = flag.String("tcp-socket", "", "path of a unix socket to listen on for TCP clients")
+	debugMode         = flag.Bool("debug", false, "enable debug mode")
+	memQueueSize      = flag.Int64("mem-queue-size", 10000, "number of messages to keep in memory (per topic)")
+	memBudget         = flag.Int64("mem-budget", 0, "max number of bytes of messages kept in memory across all topics and channels (0 for unlimited)")
+	maxBytesPerFile   = flag.Int64("max-bytes-per-file", 104857600, "number of bytes per diskqueue file before rolling")
+	syncEvery         = flag.Int64("sync-every", 2500, "number of messages between diskqueue syncs")
+	msgTimeoutMs      = flag.Int64("msg-timeout", 60000, "time (ms) to wait before auto-requeing a message")
+	dataPath          = flag.String("data-path", "", "path to store disk-backed messages")
+	workerId          = flag.Int64("worker-id", 0, "unique identifier (int, up to 65535) for this worker (will default to a hash of hostname)")
+	verbose           = flag.Bool("verbose", false, "enable verbose logging")
+	retentionWindow   = flag.Duration("retention-window", 0, "duration to retain published messages for rewind/replay (0 disables)")
+	maxTopicDepth     = flag.Int64("max-topic-depth", 0, "max number of messages queued per topic (0 for unlimited)")
+	maxTopicBytes     = flag.Int64("max-topic-bytes", 0, "max number of bytes queued per topic (0 for unlimited)")
+	maxChannelDepth   = flag.Int64("max-channel-depth", 0, "max number of messages queued per channel (0 for unlimited)")
+	maxChannelBytes   = flag.Int64("max-channel-bytes", 0, "max number of bytes queued per channel (0 for unlimited)")
+	depthPolicy       = flag.String("depth-policy", "reject", "what to do when a max depth is reached (reject, drop-oldest, drop-newest)")
+	publishDurability = flag.String("publish-durability", "none", "when to acknowledge a publish (none, ack, fsync)")
+	diskLowWatermark  = flag.Uint64("disk-low-watermark", 0, "bytes free on the data path below which publishes are rejected (0 disables)")
+	diskHighWatermark = flag.Uint64("disk-high-watermark", 0, "bytes free on the data path above which publishes are accepted again")
+	dispatchPolicy    = flag.String("dispatch-policy", "round-robin", "how messages are distributed across a channel's clients (round-robin, least-in-flight, weighted)")
+	lookupdTCPAddrs   = util.StringArray{}
+	topicRetention    = util.StringArray{}
+
+	outputBufferSize       = flag.Int64("output-buffer-size", 16*1024, "default size (bytes) of a client's output buffer (0 disables buffering)")
+	outputBufferTimeout    = flag.Duration("output-buffer-timeout", 250*time.Millisecond, "default max time a client's output buffer waits before being flushed (0 flushes every message)")
+	maxOutputBufferSize    = flag.Int64("max-output-buffer-size", 64*1024, "max output buffer size (bytes) a client can negotiate")
+	maxOutputBufferTimeout = flag.Duration("max-output-buffer-timeout", time.Second, "max output buffer timeout a client can negotiate")
+
+	webhookURLs        = util.StringArray{}
+	webhookQueueSize   = flag.Int64("webhook-queue-size", 1000, "number of events queued (per webhook url) before new ones are dropped")
+	webhookMaxAttempts = flag.Int("webhook-max-attempts", 5, "number of times delivery of an event to a webhook url is attempted")
+
+	latencyWindow      = flag.Duration("latency-window", 10*time.Minute, "window over which each channel's latency percentiles are estimated (0 disables)")
+	latencyPercentiles = util.StringArray{}
+
+	trustedProxies   = util.StringArray{}
+	auditLogMaxBytes = flag.Int64("audit-log-max-bytes", 104857600, "size (bytes) at which the audit log is rotated")
 )
 
 func init() {
 	flag.Var(&lookupdTCPAddrs, "lookupd-tcp-address", "lookupd TCP address (may be given multiple times)")
+	flag.Var(&webhookURLs, "webhook-url", "URL topology events are POSTed to (may be given multiple times)")
+	flag.Var(&topicRetention, "topic-retention", "<topic>:<duration> per-topic retention window override (may be given multiple times)")
+	flag.Var(&trustedProxies, "trusted-proxy", "IP or CIDR range of a proxy (ie. nsqadmin) whose X-Forwarded-For and X-NSQ-User headers are audited (may be given multiple times)")
+	flag.Var(&latencyPercentiles, "latency-percentile", "latency percentile (0 < p <= 1) to report in /stats (may be given multiple times, defaults to 0.5, 0.95 and 0.99)")
 }
 
-var nsqd *NSQd
-var protocols = map[int32]nsq.Protocol{}
+// the flags a config reload applies at runtime
+var reloadableFlags = []string{"lookupd-tcp-address", "msg-timeout", "verbose"}
 
 func main() {
 	flag.Parse()
@@ -46,28 +78,79 @@ func main() {
 		return
 	}
 
-	if *workerId == 0 {
-		hostname, err := os.Hostname()
+	var configFile *util.ConfigFile
+	if *config != "" {
+		configFile = util.NewConfigFile(*config, flag.CommandLine)
+		err := configFile.Load()
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("FATAL: failed to load config - %s", err.Error())
 		}
-		h := md5.New()
-		io.WriteString(h, hostname)
-		*workerId = int64(crc32.ChecksumIEEE(h.Sum(nil)) % 1024)
 	}
 
-	tcpAddr, err := net.ResolveTCPAddr("tcp", *tcpAddress)
-	if err != nil {
-		log.Fatal(err)
+	options := nsqd.NewOptions()
+	options.TCPAddress = *tcpAddress
+	options.HTTPAddress = *httpAddress
+	options.TCPSocket = *tcpSocket
+	options.HTTPSocket = *httpSocket
+	options.LookupdTCPAddrs = lookupdTCPAddrs
+	if *workerId != 0 {
+		options.WorkerId = *workerId
 	}
-
-	httpAddr, err := net.ResolveTCPAddr("tcp", *httpAddress)
+	options.Verbose = *verbose
+	options.MemQueueSize = *memQueueSize
+	options.MemBudget = *memBudget
+	options.DataPath = *dataPath
+	options.MaxBytesPerFile = *maxBytesPerFile
+	options.SyncEvery = *syncEvery
+	options.MsgTimeout = time.Duration(*msgTimeoutMs) * time.Millisecond
+	options.RetentionWindow = *retentionWindow
+	options.MaxTopicDepth = *maxTopicDepth
+	options.MaxTopicBytes = *maxTopicBytes
+	options.MaxChannelDepth = *maxChannelDepth
+	options.MaxChannelBytes = *maxChannelBytes
+	options.DepthPolicy = *depthPolicy
+	options.PublishDurability = *publishDurability
+	options.DispatchPolicy = *dispatchPolicy
+	options.OutputBufferSize = *outputBufferSize
+	options.OutputBufferTimeout = *outputBufferTimeout
+	options.MaxOutputBufferSize = *maxOutputBufferSize
+	options.MaxOutputBufferTimeout = *maxOutputBufferTimeout
+	options.WebhookURLs = webhookURLs
+	options.WebhookQueueSize = *webhookQueueSize
+	options.WebhookMaxAttempts = *webhookMaxAttempts
+	options.DiskLowWatermark = *diskLowWatermark
+	options.DiskHighWatermark = *diskHighWatermark
+	for _, entry := range topicRetention {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			log.Fatalf("FATAL: invalid --topic-retention %s", entry)
+		}
+		window, err := time.ParseDuration(parts[1])
+		if err != nil {
+			log.Fatalf("FATAL: invalid --topic-retention %s - %s", entry, err.Error())
+		}
+		options.TopicRetention[parts[0]] = window
+	}
+	options.TrustedProxies = trustedProxies
+	options.AuditLogMaxBytes = *auditLogMaxBytes
+	options.LatencyWindow = *latencyWindow
+	if len(latencyPercentiles) > 0 {
+		options.LatencyPercentiles = nil
+		for _, entry := range latencyPercentiles {
+			percentile, err := strconv.ParseFloat(entry, 64)
+			if err != nil {
+				log.Fatalf("FATAL: invalid --latency-percentile %s - %s", entry, err.Error())
+			}
+			options.LatencyPercentiles = append(options.LatencyPercentiles, percentile)
+		}
+	}
+	err := options.Validate()
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("FATAL: %s", err.Error())
 	}
 
 	log.Printf("nsqd v%s", util.BINARY_VERSION)
-	log.Printf("worker id %d", *workerId)
+	log.Printf("worker id %d", options.WorkerId)
 
 	exitChan := make(chan int)
 	signalChan := make(chan os.Signal, 1)
@@ -77,20 +160,46 @@ func main() {
 	}()
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 
-	options := NewNsqdOptions()
-	options.memQueueSize = *memQueueSize
-	options.dataPath = *dataPath
-	options.maxBytesPerFile = *maxBytesPerFile
-	options.syncEvery = *syncEvery
-	options.msgTimeout = time.Duration(*msgTimeoutMs) * time.Millisecond
+	daemon := nsqd.New(options)
+	daemon.LoadMetadata()
+	err = daemon.Start()
+	if err != nil {
+		log.Fatalf("FATAL: %s", err.Error())
+	}
 
-	nsqd = NewNSQd(*workerId, options)
-	nsqd.tcpAddr = tcpAddr
-	nsqd.httpAddr = httpAddr
-	nsqd.lookupdTCPAddrs = lookupdTCPAddrs
+	if configFile != nil {
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		go func() {
+			for _ = range hupChan {
+				reloadConfig(configFile, daemon)
+			}
+		}()
+	}
 
-	nsqd.LoadMetadata()
-	nsqd.Main()
 	<-exitChan
-	nsqd.Exit()
+	daemon.Exit()
+}
+
+// reloadConfig re-reads the config file, applying the settings that can be
+// changed at runtime (the others require a restart)
+func reloadConfig(configFile *util.ConfigFile, daemon *nsqd.NSQd) {
+	log.Printf("NSQ: reloading config")
+	err := configFile.Reload(reloadableFlags)
+	if err != nil {
+		log.Printf("ERROR: failed to reload config - %s", err.Error())
+		return
+	}
+	if *msgTimeoutMs <= 0 {
+		log.Printf("ERROR: failed to reload config - --msg-timeout must be > 0")
+		return
+	}
+
+	daemon.SetMsgTimeout(time.Duration(*msgTimeoutMs) * time.Millisecond)
+	daemon.SetVerbose(*verbose)
+	// a copy, the flag is reset on every reload
+	err = daemon.SetLookupdTCPAddrs(append([]string{}, lookupdTCPAddrs...))
+	if err != nil {
+		log.Printf("ERROR: failed to reload config - %s", err.Error())
+	}
 }
@@ -0,0 +1,271 @@
+package nsqd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the header nsqadmin uses to forward the user performing an action
+const auditUserHeader = "X-NSQ-User"
+
+// AuditEntry records a single administrative action and its result
+type AuditEntry struct {
+	Timestamp     int64  `json:"timestamp"`
+	RemoteAddress string `json:"remote_address"`
+	Via           string `json:"via,omitempty"` // the proxy (ie. nsqadmin) that forwarded the action
+	User          string `json:"user,omitempty"`
+	Action        string `json:"action"`
+	Topic         string `json:"topic"`
+	Channel       string `json:"channel,omitempty"`
+	Client        string `json:"client,omitempty"`
+	StatusCode    int    `json:"status_code"`
+	Result        string `json:"result"`
+}
+
+// the number of recent entries an AuditLog keeps in memory for Entries()
+const auditLogTailSize = 1000
+
+// the number of rotated audit logs kept, <file>.1 is the most recent
+const auditLogBackups = 5
+
+// AuditLog is an append-only file of AuditEntry, one JSON object per line,
+// rotated once it reaches maxBytes
+type AuditLog struct {
+	sync.Mutex
+
+	fileName  string
+	maxBytes  int64
+	writeFile *os.File
+	writeSize int64
+
+	// the most recent entries, oldest first
+	tail []*AuditEntry
+}
+
+func NewAuditLog(fileName string, maxBytes int64) *AuditLog {
+	a := &AuditLog{fileName: fileName, maxBytes: maxBytes}
+	err := a.load()
+	if err != nil {
+		log.Printf("ERROR: failed to read audit log %s - %s", fileName, err.Error())
+	}
+	return a
+}
+
+// Record appends an entry to the log, it is synced before returning
+func (a *AuditLog) Record(entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	a.Lock()
+	defer a.Unlock()
+
+	if a.writeFile != nil && a.maxBytes > 0 && a.writeSize+int64(len(data)) > a.maxBytes {
+		err = a.rotate()
+		if err != nil {
+			log.Printf("ERROR: failed to rotate audit log %s - %s", a.fileName, err.Error())
+		}
+	}
+
+	if a.writeFile == nil {
+		a.writeFile, err = os.OpenFile(a.fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		stat, err := a.writeFile.Stat()
+		if err != nil {
+			a.writeFile.Close()
+			a.writeFile = nil
+			return err
+		}
+		a.writeSize = stat.Size()
+	}
+
+	_, err = a.writeFile.Write(data)
+	if err == nil {
+		err = a.writeFile.Sync()
+	}
+	if err != nil {
+		a.writeFile.Close()
+		a.writeFile = nil
+		return err
+	}
+	a.writeSize += int64(len(data))
+
+	a.tail = append(a.tail, entry)
+	if len(a.tail) > auditLogTailSize {
+		a.tail = append([]*AuditEntry{}, a.tail[len(a.tail)-auditLogTailSize:]...)
+	}
+	return nil
+}
+
+// Entries returns (oldest first) up to the last `limit` of the recent
+// entries matching the given topic and channel (empty matches any)
+func (a *AuditLog) Entries(topicName string, channelName string, limit int) []*AuditEntry {
+	a.Lock()
+	defer a.Unlock()
+
+	var matched []*AuditEntry
+	for i := len(a.tail) - 1; i >= 0; i-- {
+		entry := a.tail[i]
+		if topicName != "" && entry.Topic != topicName {
+			continue
+		}
+		if channelName != "" && entry.Channel != channelName {
+			continue
+		}
+		matched = append(matched, entry)
+		if limit > 0 && len(matched) == limit {
+			break
+		}
+	}
+
+	entries := make([]*AuditEntry, len(matched))
+	for i, entry := range matched {
+		entries[len(matched)-1-i] = entry
+	}
+	return entries
+}
+
+func (a *AuditLog) Close() error {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.writeFile == nil {
+		return nil
+	}
+	err := a.writeFile.Close()
+	a.writeFile = nil
+	return err
+}
+
+// load reads the most recent entries of an existing log into the tail
+func (a *AuditLog) load() error {
+	f, err := os.Open(a.fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		entry := &AuditEntry{}
+		err := json.Unmarshal(line, entry)
+		if err != nil {
+			// a partial line left by a crash
+			log.Printf("ERROR: skipping invalid audit entry in %s - %s", a.fileName, err.Error())
+			continue
+		}
+		a.tail = append(a.tail, entry)
+		if len(a.tail) > auditLogTailSize {
+			a.tail = a.tail[1:]
+		}
+	}
+	return scanner.Err()
+}
+
+// rotate closes the log and renames it to <file>.1 (shifting older logs up
+// to <file>.<auditLogBackups>), it is reopened by the next Record()
+func (a *AuditLog) rotate() error {
+	a.writeFile.Close()
+	a.writeFile = nil
+
+	for i := auditLogBackups - 1; i > 0; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", a.fileName, i), fmt.Sprintf("%s.%d", a.fileName, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(a.fileName, a.fileName+".1")
+}
+
+// auditResponseWriter keeps the status code and (the start of) the
+// response body of an audited handler so that its result can be recorded
+type auditResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if n := 512 - w.body.Len(); n > 0 {
+		if n > len(b) {
+			n = len(b)
+		}
+		w.body.Write(b[:n])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// result is the response's status_txt (or body when it isn't an API response)
+func (w *auditResponseWriter) result() string {
+	var resp struct {
+		StatusTxt string `json:"status_txt"`
+	}
+	err := json.Unmarshal(w.body.Bytes(), &resp)
+	if err == nil && resp.StatusTxt != "" {
+		return resp.StatusTxt
+	}
+	return strings.TrimSpace(w.body.String())
+}
+
+// audited wraps an administrative handler, recording who called it,
+// on what and with what result in the audit log
+func (s *httpServer) audited(action string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		aw := &auditResponseWriter{ResponseWriter: w, statusCode: 200}
+		handler(aw, req)
+
+		query := req.URL.Query()
+		entry := &AuditEntry{
+			Timestamp:     time.Now().Unix(),
+			RemoteAddress: req.RemoteAddr,
+			Action:        action,
+			Topic:         query.Get("topic"),
+			Channel:       query.Get("channel"),
+			Client:        query.Get("id"),
+			StatusCode:    aw.statusCode,
+			Result:        aw.result(),
+		}
+		if entry.Client == "" {
+			entry.Client = query.Get("address")
+		}
+		// anyone can set these headers, they're only believed from a trusted proxy
+		if s.nsqd.trustedProxies.Contains(req.RemoteAddr) {
+			entry.User = req.Header.Get(auditUserHeader)
+			if forwardedFor := req.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+				entry.Via = entry.RemoteAddress
+				entry.RemoteAddress = forwardedFor
+			}
+		}
+
+		log.Printf("AUDIT: %s %s (%s) %s:%s - %s", entry.RemoteAddress, entry.User,
+			action, entry.Topic, entry.Channel, entry.Result)
+		err := s.nsqd.auditLog.Record(entry)
+		if err != nil {
+			log.Printf("ERROR: failed to record audit entry - %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"fmt"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	dataPath, err := ioutil.TempDir("", "nsqd-audit")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dataPath)
+
+	options := NewOptions()
+	options.DataPath = dataPath
+	options.TrustedProxies = []string{"127.0.0.1"}
+	_, httpAddr, nsqd := mustStartNSQd(options)
+	defer nsqd.Exit()
+
+	topicName := "test_audit_log" + strconv.Itoa(int(time.Now().Unix()))
+	nsqd.GetTopic(topicName).GetChannel("ch")
+
+	// as proxied by nsqadmin
+	header := make(http.Header)
+	header.Set("X-Forwarded-For", "10.0.0.1")
+	header.Set("X-NSQ-User", "alice")
+	endpoint := fmt.Sprintf("http://%s/pause_channel?topic=%s&channel=ch", httpAddr, topicName)
+	_, err = nsq.ApiRequestWithHeader(endpoint, header)
+	assert.Equal(t, err, nil)
+
+	endpoint = fmt.Sprintf("http://%s/delete_channel?topic=%s&channel=missing", httpAddr, topicName)
+	_, err = nsq.ApiRequest(endpoint)
+	assert.NotEqual(t, err, nil)
+
+	entries := nsqd.auditLog.Entries(topicName, "", 0)
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, entries[0].Action, "pause_channel")
+	assert.Equal(t, entries[0].Channel, "ch")
+	assert.Equal(t, entries[0].RemoteAddress, "10.0.0.1")
+	assert.NotEqual(t, entries[0].Via, "")
+	assert.Equal(t, entries[0].User, "alice")
+	assert.Equal(t, entries[0].StatusCode, 200)
+	assert.Equal(t, entries[0].Result, "OK")
+	assert.Equal(t, entries[1].Action, "delete_channel")
+	assert.Equal(t, entries[1].Via, "")
+	assert.Equal(t, entries[1].StatusCode, 500)
+	assert.Equal(t, entries[1].Result, "INVALID_CHANNEL")
+
+	endpoint = fmt.Sprintf("http://%s/audit?topic=%s&limit=1", httpAddr, topicName)
+	data, err := nsq.ApiRequest(endpoint)
+	assert.Equal(t, err, nil)
+	recent, _ := data.Get("entries").Array()
+	assert.Equal(t, len(recent), 1)
+	assert.Equal(t, data.Get("entries").GetIndex(0).Get("action").MustString(), "delete_channel")
+
+	// the forwarding headers are ignored from anyone but a trusted proxy
+	nsqd.trustedProxies = nil
+	endpoint = fmt.Sprintf("http://%s/unpause_channel?topic=%s&channel=ch", httpAddr, topicName)
+	_, err = nsq.ApiRequestWithHeader(endpoint, header)
+	assert.Equal(t, err, nil)
+	entries = nsqd.auditLog.Entries(topicName, "", 1)
+	assert.Equal(t, entries[0].Action, "unpause_channel")
+	assert.NotEqual(t, entries[0].RemoteAddress, "10.0.0.1")
+	assert.Equal(t, entries[0].Via, "")
+	assert.Equal(t, entries[0].User, "")
+
+	// as is creating a topic (on either API)
+	createdTopicName := topicName + "_created"
+	endpoint = fmt.Sprintf("http://%s/create_topic?topic=%s", httpAddr, createdTopicName)
+	_, err = nsq.ApiRequest(endpoint)
+	assert.Equal(t, err, nil)
+	statusCode, _ := v1APIRequest(t, httpAddr, "POST", "/v1/topic/"+createdTopicName, "")
+	assert.Equal(t, statusCode, 200)
+	entries = nsqd.auditLog.Entries(createdTopicName, "", 0)
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, entries[0].Action, "create_topic")
+	assert.Equal(t, entries[1].Action, "create_topic")
+
+	// the log is appended to across restarts
+	nsqd.auditLog.Close()
+	entries = NewAuditLog(nsqd.auditLog.fileName, 0).Entries("", "ch", 0)
+	assert.Equal(t, len(entries), 2)
+}
+
+func TestAuditLogRotate(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	dataPath, err := ioutil.TempDir("", "nsqd-audit")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dataPath)
+
+	fileName := path.Join(dataPath, "audit.log")
+	auditLog := NewAuditLog(fileName, 256)
+	for i := 0; i < auditLogTailSize+10; i++ {
+		err := auditLog.Record(&AuditEntry{Action: "empty_channel", Topic: strconv.Itoa(i)})
+		assert.Equal(t, err, nil)
+	}
+	auditLog.Close()
+
+	stat, err := os.Stat(fileName)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, stat.Size() <= 256, true)
+	_, err = os.Stat(fileName + "." + strconv.Itoa(auditLogBackups))
+	assert.Equal(t, err, nil)
+	_, err = os.Stat(fileName + "." + strconv.Itoa(auditLogBackups+1))
+	assert.Equal(t, os.IsNotExist(err), true)
+
+	// only the most recent entries are kept in memory
+	entries := auditLog.Entries("", "", 0)
+	assert.Equal(t, len(entries), auditLogTailSize)
+	assert.Equal(t, entries[0].Topic, "10")
+	entries = auditLog.Entries(strconv.Itoa(auditLogTailSize+9), "", 0)
+	assert.Equal(t, len(entries), 1)
+}
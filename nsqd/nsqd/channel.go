@@ -0,0 +1,1090 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"../../util"
+	"bytes"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// channel modes control how many clients receive messages concurrently
+const (
+	channelModeShared    = "shared"    // messages are distributed across all clients
+	channelModeExclusive = "exclusive" // only one client can subscribe
+	channelModeFailover  = "failover"  // only the oldest client receives messages, the rest are standbys
+)
+
+var ErrChannelExclusive = errors.New("channel is exclusive and already has a client")
+var ErrTooManyClients = errors.New("channel has too many clients")
+
+type Consumer interface {
+	UnPause()
+	Pause()
+	Close() error
+	TimedOutMessage()
+	Stats() ClientStats
+	Drain()
+	UnDrain()
+	IsReadyForMessages() bool
+	Deliver(msg *nsq.Message) bool
+	InFlight() int64
+	Weight() int
+}
+
+// Channel represents the concrete type for a NSQ channel (and also
+// implements the Queue interface)
+//
+// There can be multiple channels per topic, each with there own unique set 
+// of subscribers (clients).
+//
+// Channels maintain all client and message metadata, orchestrating in-flight
+// messages, timeouts, requeueing, etc.
+type Channel struct {
+	sync.RWMutex // embed a r/w mutex
+
+	topicName string
+	name      string
+	options   *Options
+	nsqd      *NSQd
+
+	backend   BackendQueue
+
+	incomingMsgChan chan *nsq.Message
+	memoryMsgChan   chan *nsq.Message
+	clientMsgChan   chan *nsq.Message
+	readyChan       chan int
+	exitChan        chan int
+	waitGroup       util.WaitGroupWrapper
+	exitFlag        int32
+
+	// when the router started routing the current message (0 while it's
+	// idle), see Liveness()
+	routeStarted int64
+
+	// state tracking
+	clients          []Consumer
+	mode             string
+	maxClients       int
+	paused           int32
+	ephemeralChannel bool
+	deleteCallback   func(*Channel)
+	deleter          sync.Once
+
+	// dispatcher state, see pickClient()
+	dispatchPolicy   string
+	dispatchIndex    int
+	dispatchWeights  map[Consumer]int
+	dispatchingCount int32
+
+	// scheduled on the shared timing wheel
+	timeouts         *TimingWheel
+	deferredMessages map[string]*Timeout
+	inFlightMessages map[string]*Timeout
+
+	// messages requeued by expired timeouts, handed to the router without
+	// blocking the timing wheel's workers (bounded by the number of
+	// in-flight and deferred messages), see requeueExpired()
+	expiredMutex sync.Mutex
+	expired      []*nsq.Message
+	expiredChan  chan int
+
+	limit        depthLimit
+	memoryBytes  int64
+	memoryBudget *MemoryBudget
+
+	// keyed (ordered) delivery state, see holdKeyed()
+	ordered      int32
+	orderedMutex sync.Mutex
+	topicOrdered *int32 // the topic's count of ordered channels, see attachTopic()
+	keyMutex     sync.Mutex
+	keyOwners    map[string][]byte
+	keyPending   map[string][]*nsq.Message
+	keyReleased  []*nsq.Message // released by releaseKey(), delivered next
+	pendingCount int64          // held back and released messages
+
+	keyReleasedChan chan int
+
+	// stat counters
+	requeueCount  uint64
+	messageCount  uint64
+	timeoutCount  uint64
+	dropCount     uint64
+	bufferedCount int32
+
+	// time from publish to FIN and from delivery to FIN of finished
+	// messages (nil when disabled, see Options.LatencyWindow)
+	e2eLatency      *Quantile
+	inFlightLatency *Quantile
+
+	// timestamps of the messages in memoryMsgChan and the recent rate
+	// messages are finished, see OldestMessageAge() and DrainETA()
+	memoryAges queueAges
+	finishRate *rateCounter
+}
+
+type inFlightMessage struct {
+	msg         *nsq.Message
+	client      Consumer
+	deliveredAt time.Time
+}
+
+// NewChannel creates a new instance of the Channel type and returns a pointer
+func NewChannel(topicName string, channelName string, nsqd *NSQd, deleteCallback func(*Channel)) *Channel {
+	options := nsqd.options
+	// backend names, for uniqueness, automatically include the topic... <topic>:<channel>
+	backendName := topicName + ":" + channelName
+	c := &Channel{
+		topicName:        topicName,
+		name:             channelName,
+		incomingMsgChan:  make(chan *nsq.Message, 1),
+		memoryMsgChan:    make(chan *nsq.Message, options.MemQueueSize),
+		clientMsgChan:    make(chan *nsq.Message),
+		readyChan:        make(chan int, 1),
+		exitChan:         make(chan int),
+		clients:          make([]Consumer, 0, 5),
+		mode:             channelModeShared,
+		dispatchPolicy:   options.DispatchPolicy,
+		dispatchWeights:  make(map[Consumer]int),
+		timeouts:         nsqd.timeouts,
+		inFlightMessages: make(map[string]*Timeout),
+		deferredMessages: make(map[string]*Timeout),
+		keyOwners:        make(map[string][]byte),
+		keyPending:       make(map[string][]*nsq.Message),
+		keyReleasedChan:  make(chan int, 1),
+		expiredChan:      make(chan int, 1),
+		deleteCallback:   deleteCallback,
+		limit:            depthLimit{options.MaxChannelDepth, options.MaxChannelBytes},
+		memoryBudget:     nsqd.memoryBudget,
+		finishRate:       newRateCounter(),
+		options:          options,
+		nsqd:             nsqd,
+	}
+	if strings.HasSuffix(channelName, "#ephemeral") {
+		c.ephemeralChannel = true
+		c.backend = NewDummyBackendQueue()
+	} else {
+		c.backend = NewDiskQueue(backendName, options.DataPath, options.MaxBytesPerFile, options.SyncEvery)
+	}
+	if options.LatencyWindow > 0 {
+		c.e2eLatency = NewQuantile(options.LatencyWindow)
+		c.inFlightLatency = NewQuantile(options.LatencyWindow)
+	}
+	go c.messagePump()
+	c.waitGroup.Wrap(func() { c.router() })
+	c.waitGroup.Wrap(func() { c.dispatcher() })
+
+	nsqd.postEvent("channel_change", c)
+	nsqd.postTopologyEvent(eventChannelCreated, topicName, channelName, nil)
+
+	return c
+}
+
+// Exiting returns a boolean indicating if this channel is closed/exiting
+func (c *Channel) Exiting() bool {
+	return atomic.LoadInt32(&c.exitFlag) == 1
+}
+
+// Delete empties the channel and closes
+func (c *Channel) Delete() error {
+	c.empty()
+	return c.Close()
+}
+
+// Empty discards all queued messages (including those held back
+// waiting on an earlier message with the same key)
+func (c *Channel) Empty() error {
+	err := c.empty()
+	c.nsqd.postTopologyEvent(eventChannelEmptied, c.topicName, c.name, nil)
+	return err
+}
+
+func (c *Channel) empty() error {
+	c.expiredMutex.Lock()
+	c.expired = nil
+	c.expiredMutex.Unlock()
+
+	err := EmptyQueue(c)
+
+	// keys stay owned by messages that are in-flight/deferred, any other
+	// owner was requeued and has just been discarded
+	c.RLock()
+	owning := make(map[string]bool, len(c.inFlightMessages)+len(c.deferredMessages))
+	for id := range c.inFlightMessages {
+		owning[id] = true
+	}
+	for id := range c.deferredMessages {
+		owning[id] = true
+	}
+	c.RUnlock()
+
+	c.keyMutex.Lock()
+	for key, owner := range c.keyOwners {
+		if !owning[string(owner)] {
+			delete(c.keyOwners, key)
+		}
+	}
+	c.keyPending = make(map[string][]*nsq.Message)
+	c.keyReleased = nil
+	atomic.StoreInt64(&c.pendingCount, 0)
+	c.keyMutex.Unlock()
+	c.notifyKeyReleased()
+
+	return err
+}
+
+// Close cleanly closes the Channel
+func (c *Channel) Close() error {
+	var msgBuf bytes.Buffer
+
+	if atomic.LoadInt32(&c.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+
+	log.Printf("CHANNEL(%s): closing", c.name)
+
+	// initiate exit
+	atomic.StoreInt32(&c.exitFlag, 1)
+
+	// this forceably closes client connections
+	for _, client := range c.clients {
+		client.Close()
+	}
+
+	close(c.exitChan)
+
+	// handle race condition w/ things writing into incomingMsgChan
+	c.Lock()
+	close(c.incomingMsgChan)
+	c.Unlock()
+
+	// synchronize the close of router() and dispatcher()
+	c.waitGroup.Wait()
+
+	// messagePump is responsible for closing the channel it writes to
+	// this will read until its closed (exited)
+	for msg := range c.clientMsgChan {
+		log.Printf("CHANNEL(%s): recovered buffered message from clientMsgChan", c.name)
+		WriteMessageToBackend(&msgBuf, msg, c)
+	}
+
+	// the in-flight and deferred messages are flushed below rather than timed out
+	c.Lock()
+	for _, item := range c.inFlightMessages {
+		c.timeouts.Remove(item)
+	}
+	for _, item := range c.deferredMessages {
+		c.timeouts.Remove(item)
+	}
+	c.Unlock()
+
+	// write anything leftover to disk
+	for _, msg := range c.takeExpired() {
+		WriteMessageToBackend(&msgBuf, msg, c)
+	}
+	if len(c.memoryMsgChan) > 0 || len(c.inFlightMessages) > 0 || len(c.deferredMessages) > 0 {
+		log.Printf("CHANNEL(%s): flushing %d memory %d in-flight %d deferred messages to backend",
+			c.name, len(c.memoryMsgChan), len(c.inFlightMessages), len(c.deferredMessages))
+	}
+	FlushQueue(c)
+
+	// held back keyed messages go last so that they follow their (in-flight) predecessor
+	c.keyMutex.Lock()
+	for _, msg := range c.keyReleased {
+		WriteMessageToBackend(&msgBuf, msg, c)
+	}
+	for _, pending := range c.keyPending {
+		for _, msg := range pending {
+			WriteMessageToBackend(&msgBuf, msg, c)
+		}
+	}
+	c.keyMutex.Unlock()
+
+	return c.backend.Close()
+}
+
+// MemoryChan implements the Queue interface
+func (c *Channel) MemoryChan() chan *nsq.Message {
+	return c.memoryMsgChan
+}
+
+// BackendQueue implements the Queue interface
+func (c *Channel) BackendQueue() BackendQueue {
+	return c.backend
+}
+
+// InFlight implements the Queue interface
+func (c *Channel) InFlight() map[string]*Timeout {
+	return c.inFlightMessages
+}
+
+// Deferred implements the Queue interface
+func (c *Channel) Deferred() map[string]*Timeout {
+	return c.deferredMessages
+}
+
+func (c *Channel) Depth() int64 {
+	return int64(len(c.memoryMsgChan)) + c.backend.Depth() + int64(atomic.LoadInt32(&c.bufferedCount)) +
+		int64(atomic.LoadInt32(&c.dispatchingCount)) + atomic.LoadInt64(&c.pendingCount)
+}
+
+// DepthBytes returns the (approximate) number of bytes queued for this channel
+func (c *Channel) DepthBytes() int64 {
+	return atomic.LoadInt64(&c.memoryBytes) + c.backend.DepthBytes()
+}
+
+// OldestMessageAge returns how long ago the oldest messages queued in memory
+// and on disk were published (0 when there are none)
+//
+// the age on disk is that of the message at the head of the backend (which
+// is only known once it has been read) to the second
+func (c *Channel) OldestMessageAge() (time.Duration, time.Duration) {
+	now := time.Now().UnixNano()
+
+	var memoryAge, backendAge time.Duration
+	if timestamp := c.memoryAges.Oldest(); timestamp > 0 {
+		memoryAge = time.Duration(now - timestamp)
+	}
+	if data := c.backend.Peek(); data != nil {
+		timestamp, err := backendMessageTimestamp(data)
+		if err == nil {
+			backendAge = time.Duration(now - timestamp*int64(time.Second))
+		}
+	}
+	if memoryAge < 0 {
+		memoryAge = 0
+	}
+	if backendAge < 0 {
+		backendAge = 0
+	}
+	return memoryAge, backendAge
+}
+
+// DrainETA returns how long the channel's depth would take to drain at the
+// rate messages were recently finished (-1 if none were)
+func (c *Channel) DrainETA() time.Duration {
+	depth := c.Depth()
+	if depth == 0 {
+		return 0
+	}
+	rate := c.finishRate.Rate()
+	if rate == 0 {
+		return -1
+	}
+	return time.Duration(float64(depth) / rate * float64(time.Second))
+}
+
+// Full returns a boolean indicating if this channel is at its max depth
+func (c *Channel) Full() bool {
+	return c.limit.exceeded(c.Depth(), c.DepthBytes())
+}
+
+// MemoryDequeued implements the Queue interface
+func (c *Channel) MemoryDequeued(msg *nsq.Message) {
+	c.memoryAges.Dequeued()
+	size := messageSize(msg)
+	atomic.AddInt64(&c.memoryBytes, -size)
+	c.memoryBudget.Release(size)
+}
+
+func (c *Channel) Pause() {
+	atomic.StoreInt32(&c.paused, 1)
+	c.nsqd.postTopologyEvent(eventChannelPaused, c.topicName, c.name, nil)
+	c.RLock()
+	defer c.RUnlock()
+	for _, client := range c.clients {
+		client.Pause()
+	}
+}
+
+func (c *Channel) UnPause() {
+	atomic.StoreInt32(&c.paused, 0)
+	c.nsqd.postTopologyEvent(eventChannelUnPaused, c.topicName, c.name, nil)
+	c.RLock()
+	defer c.RUnlock()
+	for _, client := range c.clients {
+		client.UnPause()
+	}
+	c.notifyDispatcher()
+}
+
+func (c *Channel) IsPaused() bool {
+	return atomic.LoadInt32(&c.paused) == 1
+}
+
+// PutMessage writes to the appropriate incoming message channel
+// (which will be routed asynchronously)
+//
+// the drop policies are applied here when the channel is at its max depth,
+// the reject policy is enforced when publishing to the topic
+func (c *Channel) PutMessage(msg *nsq.Message) error {
+	c.RLock()
+	defer c.RUnlock()
+	if atomic.LoadInt32(&c.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+	if c.Full() {
+		switch c.options.DepthPolicy {
+		case depthPolicyDropNewest:
+			atomic.AddUint64(&c.dropCount, 1)
+			return nil
+		case depthPolicyDropOldest:
+			if dropped, ok := DropOldest(c); ok {
+				atomic.AddUint64(&c.dropCount, 1)
+				// a requeued owner of a key will never be finished
+				if dropped != nil && len(dropped.Key) > 0 {
+					c.releaseKey(dropped)
+				}
+			}
+		}
+	}
+	c.incomingMsgChan <- msg
+	atomic.AddUint64(&c.messageCount, 1)
+	return nil
+}
+
+// FinishMessage successfully discards an in-flight message
+func (c *Channel) FinishMessage(client Consumer, id []byte) error {
+	item, err := c.popInFlightMessage(client, id)
+	if err != nil {
+		log.Printf("ERROR: failed to finish message(%s) - %s", id, err.Error())
+	} else {
+		c.timeouts.Remove(item)
+		c.finishRate.Incr()
+		inFlight := item.Value.(*inFlightMessage)
+		msg := inFlight.msg
+		if msg.Key != nil {
+			c.releaseKey(msg)
+		}
+		if c.e2eLatency != nil {
+			now := time.Now()
+			if msg.TimestampNano > 0 {
+				c.e2eLatency.Insert(time.Duration(now.UnixNano() - msg.TimestampNano))
+			}
+			c.inFlightLatency.Insert(now.Sub(inFlight.deliveredAt))
+		}
+	}
+	return err
+}
+
+// RequeueMessage requeues a message based on `time.Duration`, ie:
+//
+// `timeoutMs` == 0 - requeue a message immediately
+// `timeoutMs`  > 0 - asynchronously wait for the specified timeout
+//     and requeue a message (aka "deferred requeue")
+//
+func (c *Channel) RequeueMessage(client Consumer, id []byte, timeout time.Duration) error {
+	// remove from inflight first
+	item, err := c.popInFlightMessage(client, id)
+	if err != nil {
+		return err
+	}
+	c.timeouts.Remove(item)
+
+	msg := item.Value.(*inFlightMessage).msg
+
+	if timeout == 0 {
+		return c.doRequeue(msg)
+	}
+
+	// deferred requeue
+	return c.StartDeferredTimeout(msg, timeout)
+}
+
+// IsOrdered returns a boolean indicating if messages with the same key
+// are delivered one at a time, in order
+func (c *Channel) IsOrdered() bool {
+	return atomic.LoadInt32(&c.ordered) == 1
+}
+
+// SetOrdered enables or disables ordered delivery of keyed messages
+func (c *Channel) SetOrdered(ordered bool) {
+	c.orderedMutex.Lock()
+	if !ordered && atomic.CompareAndSwapInt32(&c.ordered, 1, 0) && c.topicOrdered != nil {
+		atomic.AddInt32(c.topicOrdered, -1)
+	}
+	if ordered && atomic.CompareAndSwapInt32(&c.ordered, 0, 1) && c.topicOrdered != nil {
+		atomic.AddInt32(c.topicOrdered, 1)
+	}
+	c.orderedMutex.Unlock()
+
+	if ordered {
+		return
+	}
+
+	// release everything that was being held back
+	c.keyMutex.Lock()
+	for _, msgs := range c.keyPending {
+		c.keyReleased = append(c.keyReleased, msgs...)
+	}
+	c.keyOwners = make(map[string][]byte)
+	c.keyPending = make(map[string][]*nsq.Message)
+	c.keyMutex.Unlock()
+	c.notifyKeyReleased()
+}
+
+// attachTopic counts the channel in the topic's count of ordered channels
+// (while it's ordered) until detachTopic(), so that the topic's router can
+// check for one without taking the topic's lock
+func (c *Channel) attachTopic(topicOrdered *int32) {
+	c.orderedMutex.Lock()
+	defer c.orderedMutex.Unlock()
+	c.topicOrdered = topicOrdered
+	if c.IsOrdered() {
+		atomic.AddInt32(c.topicOrdered, 1)
+	}
+}
+
+func (c *Channel) detachTopic() {
+	c.orderedMutex.Lock()
+	defer c.orderedMutex.Unlock()
+	if c.topicOrdered != nil && c.IsOrdered() {
+		atomic.AddInt32(c.topicOrdered, -1)
+	}
+	c.topicOrdered = nil
+}
+
+// Mode returns the channel's mode (shared, exclusive or failover)
+func (c *Channel) Mode() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.mode
+}
+
+// SetMode changes the channel's mode, it only affects clients that
+// subscribe afterwards (existing clients are never disconnected)
+// except that in failover mode only the oldest client receives messages
+func (c *Channel) SetMode(mode string) error {
+	if mode != channelModeShared && mode != channelModeExclusive && mode != channelModeFailover {
+		return errors.New("invalid channel mode " + mode)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.mode = mode
+	// standbys may now be allowed to receive messages
+	c.notifyDispatcher()
+	return nil
+}
+
+// MaxClients returns the maximum number of clients allowed to subscribe (0 is unlimited)
+func (c *Channel) MaxClients() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.maxClients
+}
+
+// SetMaxClients changes the maximum number of clients allowed to subscribe (0 is unlimited)
+func (c *Channel) SetMaxClients(maxClients int) error {
+	if maxClients < 0 {
+		return errors.New("invalid max clients")
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.maxClients = maxClients
+	return nil
+}
+
+// IsActiveClient returns a boolean indicating if the client is allowed to
+// receive messages given the channel's mode
+func (c *Channel) IsActiveClient(client Consumer) bool {
+	c.RLock()
+	defer c.RUnlock()
+	if c.mode != channelModeFailover {
+		return true
+	}
+	return len(c.clients) != 0 && c.clients[0] == client
+}
+
+// SetOption applies a single (persisted) channel option
+func (c *Channel) SetOption(key string, value string) error {
+	switch key {
+	case "ordered":
+		ordered, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		c.SetOrdered(ordered)
+	case "mode":
+		return c.SetMode(value)
+	case "dispatch":
+		return c.SetDispatchPolicy(value)
+	case "max_clients":
+		maxClients, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		return c.SetMaxClients(maxClients)
+	default:
+		return errors.New("unknown channel option " + key)
+	}
+	return nil
+}
+
+// Options returns the channel options that differ from the defaults
+// (as key=value pairs) so that they can be persisted
+func (c *Channel) Options() []string {
+	var options []string
+	if c.IsOrdered() {
+		options = append(options, "ordered=true")
+	}
+	if mode := c.Mode(); mode != channelModeShared {
+		options = append(options, "mode="+mode)
+	}
+	if policy := c.DispatchPolicy(); policy != c.options.DispatchPolicy {
+		options = append(options, "dispatch="+policy)
+	}
+	if maxClients := c.MaxClients(); maxClients > 0 {
+		options = append(options, "max_clients="+strconv.Itoa(maxClients))
+	}
+	return options
+}
+
+// holdKeyed returns true if the message must wait for an earlier message
+// with the same key (which is still in-flight/deferred) and queues it
+// behind that message, otherwise the message becomes the key's owner
+//
+// a key has at most one owner so messages with the same key are never
+// in-flight concurrently, a requeued owner stays the owner until it is
+// finished
+func (c *Channel) holdKeyed(msg *nsq.Message) bool {
+	c.keyMutex.Lock()
+	defer c.keyMutex.Unlock()
+
+	key := string(msg.Key)
+	owner, ok := c.keyOwners[key]
+	if !ok {
+		c.keyOwners[key] = msg.Id
+		return false
+	}
+	if bytes.Equal(owner, msg.Id) {
+		return false
+	}
+
+	c.keyPending[key] = append(c.keyPending[key], msg)
+	atomic.AddInt64(&c.pendingCount, 1)
+	return true
+}
+
+// releaseKey hands ownership of a finished message's key to the next
+// message held back for that key (if any) which messagePump delivers next
+func (c *Channel) releaseKey(msg *nsq.Message) {
+	c.keyMutex.Lock()
+	key := string(msg.Key)
+	owner, ok := c.keyOwners[key]
+	if !ok || !bytes.Equal(owner, msg.Id) {
+		c.keyMutex.Unlock()
+		return
+	}
+
+	pending := c.keyPending[key]
+	if len(pending) == 0 {
+		delete(c.keyOwners, key)
+		delete(c.keyPending, key)
+		c.keyMutex.Unlock()
+		return
+	}
+
+	next := pending[0]
+	if len(pending) == 1 {
+		delete(c.keyPending, key)
+	} else {
+		c.keyPending[key] = pending[1:]
+	}
+	c.keyOwners[key] = next.Id
+	c.keyReleased = append(c.keyReleased, next)
+	c.keyMutex.Unlock()
+	c.notifyKeyReleased()
+}
+
+// nextReleased returns the next message released by releaseKey() (nil
+// when there is none)
+func (c *Channel) nextReleased() *nsq.Message {
+	c.keyMutex.Lock()
+	defer c.keyMutex.Unlock()
+	if len(c.keyReleased) == 0 {
+		return nil
+	}
+	msg := c.keyReleased[0]
+	c.keyReleased = c.keyReleased[1:]
+	atomic.AddInt64(&c.pendingCount, -1)
+	return msg
+}
+
+// keyPendingFull returns true when as many messages are held back as fit
+// in --mem-queue-size (at least one), messagePump stops reading until
+// some are released so that a stuck key can't pull the backlog into memory
+func (c *Channel) keyPendingFull() bool {
+	max := c.options.MemQueueSize
+	if max < 1 {
+		max = 1
+	}
+	return atomic.LoadInt64(&c.pendingCount) >= max
+}
+
+// notifyKeyReleased wakes messagePump to deliver released messages (or
+// read again if it stopped because keyPendingFull())
+func (c *Channel) notifyKeyReleased() {
+	select {
+	case c.keyReleasedChan <- 1:
+	default:
+	}
+}
+
+// AddClient adds a client to the Channel's client list, returning an
+// error if the channel's mode or max clients do not allow another client
+func (c *Channel) AddClient(client Consumer) error {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, cli := range c.clients {
+		if cli == client {
+			return nil
+		}
+	}
+
+	if c.mode == channelModeExclusive && len(c.clients) > 0 {
+		return ErrChannelExclusive
+	}
+	if c.maxClients > 0 && len(c.clients) >= c.maxClients {
+		return ErrTooManyClients
+	}
+
+	c.clients = append(c.clients, client)
+	c.nsqd.postTopologyEvent(eventClientConnected, c.topicName, c.name, client)
+	return nil
+}
+
+// RemoveClient removes a client from the Channel's client list and
+// immediately requeues any messages it still had in flight
+func (c *Channel) RemoveClient(client Consumer) {
+	c.Lock()
+
+	wasActive := len(c.clients) != 0 && c.clients[0] == client
+	if len(c.clients) != 0 {
+		finalClients := make([]Consumer, 0, len(c.clients)-1)
+		for _, cli := range c.clients {
+			if cli != client {
+				finalClients = append(finalClients, cli)
+			}
+		}
+		if len(finalClients) != len(c.clients) {
+			c.nsqd.postTopologyEvent(eventClientDisconnected, c.topicName, c.name, client)
+		}
+		c.clients = finalClients
+	}
+
+	if wasActive && c.mode == channelModeFailover {
+		// promote the next standby
+		c.notifyDispatcher()
+	}
+
+	if len(c.clients) == 0 && c.ephemeralChannel == true {
+		go c.deleter.Do(func() { c.deleteCallback(c) })
+	}
+
+	var inFlight []*Timeout
+	if atomic.LoadInt32(&c.exitFlag) == 0 {
+		for id, item := range c.inFlightMessages {
+			if item.Value.(*inFlightMessage).client == client {
+				delete(c.inFlightMessages, id)
+				inFlight = append(inFlight, item)
+			}
+		}
+	}
+
+	c.Unlock()
+
+	for _, item := range inFlight {
+		c.timeouts.Remove(item)
+		c.doRequeue(item.Value.(*inFlightMessage).msg)
+	}
+}
+
+// FindClient returns the client with the given ID or remote address
+func (c *Channel) FindClient(id int64, address string) (Consumer, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, client := range c.clients {
+		stats := client.Stats()
+		if (id != 0 && stats.id == id) || (address != "" && stats.address == address) {
+			return client, nil
+		}
+	}
+
+	return nil, errors.New("client not found")
+}
+
+func (c *Channel) StartInFlightTimeout(msg *nsq.Message, client Consumer) error {
+	now := time.Now()
+	value := &inFlightMessage{msg, client, now}
+	item := NewTimeout(value, now.Add(c.nsqd.MsgTimeout()), c)
+	err := c.pushInFlightMessage(item)
+	if err != nil {
+		return err
+	}
+	c.timeouts.Add(item)
+	return nil
+}
+
+func (c *Channel) StartDeferredTimeout(msg *nsq.Message, timeout time.Duration) error {
+	item := NewTimeout(msg, time.Now().Add(timeout), c)
+	err := c.pushDeferredMessage(item)
+	if err != nil {
+		return err
+	}
+	c.timeouts.Add(item)
+	return nil
+}
+
+// doRequeue performs the low level operations to requeue a message
+func (c *Channel) doRequeue(msg *nsq.Message) error {
+	if atomic.LoadInt32(&c.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+	c.incomingMsgChan <- msg
+	atomic.AddUint64(&c.requeueCount, 1)
+	return nil
+}
+
+// pushInFlightMessage atomically adds a message to the in-flight dictionary
+func (c *Channel) pushInFlightMessage(item *Timeout) error {
+	c.Lock()
+	defer c.Unlock()
+
+	id := item.Value.(*inFlightMessage).msg.Id
+	_, ok := c.inFlightMessages[string(id)]
+	if ok {
+		return errors.New("ID already in flight")
+	}
+	c.inFlightMessages[string(id)] = item
+
+	return nil
+}
+
+// popInFlightMessage atomically removes a message from the in-flight dictionary
+func (c *Channel) popInFlightMessage(client Consumer, id []byte) (*Timeout, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	item, ok := c.inFlightMessages[string(id)]
+	if !ok {
+		return nil, errors.New("ID not in flight")
+	}
+
+	if item.Value.(*inFlightMessage).client != client {
+		return nil, errors.New("client does not own ID")
+	}
+
+	delete(c.inFlightMessages, string(id))
+
+	return item, nil
+}
+
+func (c *Channel) pushDeferredMessage(item *Timeout) error {
+	c.Lock()
+	defer c.Unlock()
+
+	id := item.Value.(*nsq.Message).Id
+	_, ok := c.deferredMessages[string(id)]
+	if ok {
+		return errors.New("ID already deferred")
+	}
+	c.deferredMessages[string(id)] = item
+
+	return nil
+}
+
+func (c *Channel) popDeferredMessage(id []byte) (*Timeout, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	item, ok := c.deferredMessages[string(id)]
+	if !ok {
+		return nil, errors.New("ID not deferred")
+	}
+	delete(c.deferredMessages, string(id))
+
+	return item, nil
+}
+
+// Router handles the muxing of incoming Channel messages, either writing
+// to the in-memory channel or to the backend
+func (c *Channel) router() {
+	var msgBuf bytes.Buffer
+	var msgs []*nsq.Message
+	for {
+		select {
+		case msg, ok := <-c.incomingMsgChan:
+			if !ok {
+				goto exit
+			}
+			msgs = append(msgs[:0], msg)
+		case <-c.expiredChan:
+			msgs = c.takeExpired()
+		}
+
+		for _, msg := range msgs {
+			err := c.routeMessage(&msgBuf, msg)
+			if err != nil {
+				log.Printf("CHANNEL(%s) ERROR: failed to write message to backend - %s", c.name, err.Error())
+				// theres not really much we can do at this point, you're certainly
+				// going to lose messages...
+				atomic.AddUint64(&c.dropCount, 1)
+			}
+		}
+	}
+
+exit:
+	log.Printf("CHANNEL(%s): closing ... router", c.name)
+}
+
+// routeMessage writes a message to memory or, if that (or the node's memory
+// budget) is full, the backend
+func (c *Channel) routeMessage(msgBuf *bytes.Buffer, msg *nsq.Message) error {
+	atomic.StoreInt64(&c.routeStarted, time.Now().UnixNano())
+	defer atomic.StoreInt64(&c.routeStarted, 0)
+
+	// keyed messages for an ordered channel must come back out in order so
+	// they skip memory (unless ephemeral, which has no backend to spill to)
+	if msg.Key != nil && c.IsOrdered() && !c.ephemeralChannel {
+		return WriteMessageToBackend(msgBuf, msg, c)
+	}
+
+	size := messageSize(msg)
+	if c.memoryBudget.Reserve(size) {
+		atomic.AddInt64(&c.memoryBytes, size)
+		select {
+		case c.memoryMsgChan <- msg:
+			c.memoryAges.Enqueued(messageTimestampNano(msg))
+			return nil
+		default:
+		}
+		atomic.AddInt64(&c.memoryBytes, -size)
+		c.memoryBudget.Release(size)
+	}
+
+	return WriteMessageToBackend(msgBuf, msg, c)
+}
+
+// messagePump reads messages from either memory or backend and writes
+// to the client output go channel
+//
+// it is also performs in-flight accounting and initiates the auto-requeue
+// goroutine
+func (c *Channel) messagePump() {
+	var msg *nsq.Message
+	var buf []byte
+	var err error
+	var memoryMsgChan chan *nsq.Message
+	var backendChan chan []byte
+
+	for {
+		// do an extra check for closed exit before we select on all the memory/backend/exitChan
+		// this solves the case where we are closed and something else is draining clientMsgChan into
+		// backend. we don't want to reverse that
+		if atomic.LoadInt32(&c.exitFlag) == 1 {
+			goto exit
+		}
+
+		// released keyed messages are delivered ahead of anything queued
+		msg = c.nextReleased()
+		if msg == nil {
+			if c.keyPendingFull() {
+				memoryMsgChan = nil
+				backendChan = nil
+			} else {
+				memoryMsgChan = c.memoryMsgChan
+				backendChan = c.backend.ReadChan()
+			}
+
+			select {
+			case msg = <-memoryMsgChan:
+				c.MemoryDequeued(msg)
+			case buf = <-backendChan:
+				msg, err = decodeBackendMessage(buf)
+				if err != nil {
+					log.Printf("ERROR: failed to decode message - %s", err.Error())
+					continue
+				}
+			case <-c.keyReleasedChan:
+				continue
+			case <-c.exitChan:
+				goto exit
+			}
+		}
+
+		if msg.Key != nil && c.IsOrdered() && c.holdKeyed(msg) {
+			continue
+		}
+
+		msg.Attempts++
+
+		atomic.StoreInt32(&c.bufferedCount, 1)
+		c.clientMsgChan <- msg
+		atomic.StoreInt32(&c.bufferedCount, 0)
+		// the client will call back to mark as in-flight w/ it's info
+	}
+
+exit:
+	log.Printf("CHANNEL(%s): closing ... messagePump", c.name)
+	close(c.clientMsgChan)
+}
+
+// HandleTimeout implements the TimeoutHandler interface, requeueing
+// deferred messages and in-flight messages the client took too long
+// to respond to
+func (c *Channel) HandleTimeout(item *Timeout) {
+	// a closing channel flushes them to the backend instead
+	if c.Exiting() {
+		return
+	}
+
+	switch value := item.Value.(type) {
+	case *nsq.Message:
+		_, err := c.popDeferredMessage(value.Id)
+		if err != nil {
+			return
+		}
+		c.requeueExpired(value)
+	case *inFlightMessage:
+		_, err := c.popInFlightMessage(value.client, value.msg.Id)
+		if err != nil {
+			return
+		}
+		atomic.AddUint64(&c.timeoutCount, 1)
+		value.client.TimedOutMessage()
+		c.requeueExpired(value.msg)
+	}
+}
+
+// requeueExpired hands a message whose timeout expired to the router, unlike
+// doRequeue() it never blocks (the timing wheel's workers are shared by
+// every channel so one that is stuck routing mustn't hold up the others)
+func (c *Channel) requeueExpired(msg *nsq.Message) {
+	c.expiredMutex.Lock()
+	c.expired = append(c.expired, msg)
+	c.expiredMutex.Unlock()
+	atomic.AddUint64(&c.requeueCount, 1)
+
+	select {
+	case c.expiredChan <- 1:
+	default:
+	}
+}
+
+// takeExpired returns (and clears) the messages passed to requeueExpired()
+func (c *Channel) takeExpired() []*nsq.Message {
+	c.expiredMutex.Lock()
+	defer c.expiredMutex.Unlock()
+	msgs := c.expired
+	c.expired = nil
+	return msgs
+}
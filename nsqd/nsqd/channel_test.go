@@ -0,0 +1,623 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"bytes"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// ensure that we can push a message through a topic and get it out of a channel
+func TestPutMessage(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+
+	topicName := "test_put_message" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	channel1 := topic.GetChannel("ch")
+
+	msg := nsq.NewMessage([]byte("abcdefghijklmnop"), []byte("test"))
+	topic.PutMessage(msg)
+
+	outputMsg := <-channel1.clientMsgChan
+	assert.Equal(t, msg.Id, outputMsg.Id)
+	assert.Equal(t, msg.Body, outputMsg.Body)
+}
+
+// ensure that both channels get the same message
+func TestPutMessage2Chan(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+
+	topicName := "test_put_message_2chan" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	channel1 := topic.GetChannel("ch1")
+	channel2 := topic.GetChannel("ch2")
+
+	msg := nsq.NewMessage([]byte("abcdefghijklmnop"), []byte("test"))
+	topic.PutMessage(msg)
+
+	outputMsg1 := <-channel1.clientMsgChan
+	assert.Equal(t, msg.Id, outputMsg1.Id)
+	assert.Equal(t, msg.Body, outputMsg1.Body)
+
+	outputMsg2 := <-channel2.clientMsgChan
+	assert.Equal(t, msg.Id, outputMsg2.Id)
+	assert.Equal(t, msg.Body, outputMsg2.Body)
+}
+
+func TestInFlightWorker(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	options := NewOptions()
+	options.MsgTimeout = 300 * time.Millisecond
+	nsqd := New(options)
+	defer nsqd.Exit()
+
+	topic := nsqd.GetTopic("topic")
+	channel := topic.GetChannel("channel")
+
+	for i := 0; i < 1000; i++ {
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte("test"))
+		channel.StartInFlightTimeout(msg, NewClientV2(nil, nsqd))
+	}
+
+	assert.Equal(t, len(channel.inFlightMessages), 1000)
+	assert.Equal(t, nsqd.timeouts.Len(), 1000)
+
+	time.Sleep(350 * time.Millisecond)
+
+	assert.Equal(t, len(channel.inFlightMessages), 0)
+	assert.Equal(t, nsqd.timeouts.Len(), 0)
+}
+
+// ensure a channel whose router is stuck doesn't hold up the timing wheel
+func TestTimeoutStuckRouter(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	options := NewOptions()
+	options.MsgTimeout = 50 * time.Millisecond
+	nsqd := New(options)
+	defer nsqd.Exit()
+
+	topicName := "test_stuck_router" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+	channel := topic.GetChannel("ch")
+
+	// keyed messages on an ordered channel are written to the backend, while
+	// it's locked the router blocks on the first and the second fills
+	// incomingMsgChan
+	backend := channel.backend.(*DiskQueue)
+	backend.Lock()
+	channel.SetOrdered(true)
+	for i := 0; i < 2; i++ {
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte("test"))
+		msg.Key = []byte("key")
+		channel.PutMessage(msg)
+	}
+
+	client := NewClientV2(nil, nsqd)
+	for i := 0; i < 10; i++ {
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte("test"))
+		channel.StartInFlightTimeout(msg, client)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	channel.Lock()
+	assert.Equal(t, len(channel.inFlightMessages), 0)
+	channel.Unlock()
+	assert.Equal(t, nsqd.timeouts.Len(), 0)
+	assert.Equal(t, len(channel.takeExpired()), 10)
+
+	backend.Unlock()
+}
+
+// ensure a removed client's in-flight messages are requeued immediately
+func TestRemoveClientRequeue(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+
+	topicName := "test_remove_client" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+	channel := topic.GetChannel("ch")
+
+	client := NewClientV2(nil, nsqd)
+	client.Channel = channel
+	other := NewClientV2(nil, nsqd)
+	channel.AddClient(client)
+	for i := 0; i < 5; i++ {
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte("test"))
+		if i == 0 {
+			channel.StartInFlightTimeout(msg, other)
+		} else {
+			channel.StartInFlightTimeout(msg, client)
+		}
+	}
+
+	channel.RemoveClient(client)
+	assert.Equal(t, len(channel.clients), 0)
+	assert.Equal(t, len(channel.inFlightMessages), 1)
+	assert.Equal(t, nsqd.timeouts.Len(), 1)
+	for i := 0; i < 4; i++ {
+		select {
+		case <-channel.clientMsgChan:
+		case <-time.After(time.Second):
+			t.Fatalf("in-flight message %d not requeued", i)
+		}
+	}
+	assert.Equal(t, channel.requeueCount, uint64(4))
+}
+
+func TestClientDrain(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+
+	topicName := "test_drain" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+
+	client := NewClientV2(nil, nsqd)
+	client.Channel = topic.GetChannel("ch")
+	client.SetClientReadyCount(10)
+	assert.Equal(t, client.IsReadyForMessages(), true)
+
+	client.Drain()
+	assert.Equal(t, client.IsReadyForMessages(), false)
+	assert.Equal(t, client.ReadyCount, int64(0))
+
+	// RDY sent while drained is deferred
+	client.SetClientReadyCount(5)
+	assert.Equal(t, client.IsReadyForMessages(), false)
+
+	client.UnDrain()
+	assert.Equal(t, client.IsReadyForMessages(), true)
+	assert.Equal(t, client.ReadyCount, int64(5))
+}
+
+func TestChannelModes(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+
+	topicName := "test_channel_modes" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+	channel := topic.GetChannel("ch")
+
+	client1 := NewClientV2(nil, nsqd)
+	client2 := NewClientV2(nil, nsqd)
+	client3 := NewClientV2(nil, nsqd)
+	client1.Channel = channel
+	client2.Channel = channel
+	client3.Channel = channel
+
+	err := channel.SetOption("mode", "exclusive")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, channel.AddClient(client1), nil)
+	assert.Equal(t, channel.AddClient(client2), ErrChannelExclusive)
+
+	err = channel.SetOption("mode", "failover")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, channel.AddClient(client2), nil)
+	assert.Equal(t, channel.IsActiveClient(client1), true)
+	assert.Equal(t, channel.IsActiveClient(client2), false)
+
+	err = channel.SetOption("max_clients", "2")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, channel.AddClient(client3), ErrTooManyClients)
+	assert.Equal(t, channel.Options(), []string{"mode=failover", "max_clients=2"})
+
+	// the standby is promoted when the active client goes away
+	channel.RemoveClient(client1)
+	assert.Equal(t, channel.IsActiveClient(client2), true)
+	channel.RemoveClient(client2)
+
+	assert.NotEqual(t, channel.SetOption("mode", "invalid"), nil)
+}
+
+// ensure messages with the same key are delivered one at a time, in order
+func TestOrderedChannel(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+
+	topicName := "test_ordered" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+	channel := topic.GetChannel("ch")
+	channel.SetOrdered(true)
+	channel.SetOrdered(true)
+	assert.Equal(t, topic.hasOrderedChannel(), true)
+	client := NewClientV2(nil, nsqd)
+
+	for _, body := range []string{"a1", "a2", "b1", "a3"} {
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte(body))
+		msg.Key = []byte(body[:1])
+		err := topic.PutMessage(msg)
+		assert.Equal(t, err, nil)
+	}
+
+	a1 := <-channel.clientMsgChan
+	assert.Equal(t, string(a1.Body), "a1")
+	channel.StartInFlightTimeout(a1, client)
+	b1 := <-channel.clientMsgChan
+	assert.Equal(t, string(b1.Body), "b1")
+	channel.StartInFlightTimeout(b1, client)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, channel.Depth(), int64(2))
+
+	// finishing a1 releases a2
+	err := channel.FinishMessage(client, a1.Id)
+	assert.Equal(t, err, nil)
+	a2 := <-channel.clientMsgChan
+	assert.Equal(t, string(a2.Body), "a2")
+	channel.StartInFlightTimeout(a2, client)
+
+	// a requeued a2 is redelivered before a3
+	err = channel.RequeueMessage(client, a2.Id, 0)
+	assert.Equal(t, err, nil)
+	a2 = <-channel.clientMsgChan
+	assert.Equal(t, string(a2.Body), "a2")
+	assert.Equal(t, a2.Attempts, uint16(2))
+	channel.StartInFlightTimeout(a2, client)
+
+	err = channel.FinishMessage(client, a2.Id)
+	assert.Equal(t, err, nil)
+	a3 := <-channel.clientMsgChan
+	assert.Equal(t, string(a3.Body), "a3")
+
+	err = topic.DeleteExistingChannel("ch")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, topic.hasOrderedChannel(), false)
+}
+
+// ensure a stuck key stops the channel reading rather than holding back
+// more than --mem-queue-size messages
+func TestOrderedChannelPendingLimit(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	options := NewOptions()
+	options.MemQueueSize = 1
+	nsqd := New(options)
+	defer nsqd.Exit()
+
+	topicName := "test_ordered_limit" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+	channel := topic.GetChannel("ch")
+	channel.SetOrdered(true)
+	client := NewClientV2(nil, nsqd)
+
+	for _, body := range []string{"a1", "a2", "a3", "b1"} {
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte(body))
+		msg.Key = []byte(body[:1])
+		err := topic.PutMessage(msg)
+		assert.Equal(t, err, nil)
+	}
+
+	a1 := <-channel.clientMsgChan
+	assert.Equal(t, string(a1.Body), "a1")
+	channel.StartInFlightTimeout(a1, client)
+
+	// a2 is held back, a3 and b1 stay queued
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, atomic.LoadInt64(&channel.pendingCount), int64(1))
+	assert.Equal(t, channel.backend.Depth(), int64(2))
+
+	err := channel.FinishMessage(client, a1.Id)
+	assert.Equal(t, err, nil)
+	a2 := <-channel.clientMsgChan
+	assert.Equal(t, string(a2.Body), "a2")
+	channel.StartInFlightTimeout(a2, client)
+
+	// a3 is held back in turn so b1 waits for a2 too
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, channel.backend.Depth(), int64(1))
+
+	err = channel.FinishMessage(client, a2.Id)
+	assert.Equal(t, err, nil)
+	a3 := <-channel.clientMsgChan
+	assert.Equal(t, string(a3.Body), "a3")
+	b1 := <-channel.clientMsgChan
+	assert.Equal(t, string(b1.Body), "b1")
+}
+
+// ensure emptying a channel releases the keys of the requeued messages
+// it discards
+func TestOrderedChannelEmpty(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+
+	topicName := "test_ordered_empty" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+	channel := topic.GetChannel("ch")
+	channel.SetOrdered(true)
+	client := NewClientV2(nil, nsqd)
+
+	publish := func(body string) {
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte(body))
+		msg.Key = []byte(body[:1])
+		err := topic.PutMessage(msg)
+		assert.Equal(t, err, nil)
+	}
+
+	publish("a1")
+	a1 := <-channel.clientMsgChan
+	channel.StartInFlightTimeout(a1, client)
+
+	// a2 is held back and b1 stops messagePump so the requeued a1 (which
+	// still owns its key) stays queued
+	publish("a2")
+	publish("b1")
+	time.Sleep(50 * time.Millisecond)
+	err := channel.RequeueMessage(client, a1.Id, 0)
+	assert.Equal(t, err, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	err = channel.Empty()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, atomic.LoadInt64(&channel.pendingCount), int64(0))
+	b1 := <-channel.clientMsgChan
+	assert.Equal(t, string(b1.Body), "b1")
+
+	publish("a3")
+	select {
+	case a3 := <-channel.clientMsgChan:
+		assert.Equal(t, string(a3.Body), "a3")
+	case <-time.After(time.Second):
+		t.Fatal("a3 was never delivered")
+	}
+}
+
+func TestKeyedBackendMessage(t *testing.T) {
+	var buf bytes.Buffer
+
+	msg := nsq.NewMessage([]byte("abcdefghijklmnop"), []byte("test"))
+	msg.Key = []byte("key")
+	err := encodeBackendMessage(&buf, msg)
+	assert.Equal(t, err, nil)
+
+	decoded, err := decodeBackendMessage(buf.Bytes())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, decoded.Key, msg.Key)
+	assert.Equal(t, decoded.Body, msg.Body)
+	assert.Equal(t, decoded.Id, msg.Id)
+
+	// un-keyed messages are encoded exactly as before
+	buf.Reset()
+	msg.Key = nil
+	encodeBackendMessage(&buf, msg)
+	decoded, err = nsq.DecodeMessage(buf.Bytes())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, decoded.Body, msg.Body)
+
+	// as are the attributes, after the key
+	buf.Reset()
+	msg.Key = []byte("key")
+	msg.ReplyTo = "replies"
+	msg.CorrelationId = "1"
+	encodeBackendMessage(&buf, msg)
+	decoded, err = decodeBackendMessage(buf.Bytes())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, decoded.Key, msg.Key)
+	assert.Equal(t, decoded.ReplyTo, "replies")
+	assert.Equal(t, decoded.CorrelationId, "1")
+	assert.Equal(t, decoded.Body, msg.Body)
+	timestamp, err := backendMessageTimestamp(buf.Bytes())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, timestamp, msg.Timestamp)
+}
+
+func TestQueueAges(t *testing.T) {
+	var ages queueAges
+	assert.Equal(t, ages.Oldest(), int64(0))
+
+	ages.Enqueued(30)
+	ages.Enqueued(10)
+	ages.Enqueued(20)
+	assert.Equal(t, ages.Oldest(), int64(10))
+	ages.Dequeued()
+	assert.Equal(t, ages.Oldest(), int64(10))
+	ages.Dequeued()
+	assert.Equal(t, ages.Oldest(), int64(20))
+	ages.Dequeued()
+	assert.Equal(t, ages.Oldest(), int64(0))
+
+	// a message can be dequeued before it is recorded
+	ages.Dequeued()
+	ages.Enqueued(40)
+	assert.Equal(t, ages.Oldest(), int64(0))
+	ages.Enqueued(50)
+	assert.Equal(t, ages.Oldest(), int64(50))
+}
+
+func TestChannelMessageAge(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	published := time.Now().Add(-time.Minute)
+	putMessages := func(nsqd *NSQd, channel *Channel) {
+		for i := 0; i < 4; i++ {
+			msg := nsq.NewMessage(<-nsqd.idChan, []byte("test"))
+			msg.Timestamp = published.Unix()
+			msg.TimestampNano = published.UnixNano()
+			channel.PutMessage(msg)
+		}
+	}
+	// (one message is held by messagePump, the rest are queued)
+	waitForQueued := func(channel *Channel) (time.Duration, time.Duration) {
+		var memoryAge, backendAge time.Duration
+		for i := 0; i < 100; i++ {
+			memoryAge, backendAge = channel.OldestMessageAge()
+			if channel.Depth() == 4 && (memoryAge > 0 || backendAge > 0) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return memoryAge, backendAge
+	}
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+	topicName := "test_channel_message_age" + strconv.Itoa(int(time.Now().Unix()))
+	channel := nsqd.GetTopic(topicName).GetChannel("ch")
+	defer nsqd.DeleteExistingTopic(topicName)
+	assert.Equal(t, channel.DrainETA(), time.Duration(0))
+
+	putMessages(nsqd, channel)
+	memoryAge, backendAge := waitForQueued(channel)
+	assert.Equal(t, memoryAge >= time.Minute && memoryAge < 2*time.Minute, true)
+	assert.Equal(t, backendAge, time.Duration(0))
+	assert.Equal(t, channel.DrainETA(), time.Duration(-1))
+
+	client := NewClientV2(nil, nsqd)
+	msg := <-channel.clientMsgChan
+	channel.StartInFlightTimeout(msg, client)
+	channel.FinishMessage(client, msg.Id)
+	assert.Equal(t, channel.DrainETA() > 0, true)
+
+	// messages on disk are aged by the one at the head of the backend
+	options := NewOptions()
+	options.MemQueueSize = 0
+	nsqd2 := New(options)
+	defer nsqd2.Exit()
+	channel = nsqd2.GetTopic(topicName + "_disk").GetChannel("ch")
+	defer nsqd2.DeleteExistingTopic(topicName + "_disk")
+
+	putMessages(nsqd2, channel)
+	_, backendAge = waitForQueued(channel)
+	assert.Equal(t, backendAge >= time.Minute && backendAge < 2*time.Minute, true)
+}
+
+// benchmarkChannels creates a topic with `count` (ephemeral) channels
+func benchmarkChannels(b *testing.B, name string, count int) (*NSQd, []*Channel) {
+	log.SetOutput(ioutil.Discard)
+	nsqd := New(NewOptions())
+	topic := nsqd.GetTopic(name + strconv.Itoa(b.N) + "_" + strconv.Itoa(int(time.Now().Unix())))
+	channels := make([]*Channel, count)
+	for i := range channels {
+		channels[i] = topic.GetChannel("ch" + strconv.Itoa(i) + "#ephemeral")
+	}
+	return nsqd, channels
+}
+
+func benchmarkChannelInFlight(b *testing.B, count int) {
+	b.StopTimer()
+	nsqd, channels := benchmarkChannels(b, "bench_channel_in_flight", count)
+	defer log.SetOutput(os.Stdout)
+	defer nsqd.Exit()
+	client := NewClientV2(nil, nsqd)
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		channel := channels[i%count]
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte("test"))
+		channel.StartInFlightTimeout(msg, client)
+		channel.FinishMessage(client, msg.Id)
+	}
+	b.StopTimer()
+}
+
+func BenchmarkChannelInFlight10(b *testing.B) {
+	benchmarkChannelInFlight(b, 10)
+}
+
+func BenchmarkChannelInFlight1000(b *testing.B) {
+	benchmarkChannelInFlight(b, 1000)
+}
+
+// measures how long after its deadline a deferred requeue is delivered
+func benchmarkChannelRequeueLatency(b *testing.B, count int) {
+	b.StopTimer()
+	nsqd, channels := benchmarkChannels(b, "bench_channel_requeue", count)
+	defer log.SetOutput(os.Stdout)
+	defer nsqd.Exit()
+	client := NewClientV2(nil, nsqd)
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		channel := channels[i%count]
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte("test"))
+		channel.StartInFlightTimeout(msg, client)
+		channel.RequeueMessage(client, msg.Id, time.Millisecond)
+		<-channel.clientMsgChan
+	}
+	b.StopTimer()
+}
+
+func BenchmarkChannelRequeueLatency10(b *testing.B) {
+	benchmarkChannelRequeueLatency(b, 10)
+}
+
+func BenchmarkChannelRequeueLatency1000(b *testing.B) {
+	benchmarkChannelRequeueLatency(b, 1000)
+}
+
+// reports the CPU used while channels (each with a message in flight) sit idle
+func benchmarkChannelIdle(b *testing.B, count int) {
+	var start, end syscall.Rusage
+
+	b.StopTimer()
+	nsqd, channels := benchmarkChannels(b, "bench_channel_idle", count)
+	defer log.SetOutput(os.Stdout)
+	defer nsqd.Exit()
+	client := NewClientV2(nil, nsqd)
+	for _, channel := range channels {
+		channel.StartInFlightTimeout(nsq.NewMessage(<-nsqd.idChan, []byte("test")), client)
+	}
+	// don't count collecting what was allocated setting up
+	runtime.GC()
+	syscall.Getrusage(syscall.RUSAGE_SELF, &start)
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	b.StopTimer()
+	syscall.Getrusage(syscall.RUSAGE_SELF, &end)
+	cpu := time.Duration(end.Utime.Nano() + end.Stime.Nano() - start.Utime.Nano() - start.Stime.Nano())
+	b.ReportMetric(float64(cpu.Nanoseconds())/float64(b.N), "cpu-ns/op")
+}
+
+func BenchmarkChannelIdle10(b *testing.B) {
+	benchmarkChannelIdle(b, 10)
+}
+
+func BenchmarkChannelIdle1000(b *testing.B) {
+	benchmarkChannelIdle(b, 1000)
+}
@@ -0,0 +1,353 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"bufio"
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// used to assign each client a unique ID
+var clientIDSequence int64
+
+// the output buffer used until the client's options are applied,
+// see SetOutputBuffer()
+const (
+	defaultOutputBufferSize    = 16 * 1024
+	defaultOutputBufferTimeout = 250 * time.Millisecond
+	minOutputBufferSize        = 64
+)
+
+// how long a write to the client may block before the client is considered dead
+const clientWriteTimeout = 3 * time.Second
+
+type ClientV2 struct {
+	net.Conn
+	sync.Mutex
+	nsqd            *NSQd
+	ID              int64
+	frameBuf        bytes.Buffer
+	Reader          *bufio.Reader
+	Writer          *bufio.Writer
+	State           int32
+	ReadyCount      int64
+	LastReadyCount  int64
+	InFlightCount   int64
+	MessageCount    uint64
+	FinishCount     uint64
+	RequeueCount    uint64
+	ConnectTime     time.Time
+	Channel         *Channel
+	ExitChan        chan int
+	ShortIdentifier string
+	LongIdentifier  string
+	UserAgent       string
+
+	// messages with attributes are sent as FrameTypeMessageWithAttributes
+	// (negotiated by IDENTIFY)
+	MessageAttributes bool
+
+	// frames are buffered in Writer and flushed once OutputBufferTimeout
+	// has passed (or the client can't be sent any more messages), a timeout
+	// of 0 flushes every frame
+	OutputBufferSize    int
+	OutputBufferTimeout time.Duration
+	BytesWritten        uint64
+	FlushCount          uint64
+
+	// while drained the client receives no messages and RDY counts it
+	// sends are saved (to be restored when it is undrained)
+	drainMutex        sync.Mutex
+	drained           int32
+	drainedReadyCount int64
+
+	// messages handed to the client by its channel's dispatcher, see Deliver()
+	clientMsgChan   chan *nsq.Message
+	weight          int
+	deliveryMutex   sync.Mutex
+	deliveryStopped bool
+
+	// recent finishes and requeues per second
+	finishRate  *rateCounter
+	requeueRate *rateCounter
+}
+
+func NewClientV2(conn net.Conn, nsqd *NSQd) *ClientV2 {
+	var identifier string
+	if conn != nil {
+		identifier, _, _ = net.SplitHostPort(conn.RemoteAddr().String())
+	}
+	c := &ClientV2{
+		net.Conn:            conn,
+		nsqd:                nsqd,
+		ID:                  atomic.AddInt64(&clientIDSequence, 1),
+		clientMsgChan:       make(chan *nsq.Message, 1),
+		weight:              1,
+		ExitChan:            make(chan int),
+		ConnectTime:         time.Now(),
+		ShortIdentifier:     identifier,
+		LongIdentifier:      identifier,
+		OutputBufferSize:    defaultOutputBufferSize,
+		OutputBufferTimeout: defaultOutputBufferTimeout,
+		finishRate:          newRateCounter(),
+		requeueRate:         newRateCounter(),
+	}
+	c.Writer = bufio.NewWriterSize(clientWriter{c}, c.OutputBufferSize)
+	return c
+}
+
+// clientWriter counts the writes (flushes of the output buffer)
+// made to the client's connection
+type clientWriter struct {
+	c *ClientV2
+}
+
+func (w clientWriter) Write(p []byte) (int, error) {
+	n, err := w.c.Conn.Write(p)
+	atomic.AddUint64(&w.c.BytesWritten, uint64(n))
+	atomic.AddUint64(&w.c.FlushCount, 1)
+	return n, err
+}
+
+// SetOutputBuffer changes the size of the client's output buffer and how long
+// frames can wait in it, a size <= 0 disables buffering
+func (c *ClientV2) SetOutputBuffer(size int, timeout time.Duration) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if size <= 0 {
+		size = minOutputBufferSize
+		timeout = 0
+	}
+	if timeout < 0 {
+		timeout = 0
+	}
+
+	err := c.flush()
+	if err != nil {
+		return err
+	}
+	if size != c.OutputBufferSize {
+		c.Writer = bufio.NewWriterSize(clientWriter{c}, size)
+	}
+	c.OutputBufferSize = size
+	c.OutputBufferTimeout = timeout
+	return nil
+}
+
+// Flush writes any buffered frames to the client
+func (c *ClientV2) Flush() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.flush()
+}
+
+// flush writes any buffered frames to the client
+//
+// this expects the caller to handle locking
+func (c *ClientV2) flush() error {
+	if c.Writer.Buffered() == 0 {
+		return nil
+	}
+	c.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+	err := c.Writer.Flush()
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return errors.New("timed out trying to send to client")
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *ClientV2) String() string {
+	return c.RemoteAddr().String()
+}
+
+func (c *ClientV2) Stats() ClientStats {
+	return ClientStats{
+		id:            c.ID,
+		version:       "V2",
+		address:       c.RemoteAddr().String(),
+		name:          c.ShortIdentifier,
+		state:         atomic.LoadInt32(&c.State),
+		readyCount:    atomic.LoadInt64(&c.ReadyCount),
+		inFlightCount: atomic.LoadInt64(&c.InFlightCount),
+		messageCount:  atomic.LoadUint64(&c.MessageCount),
+		finishCount:   atomic.LoadUint64(&c.FinishCount),
+		requeueCount:  atomic.LoadUint64(&c.RequeueCount),
+		connectTime:   c.ConnectTime,
+		drained:       c.IsDrained(),
+		weight:        c.weight,
+		bytesWritten:  atomic.LoadUint64(&c.BytesWritten),
+		flushCount:    atomic.LoadUint64(&c.FlushCount),
+		userAgent:     c.UserAgent,
+		finishRate:    c.finishRate.Rate(),
+		requeueRate:   c.requeueRate.Rate(),
+	}
+}
+
+func (c *ClientV2) IsReadyForMessages() bool {
+	if c.Channel.IsPaused() || c.IsDrained() {
+		return false
+	}
+
+	// the previous message has not been picked up by the message pump yet
+	if len(c.clientMsgChan) == cap(c.clientMsgChan) {
+		return false
+	}
+
+	readyCount := atomic.LoadInt64(&c.ReadyCount)
+	lastReadyCount := atomic.LoadInt64(&c.LastReadyCount)
+	inFlightCount := atomic.LoadInt64(&c.InFlightCount)
+
+	if c.nsqd.isVerbose() {
+		log.Printf("[%s] state rdy: %4d lastrdy: %4d inflt: %4d", c,
+			readyCount, lastReadyCount, inFlightCount)
+	}
+
+	if inFlightCount >= lastReadyCount || readyCount <= 0 {
+		return false
+	}
+
+	return true
+}
+
+func (c *ClientV2) SetReadyCount(count int64) {
+	atomic.StoreInt64(&c.ReadyCount, count)
+	atomic.StoreInt64(&c.LastReadyCount, count)
+	c.tryUpdateReadyState()
+}
+
+// SetClientReadyCount handles a RDY sent by the client, which is deferred
+// until the client is undrained
+func (c *ClientV2) SetClientReadyCount(count int64) {
+	c.drainMutex.Lock()
+	defer c.drainMutex.Unlock()
+
+	if c.IsDrained() {
+		c.drainedReadyCount = count
+		return
+	}
+	c.SetReadyCount(count)
+}
+
+// IsDrained returns a boolean indicating if the client was drained by an admin
+func (c *ClientV2) IsDrained() bool {
+	return atomic.LoadInt32(&c.drained) == 1
+}
+
+// Drain forces the client into RDY 0, it can still FIN/REQ
+// messages already in flight
+func (c *ClientV2) Drain() {
+	c.drainMutex.Lock()
+	defer c.drainMutex.Unlock()
+
+	if c.IsDrained() {
+		return
+	}
+	c.drainedReadyCount = atomic.LoadInt64(&c.LastReadyCount)
+	atomic.StoreInt32(&c.drained, 1)
+	c.SetReadyCount(0)
+}
+
+// UnDrain restores the last RDY count the client asked for
+func (c *ClientV2) UnDrain() {
+	c.drainMutex.Lock()
+	defer c.drainMutex.Unlock()
+
+	if !c.IsDrained() {
+		return
+	}
+	atomic.StoreInt32(&c.drained, 0)
+	c.SetReadyCount(c.drainedReadyCount)
+}
+
+// Deliver hands a message (already in-flight) to the client's message pump
+// returning false if it cannot accept it
+//
+// only the channel's dispatcher calls this, after IsReadyForMessages()
+func (c *ClientV2) Deliver(msg *nsq.Message) bool {
+	c.deliveryMutex.Lock()
+	defer c.deliveryMutex.Unlock()
+
+	if c.deliveryStopped || len(c.clientMsgChan) == cap(c.clientMsgChan) {
+		return false
+	}
+	c.SendingMessage()
+	c.clientMsgChan <- msg
+	return true
+}
+
+// StopDelivery prevents the dispatcher from handing the client any more messages
+func (c *ClientV2) StopDelivery() {
+	c.deliveryMutex.Lock()
+	defer c.deliveryMutex.Unlock()
+	c.deliveryStopped = true
+}
+
+// InFlight returns the number of messages the client has in flight
+func (c *ClientV2) InFlight() int64 {
+	return atomic.LoadInt64(&c.InFlightCount)
+}
+
+// Weight returns the relative share of messages the client asked for
+// (used by the weighted dispatch policy)
+func (c *ClientV2) Weight() int {
+	return c.weight
+}
+
+func (c *ClientV2) tryUpdateReadyState() {
+	// you can always *try* to wake up the dispatcher because in the cases
+	// where you cannot it is already going to re-evaluate the ready state.
+	// the atomic integer operations guarantee correctness of the value.
+	if c.Channel != nil {
+		c.Channel.notifyDispatcher()
+	}
+}
+
+func (c *ClientV2) FinishedMessage() {
+	atomic.AddUint64(&c.FinishCount, 1)
+	c.finishRate.Incr()
+	atomic.AddInt64(&c.InFlightCount, -1)
+	c.tryUpdateReadyState()
+}
+
+func (c *ClientV2) SendingMessage() {
+	atomic.AddInt64(&c.ReadyCount, -1)
+	atomic.AddInt64(&c.InFlightCount, 1)
+	atomic.AddUint64(&c.MessageCount, 1)
+}
+
+func (c *ClientV2) TimedOutMessage() {
+	atomic.AddInt64(&c.InFlightCount, -1)
+	c.tryUpdateReadyState()
+}
+
+func (c *ClientV2) RequeuedMessage() {
+	atomic.AddUint64(&c.RequeueCount, 1)
+	c.requeueRate.Incr()
+	atomic.AddInt64(&c.InFlightCount, -1)
+	c.tryUpdateReadyState()
+}
+
+func (c *ClientV2) StartClose() {
+	// Force the client into ready 0
+	c.SetReadyCount(0)
+	// mark this client as closing
+	atomic.StoreInt32(&c.State, nsq.StateClosing)
+	// TODO: start a timer to actually close the channel (in case the client doesn't do it first)
+}
+
+func (c *ClientV2) Pause() {
+	c.tryUpdateReadyState()
+}
+
+func (c *ClientV2) UnPause() {
+	c.tryUpdateReadyState()
+}
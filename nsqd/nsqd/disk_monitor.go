@@ -0,0 +1,79 @@
+package nsqd
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// how often free space on the data path is checked
+const diskCheckInterval = 5 * time.Second
+
+var ErrDiskFull = errors.New("disk full")
+
+// diskLoop periodically checks the free space available on dataPath,
+// switching nsqd into (and back out of) a read-only mode where publishes
+// are rejected
+//
+// nsqd enters read-only mode when free space drops below the low watermark
+// and leaves it once free space is back above the high watermark
+func (n *NSQd) diskLoop() {
+	if n.options.DiskLowWatermark == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(diskCheckInterval)
+	n.checkDisk()
+	for {
+		select {
+		case <-ticker.C:
+			n.checkDisk()
+		case <-n.exitChan:
+			goto exit
+		}
+	}
+
+exit:
+	log.Printf("DISK: closing")
+	ticker.Stop()
+}
+
+func (n *NSQd) checkDisk() {
+	free, err := diskFreeBytes(n.options.DataPath)
+	if err != nil {
+		log.Printf("ERROR: failed to stat data path %s - %s", n.options.DataPath, err.Error())
+		return
+	}
+	atomic.StoreUint64(&n.diskFreeBytes, free)
+
+	if !n.IsDiskFull() && free < n.options.DiskLowWatermark {
+		log.Printf("DISK: WARNING %d bytes free (< %d) entering read-only mode",
+			free, n.options.DiskLowWatermark)
+		atomic.StoreInt32(&n.diskFull, 1)
+	} else if n.IsDiskFull() && free > n.options.DiskHighWatermark {
+		log.Printf("DISK: %d bytes free (> %d) leaving read-only mode",
+			free, n.options.DiskHighWatermark)
+		atomic.StoreInt32(&n.diskFull, 0)
+	}
+}
+
+// IsDiskFull returns a boolean indicating if nsqd is in read-only mode
+// (because it is low on disk space)
+func (n *NSQd) IsDiskFull() bool {
+	return atomic.LoadInt32(&n.diskFull) == 1
+}
+
+func diskFreeBytes(dataPath string) (uint64, error) {
+	var stat syscall.Statfs_t
+
+	if dataPath == "" {
+		dataPath = "."
+	}
+	err := syscall.Statfs(dataPath, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
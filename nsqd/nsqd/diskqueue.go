@@ -1,4 +1,4 @@
-package main
+package nsqd
 
 import (
 	"bufio"
@@ -13,8 +13,13 @@ import (
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// how long a failed write is reported by Err() unless a later write or
+// sync succeeds first
+const backendWriteErrWindow = time.Minute
+
 // DiskQueue implements the BackendQueue interface
 // providing a filesystem backed FIFO queue
 type DiskQueue struct {
@@ -33,12 +38,24 @@ type DiskQueue struct {
 	readFileNum  int64
 	writeFileNum int64
 	depth        int64
+	depthBytes   int64 // derived from the data files (not persisted)
 
 	// keeps track of the position where we have read
 	// (but not yet sent over readChan)
 	nextReadPos     int64
 	nextReadFileNum int64
 
+	// the data that has been read (but not yet sent over readChan)
+	peekMutex sync.Mutex
+	peeked    []byte
+
+	// the errors of the most recent write and read, nil once one succeeds
+	// (or a sync does for writes, see Err())
+	errMutex   sync.Mutex
+	writeErr   error
+	writeErrAt time.Time
+	readErr    error
+
 	readFile  *os.File
 	writeFile *os.File
 	reader    *bufio.Reader
@@ -52,6 +69,8 @@ type DiskQueue struct {
 	writeResponseChan chan error
 	emptyChan         chan int
 	emptyResponseChan chan error
+	syncChan          chan int
+	syncResponseChan  chan error
 	exitChan          chan int
 	exitSyncChan      chan int
 }
@@ -68,6 +87,8 @@ func NewDiskQueue(name string, dataPath string, maxBytesPerFile int64, syncEvery
 		writeResponseChan: make(chan error),
 		emptyChan:         make(chan int),
 		emptyResponseChan: make(chan error),
+		syncChan:          make(chan int),
+		syncResponseChan:  make(chan error),
 		exitChan:          make(chan int),
 		exitSyncChan:      make(chan int),
 		syncEvery:         syncEvery,
@@ -78,6 +99,7 @@ func NewDiskQueue(name string, dataPath string, maxBytesPerFile int64, syncEvery
 	if err != nil && !os.IsNotExist(err) {
 		log.Printf("ERROR: diskqueue(%s) failed to retrieveMetaData - %s", d.name, err.Error())
 	}
+	d.depthBytes = d.computeDepthBytes()
 
 	go d.ioLoop()
 
@@ -89,6 +111,51 @@ func (d *DiskQueue) Depth() int64 {
 	return atomic.LoadInt64(&d.depth)
 }
 
+// DepthBytes returns the number of bytes (including framing) waiting in the queue
+func (d *DiskQueue) DepthBytes() int64 {
+	return atomic.LoadInt64(&d.depthBytes)
+}
+
+// Peek returns the []byte that will be sent over ReadChan() next
+// (nil if it hasn't been read from the filesystem yet)
+func (d *DiskQueue) Peek() []byte {
+	d.peekMutex.Lock()
+	defer d.peekMutex.Unlock()
+	return d.peeked
+}
+
+func (d *DiskQueue) setPeeked(data []byte) {
+	d.peekMutex.Lock()
+	d.peeked = data
+	d.peekMutex.Unlock()
+}
+
+// Err returns a *BackendError if the most recent write or read failed, a
+// write error expires after backendWriteErrWindow (a queue that isn't
+// written to again would otherwise report it forever)
+func (d *DiskQueue) Err() error {
+	d.errMutex.Lock()
+	defer d.errMutex.Unlock()
+	if d.writeErr != nil && time.Since(d.writeErrAt) < backendWriteErrWindow {
+		return d.writeErr
+	}
+	return d.readErr
+}
+
+func (d *DiskQueue) setErr(op string, err error) {
+	d.errMutex.Lock()
+	defer d.errMutex.Unlock()
+	if err != nil {
+		err = &BackendError{Op: op, Err: err}
+	}
+	if op == "write" {
+		d.writeErr = err
+		d.writeErrAt = time.Now()
+	} else {
+		d.readErr = err
+	}
+}
+
 // ReadChan returns the []byte channel for reading data
 func (d *DiskQueue) ReadChan() chan []byte {
 	return d.readChan
@@ -147,6 +214,19 @@ func (d *DiskQueue) Empty() error {
 	return <-d.emptyResponseChan
 }
 
+// Sync fsyncs the current write file and persists meta-data
+func (d *DiskQueue) Sync() error {
+	d.RLock()
+	defer d.RUnlock()
+
+	if d.exitFlag == 1 {
+		return errors.New("exiting")
+	}
+
+	d.syncChan <- 1
+	return <-d.syncResponseChan
+}
+
 func (d *DiskQueue) doEmpty() error {
 	log.Printf("DISKQUEUE(%s): emptying", d.name)
 
@@ -166,7 +246,10 @@ func (d *DiskQueue) doEmpty() error {
 	d.readPos = d.writePos
 	d.nextReadFileNum = d.writeFileNum
 	d.nextReadPos = d.writePos
+	d.setPeeked(nil)
+	d.setErr("read", nil)
 	atomic.StoreInt64(&d.depth, 0)
+	atomic.StoreInt64(&d.depthBytes, 0)
 
 	err := d.sync()
 	if err != nil {
@@ -299,6 +382,7 @@ func (d *DiskQueue) writeOne(data []byte) error {
 	totalBytes := int64(4 + dataLen)
 	d.writePos += totalBytes
 	atomic.AddInt64(&d.depth, 1)
+	atomic.AddInt64(&d.depthBytes, totalBytes)
 
 	if d.writePos > d.maxBytesPerFile {
 		d.writeFileNum++
@@ -319,18 +403,22 @@ func (d *DiskQueue) writeOne(data []byte) error {
 	return err
 }
 
-// sync fsyncs the current writeFile and persists metadata
+// sync fsyncs the current writeFile and persists metadata, its outcome is
+// that of the writes before it
 func (d *DiskQueue) sync() error {
 	if d.writeFile != nil {
 		err := d.writeFile.Sync()
 		if err != nil {
 			d.writeFile.Close()
 			d.writeFile = nil
+			d.setErr("write", err)
 			return err
 		}
 	}
 
-	return d.persistMetaData()
+	err := d.persistMetaData()
+	d.setErr("write", err)
+	return err
 }
 
 // retrieveMetaData initializes state from the filesystem
@@ -387,6 +475,24 @@ func (d *DiskQueue) persistMetaData() error {
 	return os.Rename(tmpFileName, fileName)
 }
 
+// computeDepthBytes walks the data files between the read and write positions
+// to determine how many bytes are waiting in the queue
+func (d *DiskQueue) computeDepthBytes() int64 {
+	if d.readFileNum == d.writeFileNum {
+		return d.writePos - d.readPos
+	}
+
+	total := d.writePos - d.readPos
+	for num := d.readFileNum; num < d.writeFileNum; num++ {
+		info, err := os.Stat(d.fileName(num))
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+	}
+	return total
+}
+
 func (d *DiskQueue) metaDataFileName() string {
 	return fmt.Sprintf(path.Join(d.dataPath, "%s.diskqueue.meta.dat"), d.name)
 }
@@ -426,12 +532,15 @@ func (d *DiskQueue) ioLoop() {
 				if err != nil {
 					log.Printf("ERROR: reading from diskqueue(%s) at %d of %s - %s",
 						d.name, d.readPos, d.fileName(d.readFileNum), err.Error())
+					d.setErr("read", fmt.Errorf("%s (at %d of %s)", err.Error(), d.readPos, d.fileName(d.readFileNum)))
 					// TODO: we assume that all read errors are recoverable...
 					// it will probably turn out that this is a terrible assumption
 					// as this could certainly result in an infinite busy loop
 					runtime.Gosched()
 					continue
 				}
+				d.setPeeked(dataRead)
+				d.setErr("read", nil)
 			}
 			r = d.readChan
 		} else {
@@ -443,10 +552,12 @@ func (d *DiskQueue) ioLoop() {
 		// in a select are skipped, we set r to d.readChan only when there is data to read
 		// and reset it to nil after writing to the channel
 		case r <- dataRead:
+			d.setPeeked(nil)
 			oldReadFileNum := d.readFileNum
 			d.readFileNum = d.nextReadFileNum
 			d.readPos = d.nextReadPos
 			atomic.AddInt64(&d.depth, -1)
+			atomic.AddInt64(&d.depthBytes, -int64(4+len(dataRead)))
 
 			// see if we need to clean up the old file
 			if oldReadFileNum != d.nextReadFileNum {
@@ -466,8 +577,13 @@ func (d *DiskQueue) ioLoop() {
 			}
 		case <-d.emptyChan:
 			d.emptyResponseChan <- d.doEmpty()
+		case <-d.syncChan:
+			count = 0
+			d.syncResponseChan <- d.sync()
 		case dataWrite := <-d.writeChan:
-			d.writeResponseChan <- d.writeOne(dataWrite)
+			err = d.writeOne(dataWrite)
+			d.setErr("write", err)
+			d.writeResponseChan <- err
 		case <-d.exitChan:
 			goto exit
 		}
@@ -1,10 +1,11 @@
-package main
+package nsqd
 
 import (
 	"github.com/bmizerany/assert"
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -50,6 +51,28 @@ func TestDiskQueueRoll(t *testing.T) {
 	assert.Equal(t, dq.(*DiskQueue).writePos, int64(28))
 }
 
+func TestDiskQueueErr(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	dqName := "test_disk_queue_err" + strconv.Itoa(int(time.Now().Unix()))
+	dq := NewDiskQueue(dqName, path.Join(os.TempDir(), dqName, "missing"), 1024, 2500)
+	defer dq.Close()
+	assert.Equal(t, dq.Err(), nil)
+
+	err := dq.Put([]byte("test"))
+	assert.NotEqual(t, err, nil)
+	backendErr, ok := dq.Err().(*BackendError)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, backendErr.Op, "write")
+
+	// the error expires
+	dq.(*DiskQueue).errMutex.Lock()
+	dq.(*DiskQueue).writeErrAt = time.Now().Add(-backendWriteErrWindow)
+	dq.(*DiskQueue).errMutex.Unlock()
+	assert.Equal(t, dq.Err(), nil)
+}
+
 func TestDiskQueueEmpty(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 	defer log.SetOutput(os.Stdout)
@@ -0,0 +1,234 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"bytes"
+	"errors"
+	"log"
+	"sync/atomic"
+)
+
+// policies used to pick which of a channel's ready clients receives the next message
+const (
+	dispatchPolicyRoundRobin    = "round-robin"     // each ready client in turn
+	dispatchPolicyLeastInFlight = "least-in-flight" // the ready client with the fewest messages in flight
+	dispatchPolicyWeighted      = "weighted"        // in proportion to the weight each client declared in SUB
+)
+
+// max weight a client can declare in SUB
+const maxClientWeight = 100
+
+func isValidDispatchPolicy(policy string) bool {
+	switch policy {
+	case dispatchPolicyRoundRobin, dispatchPolicyLeastInFlight, dispatchPolicyWeighted:
+		return true
+	}
+	return false
+}
+
+// dispatcher (executed in a goroutine) takes messages off clientMsgChan
+// and hands each one to a ready client picked by the channel's dispatch policy
+//
+// messages are only taken off clientMsgChan once a client is ready so that
+// they stay queued (counting towards the channel's depth) until then
+func (c *Channel) dispatcher() {
+	var msg *nsq.Message
+	var ok bool
+
+	for {
+		if msg == nil {
+			if !c.hasReadyClient() {
+				select {
+				case <-c.readyChan:
+					continue
+				case <-c.exitChan:
+					goto exit
+				}
+			}
+
+			select {
+			case msg, ok = <-c.clientMsgChan:
+				if !ok {
+					goto exit
+				}
+				atomic.StoreInt32(&c.dispatchingCount, 1)
+			case <-c.readyChan:
+				continue
+			case <-c.exitChan:
+				goto exit
+			}
+		}
+
+		c.RLock()
+		client := c.pickClient()
+		c.RUnlock()
+
+		if client != nil && c.deliver(client, msg) {
+			msg = nil
+			atomic.StoreInt32(&c.dispatchingCount, 0)
+			continue
+		}
+
+		// the client(s) stopped being ready, hold the message until one is
+		select {
+		case <-c.readyChan:
+		case <-c.exitChan:
+			goto exit
+		}
+	}
+
+exit:
+	if msg != nil {
+		var msgBuf bytes.Buffer
+		log.Printf("CHANNEL(%s): recovered undelivered message from dispatcher", c.name)
+		WriteMessageToBackend(&msgBuf, msg, c)
+		atomic.StoreInt32(&c.dispatchingCount, 0)
+	}
+	log.Printf("CHANNEL(%s): closing ... dispatcher", c.name)
+}
+
+// deliver marks the message as in-flight for the client and hands it off,
+// returning false if the client could not accept it
+func (c *Channel) deliver(client Consumer, msg *nsq.Message) bool {
+	// the message has to be in-flight before the client can possibly FIN it
+	err := c.StartInFlightTimeout(msg, client)
+	if client.Deliver(msg) {
+		return true
+	}
+
+	if err == nil {
+		item, err := c.popInFlightMessage(client, msg.Id)
+		if err != nil {
+			// the client was removed concurrently and the message has already been requeued
+			return true
+		}
+		c.timeouts.Remove(item)
+	}
+
+	return false
+}
+
+// notifyDispatcher wakes up the dispatcher to re-evaluate which clients are ready
+func (c *Channel) notifyDispatcher() {
+	select {
+	case c.readyChan <- 1:
+	default:
+	}
+}
+
+// candidateClients returns the clients that are allowed to receive
+// messages given the channel's mode
+//
+// this expects the caller to handle locking
+func (c *Channel) candidateClients() []Consumer {
+	if c.mode == channelModeFailover && len(c.clients) > 1 {
+		return c.clients[:1]
+	}
+	return c.clients
+}
+
+func (c *Channel) hasReadyClient() bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, client := range c.candidateClients() {
+		if client.IsReadyForMessages() {
+			return true
+		}
+	}
+	return false
+}
+
+// pickClient returns the ready client that should receive the next
+// message (or nil if none are ready)
+//
+// only the dispatcher calls this (so the policy state is not protected)
+// and it expects the caller to hold the read lock
+func (c *Channel) pickClient() Consumer {
+	clients := c.candidateClients()
+	if len(clients) == 0 {
+		return nil
+	}
+
+	switch c.dispatchPolicy {
+	case dispatchPolicyLeastInFlight:
+		var picked Consumer
+		var pickedInFlight int64
+		// start at the round robin index so that ties are spread out
+		for i := 0; i < len(clients); i++ {
+			client := clients[(c.dispatchIndex+i)%len(clients)]
+			if !client.IsReadyForMessages() {
+				continue
+			}
+			inFlight := client.InFlight()
+			if picked == nil || inFlight < pickedInFlight {
+				picked = client
+				pickedInFlight = inFlight
+			}
+		}
+		c.dispatchIndex++
+		return picked
+	case dispatchPolicyWeighted:
+		// smooth weighted round robin, every ready client's current weight is
+		// increased by its weight and the highest is picked and reduced by the total
+		var picked Consumer
+		total := 0
+		for _, client := range clients {
+			if !client.IsReadyForMessages() {
+				continue
+			}
+			weight := client.Weight()
+			total += weight
+			c.dispatchWeights[client] += weight
+			if picked == nil || c.dispatchWeights[client] > c.dispatchWeights[picked] {
+				picked = client
+			}
+		}
+		if picked != nil {
+			c.dispatchWeights[picked] -= total
+		}
+		if len(c.dispatchWeights) > len(c.clients) {
+			c.pruneDispatchWeights()
+		}
+		return picked
+	}
+
+	for i := 0; i < len(clients); i++ {
+		idx := (c.dispatchIndex + i) % len(clients)
+		if clients[idx].IsReadyForMessages() {
+			c.dispatchIndex = idx + 1
+			return clients[idx]
+		}
+	}
+	return nil
+}
+
+// pruneDispatchWeights forgets the state of clients that have gone away
+func (c *Channel) pruneDispatchWeights() {
+	current := make(map[Consumer]int, len(c.clients))
+	for _, client := range c.clients {
+		if weight, ok := c.dispatchWeights[client]; ok {
+			current[client] = weight
+		}
+	}
+	c.dispatchWeights = current
+}
+
+// DispatchPolicy returns the policy used to distribute messages across clients
+func (c *Channel) DispatchPolicy() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.dispatchPolicy
+}
+
+// SetDispatchPolicy changes the policy used to distribute messages across clients
+func (c *Channel) SetDispatchPolicy(policy string) error {
+	if !isValidDispatchPolicy(policy) {
+		return errors.New("invalid dispatch policy " + policy)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.dispatchPolicy = policy
+	return nil
+}
@@ -0,0 +1,114 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// receive reads every message delivered to the clients (finishing each one)
+// until `count` have been received, returning how many each client got
+func receive(t *testing.T, channel *Channel, clients []*ClientV2, count int) []int {
+	received := make([]int, len(clients))
+	timeout := time.After(5 * time.Second)
+	for total := 0; total < count; {
+		select {
+		case msg := <-clients[0].clientMsgChan:
+			finish(channel, clients[0], msg)
+			received[0]++
+		case msg := <-clients[1].clientMsgChan:
+			finish(channel, clients[1], msg)
+			received[1]++
+		case <-timeout:
+			t.Fatalf("timed out after receiving %d messages", total)
+		}
+		total++
+	}
+	return received
+}
+
+func finish(channel *Channel, client *ClientV2, msg *nsq.Message) {
+	client.tryUpdateReadyState()
+	channel.FinishMessage(client, msg.Id)
+	client.FinishedMessage()
+}
+
+func newDispatchTestClient(channel *Channel, weight int) *ClientV2 {
+	conn, _ := net.Pipe()
+	client := NewClientV2(conn, channel.nsqd)
+	client.Channel = channel
+	client.weight = weight
+	client.SetReadyCount(1000)
+	channel.AddClient(client)
+	return client
+}
+
+func TestDispatchRoundRobin(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+
+	topicName := "test_dispatch_rr" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+	channel := topic.GetChannel("ch")
+
+	clients := []*ClientV2{newDispatchTestClient(channel, 1), newDispatchTestClient(channel, 1)}
+	defer channel.RemoveClient(clients[0])
+	defer channel.RemoveClient(clients[1])
+
+	for i := 0; i < 100; i++ {
+		topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, []byte("test")))
+	}
+
+	received := receive(t, channel, clients, 100)
+	assert.Equal(t, received[0], 50)
+	assert.Equal(t, received[1], 50)
+}
+
+func TestDispatchWeighted(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+
+	topicName := "test_dispatch_weighted" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+	channel := topic.GetChannel("ch")
+	err := channel.SetOption("dispatch", "weighted")
+	assert.Equal(t, err, nil)
+
+	clients := []*ClientV2{newDispatchTestClient(channel, 3), newDispatchTestClient(channel, 1)}
+	defer channel.RemoveClient(clients[0])
+	defer channel.RemoveClient(clients[1])
+
+	// every client is ready so the picks follow the weights exactly
+	picks := make(map[Consumer]int)
+	channel.RLock()
+	for i := 0; i < 100; i++ {
+		picks[channel.pickClient()]++
+	}
+	channel.RUnlock()
+	assert.Equal(t, picks[clients[0]], 75)
+	assert.Equal(t, picks[clients[1]], 25)
+
+	for i := 0; i < 100; i++ {
+		topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, []byte("test")))
+	}
+	receive(t, channel, clients, 100)
+
+	channel.RLock()
+	stats := channel.clientStats()
+	channel.RUnlock()
+	assert.Equal(t, stats[0].share+stats[1].share, 1.0)
+}
@@ -1,4 +1,4 @@
-package main
+package nsqd
 
 type DummyBackendQueue struct {
 	readChan chan []byte
@@ -24,6 +24,22 @@ func (d *DummyBackendQueue) Depth() int64 {
 	return int64(0)
 }
 
+func (d *DummyBackendQueue) DepthBytes() int64 {
+	return int64(0)
+}
+
+func (d *DummyBackendQueue) Peek() []byte {
+	return nil
+}
+
+func (d *DummyBackendQueue) Err() error {
+	return nil
+}
+
 func (d *DummyBackendQueue) Empty() error {
 	return nil
 }
+
+func (d *DummyBackendQueue) Sync() error {
+	return nil
+}
@@ -0,0 +1,87 @@
+package nsqd
+
+// the core algorithm here was borrowed from:
+// Blake Mizerany's `noeqd` https://github.com/bmizerany/noeqd
+// and indirectly:
+// Twitter's `snowflake` https://github.com/twitter/snowflake
+
+// only minor cleanup and changes to introduce a type, combine the concept
+// of workerId + datacenterId into a single identifier, and modify the
+// behavior when sequences rollover for our specific implementation needs
+
+import (
+	"../../nsq"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// a GUID is 12 bytes, a 48-bit millisecond timestamp followed by the 16-bit
+// worker id and a 32-bit sequence, which encode to exactly nsq.MsgIdLength
+// characters
+const (
+	guidLength  = 12
+	maxWorkerId = 1<<16 - 1
+
+	// Tue, 21 Mar 2006 20:50:14.000 GMT
+	twepoch = int64(1288834974657)
+)
+
+// like base64 (URL safe) but with the alphabet in ascending order so that
+// encoded GUIDs still sort by time
+var guidEncoding = base64.NewEncoding("-0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz")
+
+var ErrTimeBackwards = errors.New("time has gone backwards")
+var ErrSequenceExpired = errors.New("sequence expired")
+
+type GUID [guidLength]byte
+
+// GUIDFactory generates the GUIDs of a single worker, it is not safe
+// for concurrent use
+type GUIDFactory struct {
+	workerId      int64
+	sequence      uint32
+	lastTimestamp int64
+}
+
+func NewGUIDFactory(workerId int64) *GUIDFactory {
+	return &GUIDFactory{workerId: workerId}
+}
+
+func (f *GUIDFactory) NewGUID() (GUID, error) {
+	var g GUID
+
+	ts := time.Now().UnixNano() / 1e6
+
+	if ts < f.lastTimestamp {
+		return g, ErrTimeBackwards
+	}
+
+	if f.lastTimestamp == ts {
+		f.sequence++
+		if f.sequence == 0 {
+			return g, ErrSequenceExpired
+		}
+	} else {
+		f.sequence = 0
+	}
+
+	f.lastTimestamp = ts
+
+	elapsed := uint64(ts - twepoch)
+	g[0] = byte(elapsed >> 40)
+	g[1] = byte(elapsed >> 32)
+	binary.BigEndian.PutUint32(g[2:6], uint32(elapsed))
+	binary.BigEndian.PutUint16(g[6:8], uint16(f.workerId))
+	binary.BigEndian.PutUint32(g[8:12], f.sequence)
+
+	return g, nil
+}
+
+// Encode returns the GUID as a message id
+func (g GUID) Encode() []byte {
+	id := make([]byte, nsq.MsgIdLength)
+	guidEncoding.Encode(id, g[:])
+	return id
+}
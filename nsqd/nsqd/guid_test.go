@@ -0,0 +1,33 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"bytes"
+	"github.com/bmizerany/assert"
+	"testing"
+)
+
+func TestGUID(t *testing.T) {
+	factory := NewGUIDFactory(maxWorkerId)
+	other := NewGUIDFactory(1)
+
+	var last []byte
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		g, err := factory.NewGUID()
+		assert.Equal(t, err, nil)
+		id := g.Encode()
+		assert.Equal(t, len(id), nsq.MsgIdLength)
+		// ids are safe to use as a protocol parameter
+		assert.Equal(t, bytes.ContainsAny(id, " \r\n"), false)
+		// and sort in the order they were generated
+		assert.Equal(t, bytes.Compare(last, id) < 0, true)
+		last = id
+		seen[string(id)] = true
+
+		g, err = other.NewGUID()
+		assert.Equal(t, err, nil)
+		seen[string(g.Encode())] = true
+	}
+	assert.Equal(t, len(seen), 20000)
+}
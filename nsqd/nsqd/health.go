@@ -0,0 +1,195 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the share of --mem-budget in use at which nsqd is under memory pressure
+const memoryPressureThreshold = 0.9
+
+// how long the id pump or a router can go without making progress before
+// nsqd isn't live
+const livenessTimeout = 10 * time.Second
+
+// HealthReason is why nsqd is unhealthy, see Readiness() and Liveness()
+type HealthReason struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// lookupdHealth records the outcome of the most recent command sent to
+// each nsqlookupd
+type lookupdHealth struct {
+	sync.Mutex
+	errors map[string]error
+}
+
+func (h *lookupdHealth) set(addr string, err error) {
+	h.Lock()
+	defer h.Unlock()
+	if h.errors == nil {
+		h.errors = make(map[string]error)
+	}
+	h.errors[addr] = err
+}
+
+func (h *lookupdHealth) remove(addr string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.errors, addr)
+}
+
+// allFailing returns the addresses of the nsqlookupd (sorted) and their
+// errors when none of them can be reached, nothing while any can
+func (h *lookupdHealth) allFailing() ([]string, map[string]error) {
+	h.Lock()
+	defer h.Unlock()
+
+	var addrs []string
+	errors := make(map[string]error)
+	for addr, err := range h.errors {
+		if err == nil {
+			return nil, nil
+		}
+		addrs = append(addrs, addr)
+		errors[addr] = err
+	}
+	sort.Strings(addrs)
+	return addrs, errors
+}
+
+// lookupPeerCommand sends a command to a nsqlookupd recording whether it
+// could be reached (a nil command only connects)
+func (n *NSQd) lookupPeerCommand(lookupPeer *nsq.LookupPeer, cmd *nsq.Command) ([]byte, error) {
+	resp, err := lookupPeer.Command(cmd)
+	n.lookupdHealth.set(lookupPeer.String(), err)
+	return resp, err
+}
+
+// Readiness returns why nsqd can't take traffic right now (nothing when it
+// can): its disk is full, a backend's writes are failing or it can't be
+// read (it's corrupt), no nsqlookupd can be reached (one is enough for
+// clients to find it), the memory budget is nearly exhausted or another
+// nsqd has its worker id
+func (n *NSQd) Readiness() []HealthReason {
+	reasons := []HealthReason{}
+
+	if n.IsDiskFull() {
+		reasons = append(reasons, HealthReason{"DISK_FULL",
+			fmt.Sprintf("%d bytes free on %s", atomic.LoadUint64(&n.diskFreeBytes), n.options.DataPath)})
+	}
+
+	reasons = append(reasons, n.backendHealth()...)
+
+	addrs, errors := n.lookupdHealth.allFailing()
+	for _, addr := range addrs {
+		reasons = append(reasons, HealthReason{"LOOKUPD_UNREACHABLE",
+			fmt.Sprintf("%s - %s", addr, errors[addr].Error())})
+	}
+
+	budget := n.memoryBudget
+	if budget.Max() > 0 && float64(budget.Used()) >= memoryPressureThreshold*float64(budget.Max()) {
+		reasons = append(reasons, HealthReason{"MEMORY_PRESSURE",
+			fmt.Sprintf("%d of %d bytes of the memory budget used", budget.Used(), budget.Max())})
+	}
+
+	if n.HasWorkerIdConflict() {
+		reasons = append(reasons, HealthReason{"WORKER_ID_CONFLICT",
+			fmt.Sprintf("worker id %d is in use by another nsqd", n.workerId)})
+	}
+
+	return reasons
+}
+
+// Liveness returns why nsqd is stuck (and should be restarted), nothing
+// when it's making progress: the id pump hasn't generated an id (while
+// there's room for one) or a router has been routing the same message
+// for over livenessTimeout
+func (n *NSQd) Liveness() []HealthReason {
+	reasons := []HealthReason{}
+	now := time.Now().UnixNano()
+
+	// every publish needs an id, when idChan is full the pump is waiting
+	heartbeat := atomic.LoadInt64(&n.idPumpHeartbeat)
+	if len(n.idChan) < cap(n.idChan) && time.Duration(now-heartbeat) > livenessTimeout {
+		reasons = append(reasons, HealthReason{"ID_PUMP_STALLED",
+			fmt.Sprintf("no message id generated for %s", time.Duration(now-heartbeat))})
+	}
+
+	for _, t := range n.sortedTopics() {
+		if reason, ok := routerReason(t.name, atomic.LoadInt64(&t.routeStarted), now); ok {
+			reasons = append(reasons, reason)
+		}
+		for _, c := range t.sortedChannels() {
+			if reason, ok := routerReason(t.name+":"+c.name, atomic.LoadInt64(&c.routeStarted), now); ok {
+				reasons = append(reasons, reason)
+			}
+		}
+	}
+
+	return reasons
+}
+
+func routerReason(name string, routeStarted int64, now int64) (HealthReason, bool) {
+	if routeStarted == 0 || time.Duration(now-routeStarted) <= livenessTimeout {
+		return HealthReason{}, false
+	}
+	return HealthReason{"ROUTER_STALLED",
+		fmt.Sprintf("%s: routing a message for %s", name, time.Duration(now-routeStarted))}, true
+}
+
+// backendHealth returns a reason for each topic and channel backend whose
+// most recent write or read failed
+func (n *NSQd) backendHealth() []HealthReason {
+	var reasons []HealthReason
+	for _, t := range n.sortedTopics() {
+		if reason, ok := backendReason(t.name, t.backend); ok {
+			reasons = append(reasons, reason)
+		}
+		for _, c := range t.sortedChannels() {
+			if reason, ok := backendReason(t.name+":"+c.name, c.backend); ok {
+				reasons = append(reasons, reason)
+			}
+		}
+	}
+	return reasons
+}
+
+func (n *NSQd) sortedTopics() []*Topic {
+	n.RLock()
+	topics := make([]*Topic, 0, len(n.topicMap))
+	for _, t := range n.topicMap {
+		topics = append(topics, t)
+	}
+	n.RUnlock()
+	sort.Sort(TopicsByName{topics})
+	return topics
+}
+
+func (t *Topic) sortedChannels() []*Channel {
+	t.RLock()
+	channels := make([]*Channel, 0, len(t.channelMap))
+	for _, c := range t.channelMap {
+		channels = append(channels, c)
+	}
+	t.RUnlock()
+	sort.Sort(ChannelsByName{channels})
+	return channels
+}
+
+func backendReason(name string, backend BackendQueue) (HealthReason, bool) {
+	err := backend.Err()
+	if err == nil {
+		return HealthReason{}, false
+	}
+	reason := "BACKEND_WRITE_FAILED"
+	if backendErr, ok := err.(*BackendError); ok && backendErr.Op == "read" {
+		reason = "DISK_QUEUE_CORRUPT"
+	}
+	return HealthReason{reason, fmt.Sprintf("%s: %s", name, err.Error())}, true
+}
@@ -0,0 +1,775 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"../../util"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+import httpprof "net/http/pprof"
+
+// httpServer serves the HTTP API of an NSQd
+type httpServer struct {
+	nsqd     *NSQd
+	handler  *http.ServeMux
+	v1Routes []*v1Route
+}
+
+func newHTTPServer(nsqd *NSQd) *httpServer {
+	s := &httpServer{nsqd: nsqd}
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("/ping", s.pingHandler)
+	handler.HandleFunc("/health/live", s.liveHandler)
+	handler.HandleFunc("/health/ready", s.pingHandler)
+	handler.HandleFunc("/info", s.infoHandler)
+	handler.HandleFunc("/put", s.putHandler)
+	handler.HandleFunc("/mput", s.mputHandler)
+	handler.HandleFunc("/stats", s.statsHandler)
+	handler.HandleFunc("/audit", s.auditHandler)
+	handler.HandleFunc("/create_topic", s.audited("create_topic", s.createTopicHandler))
+	handler.HandleFunc("/delete_topic", s.audited("delete_topic", s.deleteTopicHandler))
+	handler.HandleFunc("/empty_channel", s.audited("empty_channel", s.emptyChannelHandler))
+	handler.HandleFunc("/delete_channel", s.audited("delete_channel", s.deleteChannelHandler))
+	handler.HandleFunc("/mem_profile", s.memProfileHandler)
+	handler.HandleFunc("/cpu_profile", httpprof.Profile)
+	handler.HandleFunc("/dump_inflight", s.dumpInFlightHandler)
+	handler.HandleFunc("/pause_channel", s.audited("pause_channel", s.pauseChannelHandler))
+	handler.HandleFunc("/unpause_channel", s.audited("unpause_channel", s.pauseChannelHandler))
+	handler.HandleFunc("/channel/create", s.createChannelHandler)
+	handler.HandleFunc("/channel/rewind", s.audited("rewind_channel", s.rewindChannelHandler))
+	handler.HandleFunc("/channel/config", s.audited("config_channel", s.configChannelHandler))
+	handler.HandleFunc("/channel/clients", s.channelClientsHandler)
+	handler.HandleFunc("/channel/client/kick", s.audited("kick_client", s.channelClientHandler))
+	handler.HandleFunc("/channel/client/drain", s.audited("drain_client", s.channelClientHandler))
+	handler.HandleFunc("/channel/client/undrain", s.audited("undrain_client", s.channelClientHandler))
+	handler.HandleFunc("/v1/", s.v1Handler)
+	s.handler = handler
+
+	s.v1Routes = []*v1Route{
+		newV1Route("GET", "/v1/ping", s.pingHandler, true),
+		newV1Route("GET", "/v1/health/live", s.liveHandler, true),
+		newV1Route("GET", "/v1/health/ready", s.pingHandler, true),
+		newV1Route("GET", "/v1/info", s.infoHandler, false),
+		newV1Route("GET", "/v1/stats", s.statsHandler, true),
+		newV1Route("GET", "/v1/audit", s.auditHandler, false),
+		newV1Route("POST", "/v1/topic/:topic", s.audited("create_topic", s.createTopicHandler), false),
+		newV1Route("DELETE", "/v1/topic/:topic", s.audited("delete_topic", s.deleteTopicHandler), false),
+		newV1Route("POST", "/v1/topic/:topic/pub", s.putHandler, false),
+		newV1Route("POST", "/v1/topic/:topic/mpub", s.mputHandler, false),
+		newV1Route("POST", "/v1/channel/:topic/:channel", s.createChannelHandler, false),
+		newV1Route("DELETE", "/v1/channel/:topic/:channel", s.audited("delete_channel", s.deleteChannelHandler), false),
+		newV1Route("POST", "/v1/channel/:topic/:channel/empty", s.audited("empty_channel", s.emptyChannelHandler), false),
+		newV1Route("POST", "/v1/channel/:topic/:channel/pause", s.audited("pause_channel", s.pauseChannelHandler), false),
+		newV1Route("POST", "/v1/channel/:topic/:channel/unpause", s.audited("unpause_channel", s.pauseChannelHandler), false),
+		newV1Route("POST", "/v1/channel/:topic/:channel/rewind", s.audited("rewind_channel", s.rewindChannelHandler), false),
+		newV1Route("POST", "/v1/channel/:topic/:channel/config", s.audited("config_channel", s.configChannelHandler), false),
+		newV1Route("GET", "/v1/channel/:topic/:channel/clients", s.channelClientsHandler, false),
+		newV1Route("GET", "/v1/channel/:topic/:channel/inflight", s.dumpInFlightHandler, true),
+		newV1Route("POST", "/v1/channel/:topic/:channel/client/kick", s.audited("kick_client", s.channelClientHandler), false),
+		newV1Route("POST", "/v1/channel/:topic/:channel/client/drain", s.audited("drain_client", s.channelClientHandler), false),
+		newV1Route("POST", "/v1/channel/:topic/:channel/client/undrain", s.audited("undrain_client", s.channelClientHandler), false),
+	}
+
+	return s
+}
+
+func (s *httpServer) serve(listener net.Listener) {
+	log.Printf("HTTP: listening on %s", listener.Addr().String())
+
+	// these timeouts are absolute per server connection NOT per request
+	// this means that a single persistent connection will only last N seconds
+	server := &http.Server{
+		Handler: s.handler,
+	}
+	err := server.Serve(listener)
+	// theres no direct way to detect this error because it is not exposed
+	if err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+		log.Printf("ERROR: http.Serve() - %s", err.Error())
+	}
+
+	log.Printf("HTTP: closing %s", listener.Addr().String())
+}
+
+func (s *httpServer) dumpInFlightHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	topicName, channelName, err := util.GetTopicChannelArgs(reqParams)
+	if err != nil {
+		apiError(w, req, 400, err.Error())
+		return
+	}
+
+	topic, err := s.nsqd.GetExistingTopic(topicName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_TOPIC")
+		return
+	}
+
+	channel, err := topic.GetExistingChannel(channelName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_CHANNEL")
+		return
+	}
+
+	log.Printf("NOTICE: dumping inflight for %s:%s", topicName, channelName)
+
+	formatString, _ := reqParams.Query("format")
+	if formatString == "json" {
+		channel.Lock()
+		messages := make([]interface{}, 0, len(channel.inFlightMessages))
+		for _, item := range channel.inFlightMessages {
+			msg := item.Value.(*inFlightMessage).msg
+			messages = append(messages, struct {
+				ID        string `json:"id"`
+				Timestamp int64  `json:"timestamp"`
+				Attempts  uint16 `json:"attempts"`
+				Deadline  int64  `json:"deadline"`
+			}{string(msg.Id), msg.Timestamp, msg.Attempts, item.Deadline / int64(time.Second)})
+		}
+		channel.Unlock()
+
+		util.ApiResponse(w, 200, "OK", struct {
+			InFlightMessages []interface{} `json:"in_flight_messages"`
+		}{messages})
+		return
+	}
+
+	fmt.Fprintf(w, "inFlightMessages:\n")
+	channel.Lock()
+	for _, item := range channel.inFlightMessages {
+		msg := item.Value.(*inFlightMessage).msg
+		fmt.Fprintf(w, "%s %s %d %s\n", msg.Id, time.Unix(msg.Timestamp, 0).String(), msg.Attempts, time.Unix(0, item.Deadline).String())
+	}
+	channel.Unlock()
+}
+
+func (s *httpServer) memProfileHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("MEMORY Profiling Enabled")
+	f, err := os.Create("s.nsqd.mprof")
+	if err != nil {
+		log.Fatal(err)
+	}
+	pprof.WriteHeapProfile(f)
+	f.Close()
+
+	w.Header().Set("Content-Length", "2")
+	io.WriteString(w, "OK")
+}
+
+// pingHandler (and /health/ready) returns OK when nsqd is ready for
+// traffic, otherwise 503 with each reason it isn't (see Readiness())
+func (s *httpServer) pingHandler(w http.ResponseWriter, req *http.Request) {
+	healthResponse(w, s.nsqd.Readiness())
+}
+
+// liveHandler returns OK unless nsqd is stuck (see Liveness())
+func (s *httpServer) liveHandler(w http.ResponseWriter, req *http.Request) {
+	healthResponse(w, s.nsqd.Liveness())
+}
+
+func healthResponse(w http.ResponseWriter, reasons []HealthReason) {
+	if len(reasons) > 0 {
+		util.ApiResponse(w, 503, reasons[0].Reason, struct {
+			Reasons []HealthReason `json:"reasons"`
+		}{reasons})
+		return
+	}
+
+	w.Header().Set("Content-Length", "2")
+	io.WriteString(w, "OK")
+}
+
+func (s *httpServer) infoHandler(w http.ResponseWriter, req *http.Request) {
+	util.ApiResponse(w, 200, "OK", struct {
+		Version string `json:"version"`
+	}{
+		Version: util.BINARY_VERSION,
+	})
+}
+
+func (s *httpServer) putHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	topicName, err := reqParams.Query("topic")
+	if err != nil {
+		apiError(w, req, 400, "MISSING_ARG_TOPIC")
+		return
+	}
+
+	if !nsq.IsValidTopicName(topicName) {
+		apiError(w, req, 400, "INVALID_ARG_TOPIC")
+		return
+	}
+
+	if s.nsqd.IsDiskFull() {
+		util.ApiResponse(w, 503, "DISK_FULL", nil)
+		return
+	}
+
+	if s.nsqd.HasWorkerIdConflict() {
+		util.ApiResponse(w, 503, "WORKER_ID_CONFLICT", nil)
+		return
+	}
+
+	key, err := getKeyArg(reqParams)
+	if err != nil {
+		apiError(w, req, 400, err.Error())
+		return
+	}
+
+	topic := s.nsqd.GetPublishTopic(topicName)
+	if topic == nil {
+		// nobody is subscribed to this ephemeral topic
+		w.Header().Set("Content-Length", "2")
+		io.WriteString(w, "OK")
+		return
+	}
+	msg := nsq.NewMessage(<-s.nsqd.idChan, reqParams.Body)
+	msg.Key = key
+	err = topic.PutMessage(msg)
+	if err == ErrTopicFull {
+		util.ApiResponse(w, 503, "TOPIC_FULL", nil)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to put message to topic(%s) - %s", topicName, err.Error())
+		apiError(w, req, 500, "NOK")
+		return
+	}
+
+	w.Header().Set("Content-Length", "2")
+	io.WriteString(w, "OK")
+}
+
+func (s *httpServer) mputHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	topicName, err := reqParams.Query("topic")
+	if err != nil {
+		apiError(w, req, 400, "MISSING_ARG_TOPIC")
+		return
+	}
+
+	if !nsq.IsValidTopicName(topicName) {
+		apiError(w, req, 400, "INVALID_ARG_TOPIC")
+		return
+	}
+
+	if s.nsqd.IsDiskFull() {
+		util.ApiResponse(w, 503, "DISK_FULL", nil)
+		return
+	}
+
+	if s.nsqd.HasWorkerIdConflict() {
+		util.ApiResponse(w, 503, "WORKER_ID_CONFLICT", nil)
+		return
+	}
+
+	key, err := getKeyArg(reqParams)
+	if err != nil {
+		apiError(w, req, 400, err.Error())
+		return
+	}
+
+	topic := s.nsqd.GetPublishTopic(topicName)
+	if topic == nil {
+		// nobody is subscribed to this ephemeral topic
+		w.Header().Set("Content-Length", "2")
+		io.WriteString(w, "OK")
+		return
+	}
+	for _, block := range bytes.Split(reqParams.Body, []byte("\n")) {
+		if len(block) != 0 {
+			msg := nsq.NewMessage(<-s.nsqd.idChan, block)
+			msg.Key = key
+			err := topic.PutMessage(msg)
+			if err == ErrTopicFull {
+				util.ApiResponse(w, 503, "TOPIC_FULL", nil)
+				return
+			}
+			if err != nil {
+				log.Printf("ERROR: failed to put message to topic(%s) - %s", topicName, err.Error())
+				apiError(w, req, 500, "NOK")
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Length", "2")
+	io.WriteString(w, "OK")
+}
+
+// getKeyArg returns the (optional) partition key for a publish
+func getKeyArg(reqParams *util.ReqParams) ([]byte, error) {
+	key, err := reqParams.Query("key")
+	if err != nil || key == "" {
+		return nil, nil
+	}
+	if len(key) > maxKeyLength {
+		return nil, errors.New("INVALID_ARG_KEY")
+	}
+	return []byte(key), nil
+}
+
+func (s *httpServer) createTopicHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	topicName, err := reqParams.Query("topic")
+	if err != nil {
+		apiError(w, req, 400, "MISSING_ARG_TOPIC")
+		return
+	}
+
+	if !nsq.IsValidTopicName(topicName) {
+		apiError(w, req, 400, "INVALID_ARG_TOPIC")
+		return
+	}
+
+	s.nsqd.GetTopic(topicName)
+	util.ApiResponse(w, 200, "OK", nil)
+}
+
+func (s *httpServer) deleteTopicHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	topicName, err := reqParams.Query("topic")
+	if err != nil {
+		apiError(w, req, 400, "MISSING_ARG_TOPIC")
+		return
+	}
+
+	err = s.nsqd.DeleteExistingTopic(topicName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_TOPIC")
+		return
+	}
+
+	util.ApiResponse(w, 200, "OK", nil)
+}
+
+func (s *httpServer) emptyChannelHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	topicName, channelName, err := util.GetTopicChannelArgs(reqParams)
+	if err != nil {
+		apiError(w, req, 400, err.Error())
+		return
+	}
+
+	topic, err := s.nsqd.GetExistingTopic(topicName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_TOPIC")
+		return
+	}
+
+	channel, err := topic.GetExistingChannel(channelName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_CHANNEL")
+		return
+	}
+
+	err = channel.Empty()
+	if err != nil {
+		apiError(w, req, 500, "INTERNAL_ERROR")
+		return
+	}
+
+	w.Header().Set("Content-Length", "2")
+	io.WriteString(w, "OK")
+}
+
+func (s *httpServer) deleteChannelHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	topicName, channelName, err := util.GetTopicChannelArgs(reqParams)
+	if err != nil {
+		apiError(w, req, 400, err.Error())
+		return
+	}
+
+	topic, err := s.nsqd.GetExistingTopic(topicName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_TOPIC")
+		return
+	}
+
+	err = topic.DeleteExistingChannel(channelName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_CHANNEL")
+		return
+	}
+
+	util.ApiResponse(w, 200, "OK", nil)
+}
+
+func (s *httpServer) pauseChannelHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	topicName, channelName, err := util.GetTopicChannelArgs(reqParams)
+	if err != nil {
+		apiError(w, req, 400, err.Error())
+		return
+	}
+
+	topic, err := s.nsqd.GetExistingTopic(topicName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_TOPIC")
+		return
+	}
+
+	channel, err := topic.GetExistingChannel(channelName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_CHANNEL")
+		return
+	}
+
+	// /pause_channel or /v1/channel/.../pause
+	if strings.HasPrefix(path.Base(req.URL.Path), "pause") {
+		channel.Pause()
+	} else {
+		channel.UnPause()
+	}
+
+	util.ApiResponse(w, 200, "OK", nil)
+}
+
+func (s *httpServer) createChannelHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	topicName, channelName, err := util.GetTopicChannelArgs(reqParams)
+	if err != nil {
+		apiError(w, req, 400, err.Error())
+		return
+	}
+
+	start, err := reqParams.Query("start")
+	if err != nil {
+		start = "latest"
+	}
+	if start != "latest" && start != "earliest" {
+		apiError(w, req, 400, "INVALID_ARG_START")
+		return
+	}
+
+	topic := s.nsqd.GetTopic(topicName)
+	if start == "latest" {
+		topic.GetChannel(channelName)
+		util.ApiResponse(w, 200, "OK", nil)
+		return
+	}
+
+	if topic.retention == nil {
+		apiError(w, req, 409, "RETENTION_NOT_ENABLED")
+		return
+	}
+
+	// an existing channel has its own position, use /channel/rewind instead
+	_, err = topic.GetExistingChannel(channelName)
+	if err == nil {
+		apiError(w, req, 409, "CHANNEL_EXISTS")
+		return
+	}
+
+	count, err := topic.CreateChannelFromEarliest(channelName, s.nsqd.idChan)
+	if err != nil {
+		log.Printf("ERROR: failed to replay retention into %s:%s - %s", topicName, channelName, err.Error())
+		apiError(w, req, 500, "INTERNAL_ERROR")
+		return
+	}
+
+	util.ApiResponse(w, 200, "OK", struct {
+		Count int `json:"count"`
+	}{count})
+}
+
+func (s *httpServer) rewindChannelHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	topicName, channelName, err := util.GetTopicChannelArgs(reqParams)
+	if err != nil {
+		apiError(w, req, 400, err.Error())
+		return
+	}
+
+	sinceStr, err := reqParams.Query("since")
+	if err != nil {
+		apiError(w, req, 400, "MISSING_ARG_SINCE")
+		return
+	}
+
+	since, err := strconv.ParseInt(sinceStr, 10, 64)
+	if err != nil {
+		apiError(w, req, 400, "INVALID_ARG_SINCE")
+		return
+	}
+
+	topic, err := s.nsqd.GetExistingTopic(topicName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_TOPIC")
+		return
+	}
+
+	if topic.retention == nil {
+		apiError(w, req, 409, "RETENTION_NOT_ENABLED")
+		return
+	}
+
+	_, err = topic.GetExistingChannel(channelName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_CHANNEL")
+		return
+	}
+
+	count, err := topic.RewindChannel(channelName, since, s.nsqd.idChan)
+	if err != nil {
+		log.Printf("ERROR: failed to rewind %s:%s - %s", topicName, channelName, err.Error())
+		apiError(w, req, 500, "INTERNAL_ERROR")
+		return
+	}
+
+	util.ApiResponse(w, 200, "OK", struct {
+		Count int `json:"count"`
+	}{count})
+}
+
+func (s *httpServer) configChannelHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	topicName, channelName, err := util.GetTopicChannelArgs(reqParams)
+	if err != nil {
+		apiError(w, req, 400, err.Error())
+		return
+	}
+
+	topic, err := s.nsqd.GetExistingTopic(topicName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_TOPIC")
+		return
+	}
+
+	channel, err := topic.GetExistingChannel(channelName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_CHANNEL")
+		return
+	}
+
+	for _, key := range []string{"ordered", "mode", "max_clients", "dispatch"} {
+		value, err := reqParams.Query(key)
+		if err != nil {
+			continue
+		}
+		err = channel.SetOption(key, value)
+		if err != nil {
+			apiError(w, req, 400, "INVALID_ARG_"+strings.ToUpper(key))
+			return
+		}
+	}
+
+	util.ApiResponse(w, 200, "OK", struct {
+		Ordered    bool   `json:"ordered"`
+		Mode       string `json:"mode"`
+		MaxClients int    `json:"max_clients"`
+		Dispatch   string `json:"dispatch"`
+	}{channel.IsOrdered(), channel.Mode(), channel.MaxClients(), channel.DispatchPolicy()})
+}
+
+func (s *httpServer) channelClientsHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	topicName, channelName, err := util.GetTopicChannelArgs(reqParams)
+	if err != nil {
+		apiError(w, req, 400, err.Error())
+		return
+	}
+
+	topic, err := s.nsqd.GetExistingTopic(topicName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_TOPIC")
+		return
+	}
+
+	channel, err := topic.GetExistingChannel(channelName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_CHANNEL")
+		return
+	}
+
+	channel.RLock()
+	clientStats := channel.clientStats()
+	channel.RUnlock()
+
+	clients := make([]interface{}, len(clientStats))
+	for i, stats := range clientStats {
+		clients[i] = clientStatsJSON(stats)
+	}
+
+	util.ApiResponse(w, 200, "OK", struct {
+		Clients []interface{} `json:"clients"`
+	}{clients})
+}
+
+// channelClientHandler kicks (disconnects), drains (forces into RDY 0)
+// or undrains the client identified by `id` or `address`
+func (s *httpServer) channelClientHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	topicName, channelName, err := util.GetTopicChannelArgs(reqParams)
+	if err != nil {
+		apiError(w, req, 400, err.Error())
+		return
+	}
+
+	var id int64
+	idStr, err := reqParams.Query("id")
+	if err == nil {
+		id, err = strconv.ParseInt(idStr, 10, 64)
+		if err != nil || id <= 0 {
+			apiError(w, req, 400, "INVALID_ARG_ID")
+			return
+		}
+	}
+	address, _ := reqParams.Query("address")
+	if id == 0 && address == "" {
+		apiError(w, req, 400, "MISSING_ARG_ID")
+		return
+	}
+
+	topic, err := s.nsqd.GetExistingTopic(topicName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_TOPIC")
+		return
+	}
+
+	channel, err := topic.GetExistingChannel(channelName)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_CHANNEL")
+		return
+	}
+
+	client, err := channel.FindClient(id, address)
+	if err != nil {
+		apiError(w, req, 404, "INVALID_CLIENT")
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/kick"):
+		// the client's in-flight messages are requeued when
+		// it's removed from the channel as its connection closes
+		log.Printf("HTTP: kicking client(%s) from %s:%s", client.Stats().address, topicName, channelName)
+		client.Close()
+	case strings.HasSuffix(req.URL.Path, "/undrain"):
+		client.UnDrain()
+	default:
+		log.Printf("HTTP: draining client(%s) of %s:%s", client.Stats().address, topicName, channelName)
+		client.Drain()
+	}
+
+	util.ApiResponse(w, 200, "OK", nil)
+}
+
+// auditHandler returns the most recent entries of the audit log
+// (optionally only those for a `topic` and/or `channel`)
+func (s *httpServer) auditHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	limit := 100
+	limitStr, err := reqParams.Query("limit")
+	if err == nil {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			apiError(w, req, 400, "INVALID_ARG_LIMIT")
+			return
+		}
+	}
+	topicName, _ := reqParams.Query("topic")
+	channelName, _ := reqParams.Query("channel")
+
+	entries := s.nsqd.auditLog.Entries(topicName, channelName, limit)
+	util.ApiResponse(w, 200, "OK", struct {
+		Entries []*AuditEntry `json:"entries"`
+	}{entries})
+}
@@ -0,0 +1,301 @@
+package nsqd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type apiResponse struct {
+	StatusCode int             `json:"status_code"`
+	StatusTxt  string          `json:"status_txt"`
+	Data       json.RawMessage `json:"data"`
+}
+
+func v1Request(t *testing.T, httpAddr *net.TCPAddr, method string, path string, accept string, body string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", httpAddr, path), bytes.NewBufferString(body))
+	assert.Equal(t, err, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.Equal(t, err, nil)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, err, nil)
+	return resp, data
+}
+
+func v1APIRequest(t *testing.T, httpAddr *net.TCPAddr, method string, path string, body string) (int, apiResponse) {
+	resp, data := v1Request(t, httpAddr, method, path, "", body)
+	assert.Equal(t, resp.Header.Get("Content-Type"), "application/json; charset=utf-8")
+	var apiResp apiResponse
+	err := json.Unmarshal(data, &apiResp)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, apiResp.StatusCode, resp.StatusCode)
+	return resp.StatusCode, apiResp
+}
+
+func TestHTTPv1(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	_, httpAddr, nsqd := mustStartNSQd(NewOptions())
+	defer nsqd.Exit()
+
+	topicName := "test_http_v1" + strconv.Itoa(int(time.Now().Unix()))
+	topicPath := "/v1/topic/" + topicName
+	channelPath := "/v1/channel/" + topicName + "/ch"
+
+	statusCode, resp := v1APIRequest(t, httpAddr, "POST", topicPath+"/pub", "test")
+	assert.Equal(t, statusCode, 200)
+	assert.Equal(t, resp.StatusTxt, "OK")
+
+	statusCode, resp = v1APIRequest(t, httpAddr, "POST", topicPath+"/mpub", "test\ntest")
+	assert.Equal(t, statusCode, 200)
+	topic, err := nsqd.GetExistingTopic(topicName)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, topic.Depth(), int64(3))
+
+	statusCode, resp = v1APIRequest(t, httpAddr, "POST", channelPath, "")
+	assert.Equal(t, statusCode, 200)
+	_, err = topic.GetExistingChannel("ch")
+	assert.Equal(t, err, nil)
+
+	statusCode, resp = v1APIRequest(t, httpAddr, "POST", channelPath+"/pause", "")
+	assert.Equal(t, statusCode, 200)
+	channel, _ := topic.GetExistingChannel("ch")
+	assert.Equal(t, channel.IsPaused(), true)
+	v1APIRequest(t, httpAddr, "POST", channelPath+"/unpause", "")
+	assert.Equal(t, channel.IsPaused(), false)
+
+	// errors have a status code matching their cause
+	statusCode, resp = v1APIRequest(t, httpAddr, "DELETE", "/v1/channel/"+topicName+"/missing", "")
+	assert.Equal(t, statusCode, 404)
+	assert.Equal(t, resp.StatusTxt, "INVALID_CHANNEL")
+
+	statusCode, resp = v1APIRequest(t, httpAddr, "POST", "/v1/topic/invalid!/pub", "test")
+	assert.Equal(t, statusCode, 400)
+	assert.Equal(t, resp.StatusTxt, "INVALID_ARG_TOPIC")
+
+	statusCode, resp = v1APIRequest(t, httpAddr, "POST", channelPath+"/rewind", "")
+	assert.Equal(t, statusCode, 400)
+	assert.Equal(t, resp.StatusTxt, "MISSING_ARG_SINCE")
+
+	statusCode, resp = v1APIRequest(t, httpAddr, "GET", "/v1/nothing", "")
+	assert.Equal(t, statusCode, 404)
+	assert.Equal(t, resp.StatusTxt, "NOT_FOUND")
+
+	// methods are enforced
+	httpResp, _ := v1Request(t, httpAddr, "GET", topicPath+"/pub", "", "")
+	assert.Equal(t, httpResp.StatusCode, 405)
+	assert.Equal(t, httpResp.Header.Get("Allow"), "POST")
+	httpResp, _ = v1Request(t, httpAddr, "GET", topicPath, "", "")
+	assert.Equal(t, httpResp.StatusCode, 405)
+	assert.Equal(t, httpResp.Header.Get("Allow"), "POST, DELETE")
+
+	// the legacy endpoints respond 500 to every error
+	httpResp, _ = v1Request(t, httpAddr, "GET", "/delete_channel?topic="+topicName+"&channel=missing", "", "")
+	assert.Equal(t, httpResp.StatusCode, 500)
+
+	// content negotiation
+	statusCode, resp = v1APIRequest(t, httpAddr, "GET", "/v1/stats", "")
+	assert.Equal(t, statusCode, 200)
+	assert.Equal(t, strings.Contains(string(resp.Data), topicName), true)
+
+	httpResp, body := v1Request(t, httpAddr, "GET", "/v1/stats", "text/plain", "")
+	assert.Equal(t, httpResp.StatusCode, 200)
+	assert.Equal(t, httpResp.Header.Get("Content-Type"), "text/plain; charset=utf-8")
+	assert.Equal(t, strings.HasPrefix(string(body), "nsqd v"), true)
+
+	httpResp, _ = v1Request(t, httpAddr, "GET", "/v1/info", "text/plain", "")
+	assert.Equal(t, httpResp.StatusCode, 406)
+
+	statusCode, resp = v1APIRequest(t, httpAddr, "GET", "/v1/ping", "")
+	assert.Equal(t, statusCode, 200)
+	assert.Equal(t, resp.StatusTxt, "OK")
+
+	// legacy endpoints keep working
+	httpResp, body = v1Request(t, httpAddr, "GET", "/put?topic="+topicName, "", "test")
+	assert.Equal(t, httpResp.StatusCode, 200)
+	assert.Equal(t, string(body), "OK")
+	httpResp, _ = v1Request(t, httpAddr, "GET", "/delete_channel?topic="+topicName+"&channel=missing", "", "")
+	assert.Equal(t, httpResp.StatusCode, 500)
+
+	statusCode, resp = v1APIRequest(t, httpAddr, "DELETE", topicPath, "")
+	assert.Equal(t, statusCode, 200)
+	_, err = nsqd.GetExistingTopic(topicName)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestStatsFilters(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	_, httpAddr, nsqd := mustStartNSQd(NewOptions())
+	defer nsqd.Exit()
+
+	topicName := "test_stats_filters" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	topic.GetChannel("ch1")
+	channel := topic.GetChannel("ch2")
+	nsqd.GetTopic(topicName + "_other").GetChannel("ch1")
+
+	conn, _ := net.Pipe()
+	client := NewClientV2(conn, nsqd)
+	client.Channel = channel
+	client.UserAgent = "test/1.0"
+	channel.AddClient(client)
+	defer channel.RemoveClient(client)
+
+	var stats struct {
+		Topics []TopicStats `json:"topics"`
+		Totals StatsTotals  `json:"totals"`
+	}
+	getStats := func(query string) {
+		statusCode, resp := v1APIRequest(t, httpAddr, "GET", "/stats?format=json"+query, "")
+		assert.Equal(t, statusCode, 200)
+		stats.Topics = nil
+		err := json.Unmarshal(resp.Data, &stats)
+		assert.Equal(t, err, nil)
+	}
+
+	getStats("&topic=" + topicName)
+	assert.Equal(t, len(stats.Topics), 1)
+	assert.Equal(t, stats.Topics[0].TopicName, topicName)
+	assert.Equal(t, len(stats.Topics[0].Channels), 2)
+	assert.Equal(t, stats.Totals.ChannelCount, 2)
+	assert.Equal(t, stats.Totals.ClientCount, 1)
+
+	getStats("&topic=" + topicName + "&channel=ch2")
+	assert.Equal(t, len(stats.Topics), 1)
+	assert.Equal(t, len(stats.Topics[0].Channels), 1)
+	assert.Equal(t, stats.Topics[0].Channels[0].ClientCount, 1)
+	assert.Equal(t, len(stats.Topics[0].Channels[0].Clients), 1)
+
+	_, resp := v1APIRequest(t, httpAddr, "GET", "/stats?format=json&topic="+topicName+"&channel=ch2", "")
+	assert.Equal(t, strings.Contains(string(resp.Data), `"user_agent":"test/1.0"`), true)
+	assert.Equal(t, strings.Contains(string(resp.Data), `"finish_rate":0`), true)
+
+	getStats("&channel=ch1")
+	assert.Equal(t, stats.Totals.TopicCount >= 2, true)
+	for _, topicStats := range stats.Topics {
+		assert.Equal(t, len(topicStats.Channels), 1)
+	}
+
+	getStats("&topic=" + topicName + "&include_clients=false")
+	assert.Equal(t, stats.Topics[0].Channels[1].ClientCount, 1)
+	assert.Equal(t, len(stats.Topics[0].Channels[1].Clients), 0)
+
+	getStats("&topic=" + topicName + "&aggregate=true")
+	assert.Equal(t, len(stats.Topics), 0)
+	assert.Equal(t, stats.Totals.TopicCount, 1)
+	assert.Equal(t, stats.Totals.ChannelCount, 2)
+
+	statusCode, resp := v1APIRequest(t, httpAddr, "GET", "/stats?format=json&aggregate=maybe", "")
+	assert.Equal(t, statusCode, 500)
+	assert.Equal(t, resp.StatusTxt, "INVALID_ARG_AGGREGATE")
+}
+
+func TestHealth(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	options := NewOptions()
+	options.MemBudget = 1000
+	_, httpAddr, nsqd := mustStartNSQd(options)
+	defer nsqd.Exit()
+
+	for _, path := range []string{"/ping", "/health/live", "/health/ready"} {
+		httpResp, body := v1Request(t, httpAddr, "GET", path, "", "")
+		assert.Equal(t, httpResp.StatusCode, 200)
+		assert.Equal(t, string(body), "OK")
+	}
+	statusCode, resp := v1APIRequest(t, httpAddr, "GET", "/v1/health/ready", "")
+	assert.Equal(t, statusCode, 200)
+	assert.Equal(t, resp.StatusTxt, "OK")
+
+	waitForReadiness := func(count int) {
+		for i := 0; i < 100 && len(nsqd.Readiness()) != count; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// nothing listens on port 1
+	nsqd.SetLookupdTCPAddrs([]string{"127.0.0.1:1"})
+	waitForReadiness(1)
+	statusCode, resp = v1APIRequest(t, httpAddr, "GET", "/ping", "")
+	assert.Equal(t, statusCode, 503)
+	assert.Equal(t, resp.StatusTxt, "LOOKUPD_UNREACHABLE")
+	nsqd.SetLookupdTCPAddrs([]string{})
+	waitForReadiness(0)
+	assert.Equal(t, len(nsqd.Readiness()), 0)
+
+	// as long as one nsqlookupd can be reached nsqd can be found
+	nsqd.lookupdHealth.set("127.0.0.1:1", errors.New("connection refused"))
+	nsqd.lookupdHealth.set("127.0.0.1:2", nil)
+	assert.Equal(t, len(nsqd.Readiness()), 0)
+	nsqd.lookupdHealth.set("127.0.0.1:2", errors.New("connection refused"))
+	assert.Equal(t, len(nsqd.Readiness()), 2)
+	nsqd.lookupdHealth.remove("127.0.0.1:1")
+	nsqd.lookupdHealth.remove("127.0.0.1:2")
+
+	topicName := "test_health" + strconv.Itoa(int(time.Now().Unix()))
+	channel := nsqd.GetTopic(topicName).GetChannel("ch")
+	defer nsqd.DeleteExistingTopic(topicName)
+	channel.backend.(*DiskQueue).setErr("read", errors.New("invalid message size"))
+	nsqd.memoryBudget.Reserve(950)
+
+	var health struct {
+		Reasons []HealthReason `json:"reasons"`
+	}
+	statusCode, resp = v1APIRequest(t, httpAddr, "GET", "/v1/health/ready", "")
+	assert.Equal(t, statusCode, 503)
+	assert.Equal(t, resp.StatusTxt, "DISK_QUEUE_CORRUPT")
+	err := json.Unmarshal(resp.Data, &health)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(health.Reasons), 2)
+	assert.Equal(t, strings.HasPrefix(health.Reasons[0].Message, topicName+":ch: read failed"), true)
+	assert.Equal(t, health.Reasons[1].Reason, "MEMORY_PRESSURE")
+
+	// nsqd isn't stuck, so it's still live
+	httpResp, _ := v1Request(t, httpAddr, "GET", "/health/live", "", "")
+	assert.Equal(t, httpResp.StatusCode, 200)
+	assert.Equal(t, len(nsqd.Liveness()), 0)
+
+	// a router that has been writing the same message for too long
+	atomic.StoreInt64(&channel.routeStarted, time.Now().Add(-2*livenessTimeout).UnixNano())
+	statusCode, resp = v1APIRequest(t, httpAddr, "GET", "/v1/health/live", "")
+	assert.Equal(t, statusCode, 503)
+	assert.Equal(t, resp.StatusTxt, "ROUTER_STALLED")
+	atomic.StoreInt64(&channel.routeStarted, 0)
+
+	channel.backend.(*DiskQueue).setErr("read", nil)
+	nsqd.memoryBudget.Release(950)
+	assert.Equal(t, len(nsqd.Readiness()), 0)
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	assert.Equal(t, negotiateFormat("", true), "json")
+	assert.Equal(t, negotiateFormat("*/*", true), "json")
+	assert.Equal(t, negotiateFormat("text/plain", true), "text")
+	assert.Equal(t, negotiateFormat("text/plain", false), "")
+	assert.Equal(t, negotiateFormat("text/*", true), "text")
+	assert.Equal(t, negotiateFormat("application/json;q=0.5, text/plain", true), "text")
+	assert.Equal(t, negotiateFormat("text/plain;q=0.5, application/json", true), "json")
+	assert.Equal(t, negotiateFormat("text/html,application/xhtml+xml,*/*;q=0.8", true), "json")
+	assert.Equal(t, negotiateFormat("*/*, application/json;q=0", false), "")
+	assert.Equal(t, negotiateFormat("image/png", true), "")
+}
@@ -0,0 +1,215 @@
+package nsqd
+
+import (
+	"../../util"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// v1Route is an endpoint of the versioned HTTP API, served by the same
+// handler as its legacy counterpart with the parameters in its path
+// (`:topic`, `:channel`) passed as query params
+type v1Route struct {
+	method   string
+	segments []string
+	handler  http.HandlerFunc
+	text     bool // whether it can respond with text/plain
+}
+
+func newV1Route(method string, pattern string, handler http.HandlerFunc, text bool) *v1Route {
+	return &v1Route{
+		method:   method,
+		segments: strings.Split(strings.Trim(pattern, "/"), "/"),
+		handler:  handler,
+		text:     text,
+	}
+}
+
+// match returns the route's parameters when it matches the path
+func (r *v1Route) match(segments []string) (url.Values, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+	params := make(url.Values)
+	for i, segment := range r.segments {
+		if strings.HasPrefix(segment, ":") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params.Set(segment[1:], segments[i])
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (r *v1Route) allows(method string) bool {
+	return r.method == method || (r.method == "GET" && method == "HEAD")
+}
+
+func (s *httpServer) v1Handler(w http.ResponseWriter, req *http.Request) {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	var allowed []string
+	for _, route := range s.v1Routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		if !route.allows(req.Method) {
+			allowed = append(allowed, route.method)
+			continue
+		}
+		s.serveV1(w, req, route, params)
+		return
+	}
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		util.ApiResponse(w, 405, "METHOD_NOT_ALLOWED", nil)
+		return
+	}
+	util.ApiResponse(w, 404, "NOT_FOUND", nil)
+}
+
+func (s *httpServer) serveV1(w http.ResponseWriter, req *http.Request, route *v1Route, params url.Values) {
+	format := negotiateFormat(req.Header.Get("Accept"), route.text)
+	if format == "" {
+		util.ApiResponse(w, 406, "NOT_ACCEPTABLE", nil)
+		return
+	}
+
+	query := req.URL.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	query.Set("format", format)
+
+	// handlers (and the audit log) read their arguments from the query
+	v1Req := new(http.Request)
+	*v1Req = *req
+	v1URL := *req.URL
+	v1URL.RawQuery = query.Encode()
+	v1Req.URL = &v1URL
+
+	vw := &v1ResponseWriter{ResponseWriter: w, statusCode: 200}
+	route.handler(vw, v1Req)
+	vw.flush(format)
+}
+
+// v1ResponseWriter buffers the response of a handler so that it can
+// be rewritten as a v1 API response
+type v1ResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *v1ResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+}
+
+func (w *v1ResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// flush writes the buffered response, plain text (ie. "OK") is wrapped
+// in an API response unless text was negotiated
+func (w *v1ResponseWriter) flush(format string) {
+	var resp struct {
+		StatusTxt string          `json:"status_txt"`
+		Data      json.RawMessage `json:"data"`
+	}
+	isJSON := strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+	if isJSON && json.Unmarshal(w.body.Bytes(), &resp) == nil {
+		util.ApiResponse(w.ResponseWriter, w.statusCode, resp.StatusTxt, resp.Data)
+		return
+	}
+
+	if format == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(w.body.Len()))
+		w.ResponseWriter.WriteHeader(w.statusCode)
+		w.ResponseWriter.Write(w.body.Bytes())
+		return
+	}
+
+	util.ApiResponse(w.ResponseWriter, w.statusCode, strings.TrimSpace(w.body.String()), nil)
+}
+
+// apiError responds with an error, statusCode is only used by the v1 API
+// (the legacy endpoints respond 500 to every error, as they always have)
+func apiError(w http.ResponseWriter, req *http.Request, statusCode int, statusTxt string) {
+	if !isV1Request(req) {
+		statusCode = 500
+	}
+	util.ApiResponse(w, statusCode, statusTxt, nil)
+}
+
+// isV1Request returns true if the request is for the v1 API
+func isV1Request(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, "/v1/")
+}
+
+// negotiateFormat returns "json" or, when the endpoint supports it and the
+// Accept header prefers it, "text" (an empty string when neither is acceptable)
+func negotiateFormat(accept string, text bool) string {
+	if accept == "" {
+		return "json"
+	}
+	jsonQuality := acceptQuality(accept, "application/json")
+	textQuality := 0.0
+	if text {
+		textQuality = acceptQuality(accept, "text/plain")
+	}
+	switch {
+	case jsonQuality == 0 && textQuality == 0:
+		return ""
+	case textQuality > jsonQuality:
+		return "text"
+	}
+	return "json"
+}
+
+// acceptQuality returns the q value the Accept header gives the media type
+// (from its most specific matching media range)
+func acceptQuality(accept string, mediaType string) float64 {
+	mediaGroup := mediaType[:strings.Index(mediaType, "/")] + "/*"
+
+	quality := 0.0
+	specificity := -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+
+		var s int
+		switch mediaRange {
+		case mediaType:
+			s = 2
+		case mediaGroup:
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity = s
+
+		quality = 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				quality, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+	}
+	return quality
+}
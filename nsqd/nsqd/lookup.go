@@ -0,0 +1,257 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"../../util"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/bitly/go-notify"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+func (n *NSQd) lookupLoop() {
+	notifyChannelChan := make(chan interface{})
+	notifyTopicChan := make(chan interface{})
+	syncTopicChan := make(chan *nsq.LookupPeer)
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("ERROR: failed to get hostname - %s", err.Error())
+	}
+
+	// the lookupds that refused our worker id, they're asked again every
+	// heartbeat (the conflict is resolved once the other nsqd goes away)
+	conflicts := make(map[string]bool)
+	setConflict := func(lp *nsq.LookupPeer, conflict bool) {
+		if conflict {
+			conflicts[lp.String()] = true
+		} else {
+			delete(conflicts, lp.String())
+		}
+		var flag int32
+		if len(conflicts) > 0 {
+			flag = 1
+		}
+		atomic.StoreInt32(&n.workerIdConflict, flag)
+	}
+
+	identify := func(lp *nsq.LookupPeer) {
+		cmd := nsq.Identify(util.BINARY_VERSION, n.tcpAddr.Port, n.httpAddr.Port, hostname, n.workerId)
+		resp, err := lp.Command(cmd)
+		if err != nil {
+			log.Printf("LOOKUPD(%s): ERROR %s - %s", lp, cmd, err.Error())
+		} else if bytes.Equal(resp, []byte("E_WORKER_ID_CONFLICT")) {
+			// lookupd won't accept our registrations and the message IDs we
+			// generate can collide with the other nsqd's (so publishing is
+			// refused until it's resolved)
+			log.Printf("LOOKUPD(%s): ERROR worker id %d is in use by another nsqd, restart with a unique --worker-id",
+				lp, n.workerId)
+			setConflict(lp, true)
+		} else if bytes.Equal(resp, []byte("E_INVALID")) {
+			log.Printf("LOOKUPD(%s): lookupd returned %s", lp, resp)
+		} else {
+			if conflicts[lp.String()] {
+				log.Printf("LOOKUPD(%s): worker id %d is no longer in use by another nsqd", lp, n.workerId)
+			}
+			setConflict(lp, false)
+			err = json.Unmarshal(resp, &lp.PeerInfo)
+			if err != nil {
+				log.Printf("LOOKUPD(%s): ERROR parsing response - %v", lp, resp)
+			} else {
+				log.Printf("LOOKUPD(%s): peer info %+v", lp, lp.PeerInfo)
+			}
+		}
+	}
+
+	connectCallback := func(lp *nsq.LookupPeer) {
+		identify(lp)
+		go func() {
+			syncTopicChan <- lp
+		}()
+	}
+
+	var lookupPeers []*nsq.LookupPeer
+	for _, host := range n.lookupdTCPAddrs {
+		lookupPeers = append(lookupPeers, n.newLookupPeer(host, connectCallback))
+	}
+	n.Lock()
+	n.lookupPeers = lookupPeers
+	n.Unlock()
+
+	// always registered, peers can be added by a config reload
+	notify.Start(n.eventName("channel_change"), notifyChannelChan)
+	notify.Start(n.eventName("topic_change"), notifyTopicChan)
+
+	// for announcements, lookupd determines the host automatically
+	ticker := time.Tick(15 * time.Second)
+	for {
+		select {
+		case <-ticker:
+			// send a heartbeat and read a response (read detects closed conns)
+			for _, lookupPeer := range n.lookupPeers {
+				log.Printf("LOOKUPD(%s): sending heartbeat", lookupPeer)
+				cmd := nsq.Ping()
+				_, err := n.lookupPeerCommand(lookupPeer, cmd)
+				if err != nil {
+					log.Printf("LOOKUPD(%s): ERROR %s - %s", lookupPeer, cmd, err.Error())
+				}
+
+				// (a reconnect above has already identified again)
+				if conflicts[lookupPeer.String()] {
+					identify(lookupPeer)
+					if !conflicts[lookupPeer.String()] {
+						go func(lp *nsq.LookupPeer) {
+							syncTopicChan <- lp
+						}(lookupPeer)
+					}
+				}
+			}
+		case channelObj := <-notifyChannelChan:
+			// notify all nsqds that a new channel exists, or that it's removed
+			channel := channelObj.(*Channel)
+			var cmd *nsq.Command
+			if channel.Exiting() == true {
+				cmd = nsq.UnRegister(channel.topicName, channel.name)
+			} else {
+				cmd = nsq.Register(channel.topicName, channel.name)
+			}
+			for _, lookupPeer := range n.lookupPeers {
+				log.Printf("LOOKUPD(%s): channel %s", lookupPeer, cmd)
+				_, err := n.lookupPeerCommand(lookupPeer, cmd)
+				if err != nil {
+					log.Printf("LOOKUPD(%s): ERROR %s - %s", lookupPeer, cmd, err.Error())
+				}
+			}
+		case newTopic := <-notifyTopicChan:
+			// notify all nsqds that a new topic exists, or that it's removed
+			topic := newTopic.(*Topic)
+			var cmd *nsq.Command
+			if topic.Exiting() == true {
+				cmd = nsq.UnRegister(topic.name, "")
+			} else {
+				cmd = nsq.Register(topic.name, "")
+			}
+			for _, lookupPeer := range n.lookupPeers {
+				log.Printf("LOOKUPD(%s): topic %s", lookupPeer, cmd)
+				_, err := n.lookupPeerCommand(lookupPeer, cmd)
+				if err != nil {
+					log.Printf("LOOKUPD(%s): ERROR %s - %s", lookupPeer, cmd, err.Error())
+				}
+			}
+		case lookupPeer := <-syncTopicChan:
+			if util.StringIndex(n.lookupdTCPAddrs, lookupPeer.String()) == -1 {
+				// removed since it connected
+				continue
+			}
+			commands := make([]*nsq.Command, 0)
+			// build all the commands first so we exit the lock(s) as fast as possible
+			n.RLock()
+			for _, topic := range n.topicMap {
+				topic.RLock()
+				if len(topic.channelMap) == 0 {
+					commands = append(commands, nsq.Register(topic.name, ""))
+				} else {
+					for _, channel := range topic.channelMap {
+						commands = append(commands, nsq.Register(channel.topicName, channel.name))
+					}
+				}
+				topic.RUnlock()
+			}
+			n.RUnlock()
+
+			for _, cmd := range commands {
+				log.Printf("LOOKUPD(%s): %s", lookupPeer, cmd)
+				_, err := n.lookupPeerCommand(lookupPeer, cmd)
+				if err != nil {
+					log.Printf("LOOKUPD(%s): ERROR %s - %s", lookupPeer, cmd, err.Error())
+					break
+				}
+			}
+		case addrs := <-n.lookupdChan:
+			lookupPeers := make([]*nsq.LookupPeer, 0, len(addrs))
+			for _, lookupPeer := range n.lookupPeers {
+				if util.StringIndex(addrs, lookupPeer.String()) == -1 {
+					log.Printf("LOOKUP: removing peer %s", lookupPeer)
+					lookupPeer.Close()
+					n.lookupdHealth.remove(lookupPeer.String())
+					setConflict(lookupPeer, false)
+					continue
+				}
+				lookupPeers = append(lookupPeers, lookupPeer)
+			}
+			for _, host := range addrs {
+				if util.StringIndex(n.lookupdTCPAddrs, host) == -1 {
+					lookupPeers = append(lookupPeers, n.newLookupPeer(host, connectCallback))
+				}
+			}
+			n.Lock()
+			n.lookupdTCPAddrs = addrs
+			n.lookupPeers = lookupPeers
+			n.Unlock()
+		case <-n.exitChan:
+			goto exit
+		}
+	}
+
+exit:
+	log.Printf("LOOKUP: closing")
+	// a pending Post holds the lock Stop needs until its event is received
+	go func() {
+		for _ = range notifyChannelChan {
+		}
+	}()
+	go func() {
+		for _ = range notifyTopicChan {
+		}
+	}()
+	notify.Stop(n.eventName("channel_change"), notifyChannelChan)
+	notify.Stop(n.eventName("topic_change"), notifyTopicChan)
+}
+
+func (n *NSQd) newLookupPeer(host string, connectCallback func(*nsq.LookupPeer)) *nsq.LookupPeer {
+	log.Printf("LOOKUP: adding peer %s", host)
+	lookupPeer := nsq.NewLookupPeer(host, connectCallback)
+	n.lookupPeerCommand(lookupPeer, nil) // start the connection
+	return lookupPeer
+}
+
+// SetLookupdTCPAddrs changes the nsqlookupd instances this nsqd registers
+// with, connecting to new ones and disconnecting from those removed
+func (n *NSQd) SetLookupdTCPAddrs(addrs []string) error {
+	if len(addrs) > 0 && (n.options.TCPAddress == "" || n.options.HTTPAddress == "") {
+		return errors.New("registering with nsqlookupd requires a tcp and http address")
+	}
+	select {
+	case n.lookupdChan <- addrs:
+	case <-n.exitChan:
+	}
+	return nil
+}
+
+func (n *NSQd) lookupHttpAddrs() []string {
+	n.RLock()
+	defer n.RUnlock()
+
+	var lookupHttpAddrs []string
+	for _, lp := range n.lookupPeers {
+		if len(lp.PeerInfo.Address) <= 0 {
+			continue
+		}
+		addr := net.JoinHostPort(lp.PeerInfo.Address, strconv.Itoa(lp.PeerInfo.HttpPort))
+		lookupHttpAddrs = append(lookupHttpAddrs, addr)
+	}
+	return lookupHttpAddrs
+}
+
+// HasWorkerIdConflict returns a boolean indicating if a nsqlookupd refuses
+// this nsqd because another is using its worker id (while it does nsqd
+// refuses to publish)
+func (n *NSQd) HasWorkerIdConflict() bool {
+	return atomic.LoadInt32(&n.workerIdConflict) == 1
+}
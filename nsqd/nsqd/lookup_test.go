@@ -0,0 +1,78 @@
+package nsqd
+
+import (
+	"../../nsqlookupd/nsqlookupd"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func mustStartLookupd(tcpSocket string) *nsqlookupd.NSQLookupd {
+	options := nsqlookupd.NewOptions()
+	options.TCPAddress = "127.0.0.1:0"
+	options.HTTPAddress = "127.0.0.1:0"
+	if tcpSocket != "" {
+		options.TCPAddress = ""
+		options.TCPSocket = tcpSocket
+	}
+	lookupd := nsqlookupd.New(options)
+	err := lookupd.Start()
+	if err != nil {
+		panic(err)
+	}
+	return lookupd
+}
+
+// topics and channels are registered with nsqlookupd
+func TestLookupRegistration(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	lookupd := mustStartLookupd("")
+	defer lookupd.Exit()
+
+	testLookupRegistration(t, lookupd, lookupd.TCPAddr().String())
+}
+
+// nsqlookupd can be connected to over a unix socket
+func TestLookupRegistrationUnixSocket(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	dataPath, err := ioutil.TempDir("", "nsqlookupd")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dataPath)
+
+	tcpSocket := path.Join(dataPath, "nsqlookupd.sock")
+	lookupd := mustStartLookupd(tcpSocket)
+	defer lookupd.Exit()
+
+	testLookupRegistration(t, lookupd, "unix:"+tcpSocket)
+}
+
+func testLookupRegistration(t *testing.T, lookupd *nsqlookupd.NSQLookupd, lookupdAddr string) {
+	options := NewOptions()
+	options.LookupdTCPAddrs = []string{lookupdAddr}
+	tcpAddr, _, nsqd := mustStartNSQd(options)
+	defer nsqd.Exit()
+
+	topicName := "lookup_test" + strconv.Itoa(int(time.Now().UnixNano()))
+	nsqd.GetTopic(topicName).GetChannel("ch")
+
+	for i := 0; i < 100; i++ {
+		if len(lookupd.DB.FindRegistrations("channel", topicName, "ch")) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, len(lookupd.DB.FindRegistrations("channel", topicName, "ch")), 1)
+
+	producers := lookupd.DB.FindProducers("topic", topicName, "")
+	assert.Equal(t, len(producers), 1)
+	assert.Equal(t, producers[0].TcpPort, tcpAddr.Port)
+}
@@ -0,0 +1,52 @@
+package nsqd
+
+import (
+	"sync/atomic"
+)
+
+// MemoryBudget bounds the bytes of messages queued in memory across every
+// topic and channel, once it is exhausted their routers spill to the backend
+//
+// usage is tracked even without a budget so that it can be reported
+type MemoryBudget struct {
+	max  int64 // 0 means unlimited
+	used int64
+}
+
+func NewMemoryBudget(max int64) *MemoryBudget {
+	return &MemoryBudget{max: max}
+}
+
+// Reserve accounts for a message about to be queued in memory, returning
+// false (and reserving nothing) if it would exceed the budget
+func (b *MemoryBudget) Reserve(size int64) bool {
+	if b.max <= 0 {
+		atomic.AddInt64(&b.used, size)
+		return true
+	}
+
+	for {
+		used := atomic.LoadInt64(&b.used)
+		if used+size > b.max {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.used, used, used+size) {
+			return true
+		}
+	}
+}
+
+// Release returns the bytes of a message that has left memory to the budget
+func (b *MemoryBudget) Release(size int64) {
+	atomic.AddInt64(&b.used, -size)
+}
+
+// Used returns the bytes of messages currently queued in memory
+func (b *MemoryBudget) Used() int64 {
+	return atomic.LoadInt64(&b.used)
+}
+
+// Max returns the size of the budget (0 means unlimited)
+func (b *MemoryBudget) Max() int64 {
+	return b.max
+}
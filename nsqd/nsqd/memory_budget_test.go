@@ -0,0 +1,50 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// ensure topics and channels spill to disk once the node's memory budget is used up
+func TestMemoryBudget(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	size := messageSize(nsq.NewMessage([]byte("0123456789abcdef"), []byte("test")))
+	options := NewOptions()
+	options.MemBudget = 10 * size
+	nsqd := New(options)
+	defer nsqd.Exit()
+
+	topicName := "test_memory_budget" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+
+	for i := 0; i < 100; i++ {
+		topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, []byte("test")))
+	}
+	for topic.Depth() != 100 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, topic.backend.Depth(), int64(90))
+	assert.Equal(t, nsqd.memoryBudget.Used(), 10*size)
+
+	channel := topic.GetChannel("ch")
+	for i := 0; i < 100; i++ {
+		select {
+		case <-channel.clientMsgChan:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after receiving %d messages", i)
+		}
+		if nsqd.memoryBudget.Used() > options.MemBudget {
+			t.Fatalf("memory used %d exceeds budget %d", nsqd.memoryBudget.Used(), options.MemBudget)
+		}
+	}
+	assert.Equal(t, nsqd.memoryBudget.Used(), int64(0))
+}
@@ -0,0 +1,590 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"../../util"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"github.com/bitly/go-notify"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type NSQd struct {
+	sync.RWMutex
+	options         *Options
+	workerId        int64
+	topicMap        map[string]*Topic
+	lookupdTCPAddrs util.StringArray
+	tcpAddr         *net.TCPAddr
+	httpAddr        *net.TCPAddr
+	tcpListeners    []net.Listener
+	httpListeners   []net.Listener
+	idChan          chan []byte
+	exitChan        chan int
+	waitGroup       util.WaitGroupWrapper
+	lookupPeers     []*nsq.LookupPeer
+	lookupdChan     chan []string
+	diskFull        int32
+	diskFreeBytes   uint64
+	timeouts        *TimingWheel
+	memoryBudget    *MemoryBudget
+	webhookTargets  []*webhookTarget
+	auditLog        *AuditLog
+	trustedProxies  util.TrustedProxies
+
+	// when the id pump last generated (or handed off) an id, see Liveness()
+	idPumpHeartbeat int64
+
+	// set once nsqlookupd reports another nsqd is using our worker id
+	workerIdConflict int32
+
+	// whether each nsqlookupd could be reached, see Readiness()
+	lookupdHealth lookupdHealth
+
+	// the settings that can be changed at runtime
+	msgTimeout int64
+	verbose    int32
+
+	// prefixes the go-notify events of this instance, see postEvent()
+	eventPrefix string
+}
+
+type Options struct {
+	TCPAddress      string // <addr>:<port> to listen on, port 0 picks a free port (see TCPAddr())
+	HTTPAddress     string
+	TCPSocket       string // path of a unix socket to listen on (in addition to, or instead of, TCPAddress)
+	HTTPSocket      string
+	LookupdTCPAddrs []string
+	WorkerId        int64 // up to 65535, defaults to a hash of the hostname
+	Verbose         bool
+
+	MemQueueSize      int64
+	MemBudget         int64
+	DataPath          string
+	MaxBytesPerFile   int64
+	SyncEvery         int64
+	MsgTimeout        time.Duration
+	ClientTimeout     time.Duration
+	RetentionWindow   time.Duration
+	TopicRetention    map[string]time.Duration
+	MaxTopicDepth     int64
+	MaxTopicBytes     int64
+	MaxChannelDepth   int64
+	MaxChannelBytes   int64
+	DepthPolicy       string // reject, drop-oldest or drop-newest
+	PublishDurability string // none, ack or fsync
+	DiskLowWatermark  uint64
+	DiskHighWatermark uint64
+	DispatchPolicy    string // round-robin, least-in-flight or weighted
+
+	OutputBufferSize       int64
+	OutputBufferTimeout    time.Duration
+	MaxOutputBufferSize    int64
+	MaxOutputBufferTimeout time.Duration
+
+	WebhookURLs        []string
+	WebhookQueueSize   int64
+	WebhookMaxAttempts int
+
+	// the window over which each channel's latency percentiles are
+	// estimated (0 disables them)
+	LatencyWindow      time.Duration
+	LatencyPercentiles []float64
+
+	// the proxies (IPs or CIDR ranges, ie. nsqadmin) whose X-Forwarded-For
+	// and X-NSQ-User headers are recorded in the audit log
+	TrustedProxies   []string
+	AuditLogMaxBytes int64 // size at which the audit log is rotated
+}
+
+// policies applied when a topic/channel reaches its max depth
+const (
+	depthPolicyReject     = "reject"
+	depthPolicyDropOldest = "drop-oldest"
+	depthPolicyDropNewest = "drop-newest"
+)
+
+// when a publish is acknowledged
+const (
+	publishDurabilityNone  = "none"  // as soon as it is handed to the topic
+	publishDurabilityAck   = "ack"   // once it is in memory or written to the backend
+	publishDurabilityFsync = "fsync" // as above, but backend writes are fsynced first
+)
+
+// NewOptions returns the default options (those of the nsqd binary)
+func NewOptions() *Options {
+	return &Options{
+		TCPAddress:  "0.0.0.0:4150",
+		HTTPAddress: "0.0.0.0:4151",
+		WorkerId:    defaultWorkerId(),
+
+		MemQueueSize:      10000,
+		DataPath:          os.TempDir(),
+		MaxBytesPerFile:   104857600,
+		SyncEvery:         2500,
+		MsgTimeout:        60 * time.Second,
+		ClientTimeout:     nsq.DefaultClientTimeout,
+		TopicRetention:    make(map[string]time.Duration),
+		DepthPolicy:       depthPolicyReject,
+		PublishDurability: publishDurabilityNone,
+		DispatchPolicy:    dispatchPolicyRoundRobin,
+
+		OutputBufferSize:       defaultOutputBufferSize,
+		OutputBufferTimeout:    defaultOutputBufferTimeout,
+		MaxOutputBufferSize:    64 * 1024,
+		MaxOutputBufferTimeout: time.Second,
+
+		WebhookQueueSize:   1000,
+		WebhookMaxAttempts: 5,
+
+		LatencyWindow:      10 * time.Minute,
+		LatencyPercentiles: []float64{0.5, 0.95, 0.99},
+
+		AuditLogMaxBytes: 104857600,
+	}
+}
+
+// defaultWorkerId is a hash of the hostname across the full range of
+// worker ids (hosts that still collide are detected when they IDENTIFY
+// with nsqlookupd)
+func defaultWorkerId() int64 {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatal(err)
+	}
+	h := md5.New()
+	io.WriteString(h, hostname)
+	return int64(crc32.ChecksumIEEE(h.Sum(nil)) % (maxWorkerId + 1))
+}
+
+// Validate returns an error for the first invalid option, a disk high
+// watermark below the low watermark is raised to it
+func (o *Options) Validate() error {
+	if o.WorkerId < 0 || o.WorkerId > maxWorkerId {
+		return fmt.Errorf("worker id must be between 0 and %d", maxWorkerId)
+	}
+	if o.TCPAddress == "" && o.TCPSocket == "" {
+		return errors.New("a tcp address or socket is required")
+	}
+	if o.HTTPAddress == "" && o.HTTPSocket == "" {
+		return errors.New("an http address or socket is required")
+	}
+	// nsqlookupd only advertises TCP addresses
+	if len(o.LookupdTCPAddrs) > 0 && (o.TCPAddress == "" || o.HTTPAddress == "") {
+		return errors.New("registering with nsqlookupd requires a tcp and http address")
+	}
+	if o.MsgTimeout <= 0 {
+		return errors.New("msg timeout must be > 0")
+	}
+	switch o.DepthPolicy {
+	case depthPolicyReject, depthPolicyDropOldest, depthPolicyDropNewest:
+	default:
+		return fmt.Errorf("invalid depth policy %s", o.DepthPolicy)
+	}
+	switch o.PublishDurability {
+	case publishDurabilityNone, publishDurabilityAck, publishDurabilityFsync:
+	default:
+		return fmt.Errorf("invalid publish durability %s", o.PublishDurability)
+	}
+	if !isValidDispatchPolicy(o.DispatchPolicy) {
+		return fmt.Errorf("invalid dispatch policy %s", o.DispatchPolicy)
+	}
+	for topicName := range o.TopicRetention {
+		if !nsq.IsValidTopicName(topicName) {
+			return fmt.Errorf("invalid topic retention topic %s", topicName)
+		}
+	}
+	for _, percentile := range o.LatencyPercentiles {
+		if percentile <= 0 || percentile > 1 {
+			return fmt.Errorf("latency percentile %v must be > 0 and <= 1", percentile)
+		}
+	}
+	_, err := util.NewTrustedProxies(o.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	if o.DiskHighWatermark < o.DiskLowWatermark {
+		o.DiskHighWatermark = o.DiskLowWatermark
+	}
+	return nil
+}
+
+// retentionFor returns the retention window for a given topic
+// (0 means messages are not retained)
+func (o *Options) retentionFor(topicName string) time.Duration {
+	window, ok := o.TopicRetention[topicName]
+	if ok {
+		return window
+	}
+	return o.RetentionWindow
+}
+
+// the number of NSQd created, used to namespace their go-notify events
+var instanceCount int64
+
+// New returns an NSQd for the given options, which must not be modified
+// afterwards (see the Set methods for those that can change at runtime)
+func New(options *Options) *NSQd {
+	n := &NSQd{
+		workerId:        options.WorkerId,
+		options:         options,
+		topicMap:        make(map[string]*Topic),
+		lookupdTCPAddrs: append(util.StringArray{}, options.LookupdTCPAddrs...),
+		idChan:          make(chan []byte, 4096),
+		idPumpHeartbeat: time.Now().UnixNano(),
+		exitChan:        make(chan int),
+		timeouts:        NewTimingWheel(timingWheelTick, timingWheelSlots, timingWheelWorkers),
+
+		memoryBudget:   NewMemoryBudget(options.MemBudget),
+		webhookTargets: newWebhookTargets(options.WebhookURLs, options.WebhookQueueSize),
+		lookupdChan:    make(chan []string),
+		msgTimeout:     int64(options.MsgTimeout),
+		eventPrefix:    fmt.Sprintf("nsqd.%d.", atomic.AddInt64(&instanceCount, 1)),
+	}
+	n.SetVerbose(options.Verbose)
+	n.auditLog = NewAuditLog(fmt.Sprintf(path.Join(options.DataPath, "nsqd.%d.audit.log"), n.workerId),
+		options.AuditLogMaxBytes)
+	// invalid proxies are rejected by Validate()
+	n.trustedProxies, _ = util.NewTrustedProxies(options.TrustedProxies)
+
+	n.waitGroup.Wrap(func() { n.idPump() })
+
+	return n
+}
+
+// Start listens on the TCP and HTTP addresses and starts serving clients
+// and talking to nsqlookupd, an error is returned if either can't be
+// listened on
+func (n *NSQd) Start() error {
+	err := n.options.Validate()
+	if err != nil {
+		return err
+	}
+
+	tcpListeners, err := util.Listen(n.options.TCPAddress, n.options.TCPSocket)
+	if err != nil {
+		return err
+	}
+	httpListeners, err := util.Listen(n.options.HTTPAddress, n.options.HTTPSocket)
+	if err != nil {
+		for _, listener := range tcpListeners {
+			listener.Close()
+		}
+		return err
+	}
+	n.Lock()
+	n.tcpListeners = tcpListeners
+	n.httpListeners = httpListeners
+	// the addresses actually listened on (when given port 0)
+	n.tcpAddr = tcpAddrOf(tcpListeners)
+	n.httpAddr = tcpAddrOf(httpListeners)
+	n.Unlock()
+
+	n.waitGroup.Wrap(func() { n.lookupLoop() })
+	n.waitGroup.Wrap(func() { n.diskLoop() })
+	if len(n.webhookTargets) > 0 {
+		// registered before returning so that no later event is missed
+		eventChan := make(chan interface{})
+		notify.Start(n.eventName("topology_event"), eventChan)
+		n.waitGroup.Wrap(func() { n.webhookLoop(eventChan) })
+	}
+
+	protocols := map[int32]nsq.Protocol{
+		protocolV2Magic: &ProtocolV2{nsqd: n},
+	}
+	for _, listener := range tcpListeners {
+		l := listener
+		n.waitGroup.Wrap(func() { util.TcpServer(l, &TcpProtocol{protocols: protocols}) })
+	}
+	httpServer := newHTTPServer(n)
+	for _, listener := range httpListeners {
+		l := listener
+		n.waitGroup.Wrap(func() { httpServer.serve(l) })
+	}
+
+	return nil
+}
+
+// tcpAddrOf returns the address of the TCP (rather than unix socket)
+// listener, nil if there isn't one
+func tcpAddrOf(listeners []net.Listener) *net.TCPAddr {
+	for _, listener := range listeners {
+		if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+			return addr
+		}
+	}
+	return nil
+}
+
+// TCPAddr returns the address nsqd is listening on for TCP clients
+// (nil until started, or when only listening on a unix socket)
+func (n *NSQd) TCPAddr() *net.TCPAddr {
+	n.RLock()
+	defer n.RUnlock()
+	return n.tcpAddr
+}
+
+// HTTPAddr returns the address nsqd is listening on for HTTP clients
+// (nil until started, or when only listening on a unix socket)
+func (n *NSQd) HTTPAddr() *net.TCPAddr {
+	n.RLock()
+	defer n.RUnlock()
+	return n.httpAddr
+}
+
+// MsgTimeout returns the time a message can be in-flight before it's
+// requeued
+func (n *NSQd) MsgTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&n.msgTimeout))
+}
+
+func (n *NSQd) SetMsgTimeout(msgTimeout time.Duration) {
+	atomic.StoreInt64(&n.msgTimeout, int64(msgTimeout))
+}
+
+func (n *NSQd) isVerbose() bool {
+	return atomic.LoadInt32(&n.verbose) == 1
+}
+
+func (n *NSQd) SetVerbose(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&n.verbose, v)
+}
+
+// eventName namespaces a go-notify event to this instance
+func (n *NSQd) eventName(event string) string {
+	return n.eventPrefix + event
+}
+
+// postEvent posts (asynchronously) a go-notify event of this instance
+func (n *NSQd) postEvent(event string, v interface{}) {
+	go notify.Post(n.eventName(event), v)
+}
+
+func (n *NSQd) LoadMetadata() {
+	// 不同 workerId 是不同的存储路径
+	fn := fmt.Sprintf(path.Join(n.options.DataPath, "nsqd.%d.dat"), n.workerId)
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("ERROR: failed to read channel metadata from %s - %s", fn, err.Error())
+		}
+		return
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			// channel lines may be followed by tab separated key=value options
+			fields := strings.Split(line, "\t")
+			parts := strings.SplitN(fields[0], ":", 2)
+
+			if !nsq.IsValidTopicName(parts[0]) {
+				log.Printf("WARNING: skipping creation of invalid topic %s", parts[0])
+				continue
+			}
+			topic := n.GetTopic(parts[0])
+
+			if len(parts) < 2 {
+				continue
+			}
+			if !nsq.IsValidChannelName(parts[1]) {
+				log.Printf("WARNING: skipping creation of invalid channel %s", parts[1])
+			}
+			channel := topic.GetChannel(parts[1])
+
+			for _, option := range fields[1:] {
+				kv := strings.SplitN(option, "=", 2)
+				if len(kv) != 2 {
+					log.Printf("WARNING: skipping invalid channel option %s", option)
+					continue
+				}
+				err := channel.SetOption(kv[0], kv[1])
+				if err != nil {
+					log.Printf("WARNING: failed to set channel(%s) option %s - %s", channel.name, option, err.Error())
+				}
+			}
+		}
+	}
+}
+
+func (n *NSQd) Exit() {
+	for _, listener := range n.tcpListeners {
+		listener.Close()
+	}
+
+	for _, listener := range n.httpListeners {
+		listener.Close()
+	}
+
+	// persist metadata about what topics/channels we have
+	// so that upon restart we can get back to the same state
+	fn := fmt.Sprintf(path.Join(n.options.DataPath, "nsqd.%d.dat"), n.workerId)
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Printf("ERROR: failed to open channel metadata file %s - %s", fn, err.Error())
+	}
+
+	log.Printf("NSQ: closing topics")
+	n.Lock()
+	for _, topic := range n.topicMap {
+		if f != nil && !topic.ephemeralTopic {
+			topic.Lock()
+			fmt.Fprintf(f, "%s\n", topic.name)
+			for _, channel := range topic.channelMap {
+				if !channel.ephemeralChannel {
+					fmt.Fprintf(f, "%s:%s", topic.name, channel.name)
+					for _, option := range channel.Options() {
+						fmt.Fprintf(f, "\t%s", option)
+					}
+					fmt.Fprintf(f, "\n")
+				}
+			}
+			topic.Unlock()
+		}
+		topic.Close()
+	}
+	n.Unlock()
+
+	if f != nil {
+		f.Sync()
+		f.Close()
+	}
+
+	// after the topics, which stop their channels' timeouts as they close
+	n.timeouts.Close()
+
+	// we want to do this last as it closes the idPump (if closed first it
+	// could potentially starve items in process and deadlock)
+	close(n.exitChan)
+	n.waitGroup.Wait()
+
+	n.auditLog.Close()
+}
+
+// GetTopic performs a thread safe operation
+// to return a pointer to a Topic object (potentially new)
+func (n *NSQd) GetTopic(topicName string) *Topic {
+	n.Lock()
+	t, ok := n.topicMap[topicName]
+	if ok {
+		n.Unlock()
+		return t
+	} else {
+		t = NewTopic(topicName, n)
+		n.topicMap[topicName] = t
+		log.Printf("TOPIC(%s): created", t.name)
+
+		// release our global nsqd lock, and switch to a more granular topic lock while we init our
+		// channels from lookupd. This blocks concurrent PutMessages to this topic.
+		t.Lock()
+		defer t.Unlock()
+		n.Unlock()
+		// if using lookupd, make a blocking call to get the topics, and immediately create them.
+		// this makes sure that any message received is buffered to the right channels
+		lookupHttpAddrs := n.lookupHttpAddrs()
+		if len(lookupHttpAddrs) > 0 {
+			channelNames, _ := util.GetChannelsForTopic(t.name, lookupHttpAddrs)
+			for _, channelName := range channelNames {
+				t.getOrCreateChannel(channelName)
+			}
+		}
+	}
+	return t
+}
+
+// GetPublishTopic performs a thread safe operation
+// to return a pointer to a Topic object to publish to
+//
+// unlike GetTopic it returns nil for an ephemeral topic that doesn't exist,
+// it would have no channels (so the message would be discarded anyway) and
+// nothing would ever delete it
+func (n *NSQd) GetPublishTopic(topicName string) *Topic {
+	if strings.HasSuffix(topicName, "#ephemeral") {
+		topic, err := n.GetExistingTopic(topicName)
+		if err != nil {
+			return nil
+		}
+		return topic
+	}
+	return n.GetTopic(topicName)
+}
+
+// GetExistingTopic gets a topic only if it exists
+func (n *NSQd) GetExistingTopic(topicName string) (*Topic, error) {
+	n.RLock()
+	defer n.RUnlock()
+	topic, ok := n.topicMap[topicName]
+	if !ok {
+		return nil, errors.New("topic does not exist")
+	}
+	return topic, nil
+}
+
+// DeleteExistingTopic removes a topic only if it exists
+func (n *NSQd) DeleteExistingTopic(topicName string) error {
+	n.Lock()
+	topic, ok := n.topicMap[topicName]
+	if !ok {
+		n.Unlock()
+		return errors.New("topic does not exist")
+	}
+	delete(n.topicMap, topicName)
+	// not defered so that we can continue while the topic async closes
+	n.Unlock()
+
+	log.Printf("TOPIC(%s): deleting", topic.name)
+
+	// delete empties all channels and the topic itself before closing
+	// (so that we dont leave any messages around)
+	topic.Delete()
+
+	// since we are explicitly deleting a topic (not just at system exit time)
+	// de-register this from the lookupd
+	n.postEvent("topic_change", topic)
+	n.postTopologyEvent(eventTopicDeleted, topic.name, "", nil)
+
+	return nil
+}
+
+func (n *NSQd) idPump() {
+	factory := NewGUIDFactory(n.workerId)
+	lastError := time.Now()
+	for {
+		id, err := factory.NewGUID()
+		if err != nil {
+			now := time.Now()
+			if now.Sub(lastError) > time.Second {
+				// only print the error once/second
+				log.Printf("ERROR: %s", err.Error())
+				lastError = now
+			}
+			runtime.Gosched()
+			continue
+		}
+		atomic.StoreInt64(&n.idPumpHeartbeat, time.Now().UnixNano())
+		select {
+		case n.idChan <- id.Encode():
+			atomic.StoreInt64(&n.idPumpHeartbeat, time.Now().UnixNano())
+		case <-n.exitChan:
+			goto exit
+		}
+	}
+
+exit:
+	log.Printf("ID: closing")
+}
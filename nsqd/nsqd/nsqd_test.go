@@ -1,11 +1,13 @@
-package main
+package nsqd
 
 import (
-	"../nsq"
+	"../../nsq"
+	"bytes"
 	"fmt"
 	"github.com/bmizerany/assert"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"path"
 	"strconv"
@@ -20,10 +22,10 @@ func TestStartup(t *testing.T) {
 	iterations := 300
 	doneExitChan := make(chan int)
 
-	options := NewNsqdOptions()
-	options.memQueueSize = 100
-	options.maxBytesPerFile = 10240
-	mustStartNSQd(options)
+	options := NewOptions()
+	options.MemQueueSize = 100
+	options.MaxBytesPerFile = 10240
+	_, _, nsqd := mustStartNSQd(options)
 
 	topicName := "nsqd_test" + strconv.Itoa(int(time.Now().Unix()))
 
@@ -63,10 +65,10 @@ func TestStartup(t *testing.T) {
 
 	// start up a new nsqd w/ the same folder
 
-	options = NewNsqdOptions()
-	options.memQueueSize = 100
-	options.maxBytesPerFile = 10240
-	mustStartNSQd(options)
+	options = NewOptions()
+	options.MemQueueSize = 100
+	options.MaxBytesPerFile = 10240
+	_, _, nsqd = mustStartNSQd(options)
 
 	go func() {
 		<-exitChan
@@ -105,9 +107,9 @@ func TestEphemeralChannel(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 	defer log.SetOutput(os.Stdout)
 
-	options := NewNsqdOptions()
-	options.memQueueSize = 100
-	mustStartNSQd(options)
+	options := NewOptions()
+	options.MemQueueSize = 100
+	_, _, nsqd := mustStartNSQd(options)
 
 	topicName := "ephemeral_test" + strconv.Itoa(int(time.Now().Unix()))
 	doneExitChan := make(chan int)
@@ -141,3 +143,59 @@ func TestEphemeralChannel(t *testing.T) {
 func TestNSQd_LoadMetadata(t *testing.T) {
 	fmt.Sprintf(path.Join("C://123123", "nsqd.%d.dat"), 123)
 }
+
+func TestDiskWatermarks(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	options := NewOptions()
+	options.DiskLowWatermark = 1 << 62
+	options.DiskHighWatermark = 1 << 62
+	nsqd := New(options)
+	defer nsqd.Exit()
+
+	nsqd.checkDisk()
+	assert.Equal(t, nsqd.IsDiskFull(), true)
+	assert.NotEqual(t, nsqd.diskFreeBytes, uint64(0))
+
+	// stays read-only until free space is above the high watermark
+	options.DiskLowWatermark = 1
+	nsqd.checkDisk()
+	assert.Equal(t, nsqd.IsDiskFull(), true)
+
+	options.DiskHighWatermark = 1
+	nsqd.checkDisk()
+	assert.Equal(t, nsqd.IsDiskFull(), false)
+}
+
+// several instances can run in the same process
+func TestMultipleInstances(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	options := NewOptions()
+	options.WorkerId = 1
+	_, httpAddr, nsqd1 := mustStartNSQd(options)
+	defer nsqd1.Exit()
+
+	options = NewOptions()
+	options.WorkerId = 2
+	_, _, nsqd2 := mustStartNSQd(options)
+	defer nsqd2.Exit()
+
+	assert.NotEqual(t, nsqd1.TCPAddr().Port, nsqd2.TCPAddr().Port)
+	assert.NotEqual(t, nsqd1.eventName("topic_change"), nsqd2.eventName("topic_change"))
+
+	topicName := "multiple_test" + strconv.Itoa(int(time.Now().Unix()))
+	endpoint := fmt.Sprintf("http://%s/put?topic=%s", httpAddr, topicName)
+	resp, err := http.Post(endpoint, "application/octet-stream", bytes.NewBufferString("test"))
+	assert.Equal(t, err, nil)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, 200)
+
+	topic, err := nsqd1.GetExistingTopic(topicName)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, topic.Depth(), int64(1))
+	_, err = nsqd2.GetExistingTopic(topicName)
+	assert.NotEqual(t, err, nil)
+}
@@ -0,0 +1,554 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const maxTimeout = time.Hour
+
+const maxIdentifyBodyLength = 4096
+
+// the prefixes of the PUB params that are message attributes
+var (
+	replyToParam       = []byte("reply_to=")
+	correlationIdParam = []byte("correlation_id=")
+)
+
+const maxUserAgentLength = 256
+
+type ProtocolV2 struct {
+	nsq.Protocol
+	nsqd *NSQd
+}
+
+// BigEndian client byte sequence "  V2"
+var protocolV2Magic int32
+
+func init() {
+	buf := bytes.NewBuffer([]byte(nsq.MagicV2))
+	binary.Read(buf, binary.BigEndian, &protocolV2Magic)
+}
+
+func (p *ProtocolV2) IOLoop(conn net.Conn) error {
+	var err error
+	var line []byte
+
+	client := NewClientV2(conn, p.nsqd)
+	atomic.StoreInt32(&client.State, nsq.StateInit)
+	client.SetOutputBuffer(int(p.nsqd.options.OutputBufferSize), p.nsqd.options.OutputBufferTimeout)
+
+	err = nil
+	client.Reader = bufio.NewReader(client)
+	for {
+		client.SetReadDeadline(time.Now().Add(p.nsqd.options.ClientTimeout))
+		// ReadSlice does not allocate new space for the data each request
+		// ie. the returned slice is only valid until the next call to it
+		line, err = client.Reader.ReadSlice('\n')
+		if err != nil {
+			break
+		}
+
+		// trim the '\n'
+		line = line[:len(line)-1]
+		// optionally trim the '\r'
+		if line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+		params := bytes.Split(line, []byte(" "))
+
+		if p.nsqd.isVerbose() {
+			log.Printf("PROTOCOL(V2): [%s] %s", client, params[0])
+		}
+
+		response, err := p.Exec(client, params)
+		if err != nil {
+			log.Printf("ERROR: CLIENT(%s) - %s", client, err.(*nsq.ClientErr).Description())
+			err = p.Send(client, nsq.FrameTypeError, []byte(err.Error()))
+			if err != nil {
+				break
+			}
+			continue
+		}
+
+		if response != nil {
+			err = p.Send(client, nsq.FrameTypeResponse, response)
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	log.Printf("PROTOCOL(V2): [%s] exiting ioloop", client)
+	// TODO: gracefully send clients the close signal
+	conn.Close()
+	close(client.ExitChan)
+
+	return err
+}
+
+// Send buffers a frame for the client, anything other than a message
+// (and every frame for an unbuffered client) is flushed immediately
+func (p *ProtocolV2) Send(client *ClientV2, frameType int32, data []byte) error {
+	client.Lock()
+	defer client.Unlock()
+
+	client.frameBuf.Reset()
+	err := nsq.Frame(&client.frameBuf, frameType, data)
+	if err != nil {
+		return err
+	}
+
+	// a full buffer is written out to make room for the frame
+	client.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+	_, err = nsq.SendResponse(client.Writer, client.frameBuf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	isMessage := frameType == nsq.FrameTypeMessage || frameType == nsq.FrameTypeMessageWithAttributes
+	if !isMessage || client.OutputBufferTimeout == 0 {
+		return client.flush()
+	}
+
+	return nil
+}
+
+func (p *ProtocolV2) Exec(client *ClientV2, params [][]byte) ([]byte, error) {
+	switch {
+	case bytes.Equal(params[0], []byte("SUB")):
+		return p.SUB(client, params)
+	case bytes.Equal(params[0], []byte("RDY")):
+		return p.RDY(client, params)
+	case bytes.Equal(params[0], []byte("FIN")):
+		return p.FIN(client, params)
+	case bytes.Equal(params[0], []byte("REQ")):
+		return p.REQ(client, params)
+	case bytes.Equal(params[0], []byte("CLS")):
+		return p.CLS(client, params)
+	case bytes.Equal(params[0], []byte("NOP")):
+		return p.NOP(client, params)
+	case bytes.Equal(params[0], []byte("PUB")):
+		return p.PUB(client, params)
+	case bytes.Equal(params[0], []byte("IDENTIFY")):
+		return p.IDENTIFY(client, params)
+	}
+	return nil, nsq.NewClientErr("E_INVALID", fmt.Sprintf("invalid command %s", params[0]))
+}
+
+func (p *ProtocolV2) sendHeartbeat(client *ClientV2) error {
+	err := p.Send(client, nsq.FrameTypeResponse, []byte("_heartbeat_"))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *ProtocolV2) messagePump(client *ClientV2) {
+	var err error
+	var buf bytes.Buffer
+	var flusherChan <-chan time.Time
+
+	heartbeat := time.NewTicker(p.nsqd.options.ClientTimeout / 2)
+
+	// IDENTIFY is only accepted before SUB so this doesn't change
+	// while the pump is running
+	var outputBufferTicker *time.Ticker
+	if client.OutputBufferTimeout > 0 {
+		outputBufferTicker = time.NewTicker(client.OutputBufferTimeout)
+	}
+
+	// the channel's dispatcher only delivers messages to client.clientMsgChan
+	// when the client is ready for them (and has already marked them in-flight)
+	for {
+		select {
+		case <-flusherChan:
+			flusherChan = nil
+			err = client.Flush()
+			if err != nil {
+				goto exit
+			}
+		case <-heartbeat.C:
+			err = p.sendHeartbeat(client)
+			if err != nil {
+				log.Printf("PROTOCOL(V2): error sending heartbeat - %s", err.Error())
+			}
+		case msg := <-client.clientMsgChan:
+			// there is room for the next message
+			client.tryUpdateReadyState()
+
+			if p.nsqd.isVerbose() {
+				log.Printf("PROTOCOL(V2): writing msg(%s) to client(%s) - %s",
+					msg.Id, client, msg.Body)
+			}
+
+			buf.Reset()
+			frameType := nsq.FrameTypeMessage
+			if client.MessageAttributes && msg.HasAttributes() {
+				frameType = nsq.FrameTypeMessageWithAttributes
+				err = msg.EncodeWithAttributes(&buf)
+			} else {
+				err = msg.Encode(&buf)
+			}
+			if err != nil {
+				goto exit
+			}
+
+			err = p.Send(client, frameType, buf.Bytes())
+			if err != nil {
+				goto exit
+			}
+
+			if outputBufferTicker == nil {
+				continue
+			}
+			// a client that won't be sent another message until it responds
+			// is waiting on what's buffered, otherwise more messages can be
+			// coalesced until the next tick
+			if len(client.clientMsgChan) == 0 && !client.IsReadyForMessages() {
+				flusherChan = nil
+				err = client.Flush()
+				if err != nil {
+					goto exit
+				}
+			} else {
+				flusherChan = outputBufferTicker.C
+			}
+		case <-client.ExitChan:
+			goto exit
+		}
+	}
+
+exit:
+	log.Printf("PROTOCOL(V2): [%s] exiting messagePump", client)
+	heartbeat.Stop()
+	if outputBufferTicker != nil {
+		outputBufferTicker.Stop()
+	}
+	// any messages that were delivered (including one left in clientMsgChan)
+	// are in-flight and get requeued when the client is removed
+	client.StopDelivery()
+	client.Channel.RemoveClient(client)
+	if err != nil {
+		log.Printf("PROTOCOL(V2): messagePump error - %s", err.Error())
+	}
+}
+
+func (p *ProtocolV2) SUB(client *ClientV2, params [][]byte) ([]byte, error) {
+	if atomic.LoadInt32(&client.State) != nsq.StateInit {
+		return nil, nsq.NewClientErr("E_INVALID", "client not initialized")
+	}
+
+	if len(params) < 3 {
+		return nil, nsq.NewClientErr("E_MISSING_PARAMS", "insufficient number of parameters")
+	}
+
+	topicName := string(params[1])
+	if !nsq.IsValidTopicName(topicName) {
+		return nil, nsq.NewClientErr("E_BAD_TOPIC", fmt.Sprintf("topic name '%s' is not valid", topicName))
+	}
+
+	channelName := string(params[2])
+	if !nsq.IsValidChannelName(channelName) {
+		return nil, nsq.NewClientErr("E_BAD_CHANNEL", fmt.Sprintf("channel name '%s' is not valid", channelName))
+	}
+
+	if len(params) >= 5 {
+		client.ShortIdentifier = string(params[3])
+		client.LongIdentifier = string(params[4])
+	}
+
+	if len(params) >= 6 {
+		weight, err := strconv.Atoi(string(params[5]))
+		if err != nil || weight < 1 || weight > maxClientWeight {
+			return nil, nsq.NewClientErr("E_INVALID", fmt.Sprintf("weight '%s' must be between 1 and %d", params[5], maxClientWeight))
+		}
+		client.weight = weight
+	}
+
+	topic := p.nsqd.GetTopic(topicName)
+	channel := topic.GetChannel(channelName)
+	// the dispatcher expects a client to know its channel as soon as it's added
+	client.Channel = channel
+	err := channel.AddClient(client)
+	if err != nil {
+		client.Channel = nil
+		if err == ErrChannelExclusive {
+			return nil, nsq.NewClientErr("E_CHANNEL_EXCLUSIVE", fmt.Sprintf("channel '%s' is exclusive and already has a client", channelName))
+		}
+		return nil, nsq.NewClientErr("E_TOO_MANY_CLIENTS", fmt.Sprintf("channel '%s' has reached its max clients", channelName))
+	}
+
+	atomic.StoreInt32(&client.State, nsq.StateSubscribed)
+
+	go p.messagePump(client)
+
+	return nil, nil
+}
+
+func (p *ProtocolV2) RDY(client *ClientV2, params [][]byte) ([]byte, error) {
+	var err error
+
+	state := atomic.LoadInt32(&client.State)
+
+	if state == nsq.StateClosing {
+		// just ignore ready changes on a closing channel
+		log.Printf("PROTOCOL(V2): ignoring RDY after CLS in state ClientStateV2Closing")
+		return nil, nil
+	}
+
+	if state != nsq.StateSubscribed {
+		return nil, nsq.NewClientErr("E_INVALID", "client not subscribed")
+	}
+
+	count := 1
+	if len(params) > 1 {
+		count, err = strconv.Atoi(string(params[1]))
+		if err != nil {
+			return nil, nsq.NewClientErr("E_INVALID", fmt.Sprintf("could not parse RDY count %s", params[1]))
+		}
+	}
+
+	if count > nsq.MaxReadyCount {
+		log.Printf("ERROR: client(%s) sent ready count %d > %d", client, count, nsq.MaxReadyCount)
+		count = nsq.MaxReadyCount
+	}
+
+	client.SetClientReadyCount(int64(count))
+
+	return nil, nil
+}
+
+func (p *ProtocolV2) FIN(client *ClientV2, params [][]byte) ([]byte, error) {
+	state := atomic.LoadInt32(&client.State)
+	if state != nsq.StateSubscribed && state != nsq.StateClosing {
+		return nil, nsq.NewClientErr("E_INVALID", "cannot finish in current state")
+	}
+
+	if len(params) < 2 {
+		return nil, nsq.NewClientErr("E_MISSING_PARAMS", "insufficient number of params")
+	}
+
+	idStr := params[1]
+	err := client.Channel.FinishMessage(client, idStr)
+	if err != nil {
+		return nil, nsq.NewClientErr("E_FIN_FAILED", err.Error())
+	}
+
+	client.FinishedMessage()
+
+	return nil, nil
+}
+
+func (p *ProtocolV2) REQ(client *ClientV2, params [][]byte) ([]byte, error) {
+	state := atomic.LoadInt32(&client.State)
+	if state != nsq.StateSubscribed && state != nsq.StateClosing {
+		return nil, nsq.NewClientErr("E_INVALID", "cannot re-queue in current state")
+	}
+
+	if len(params) < 3 {
+		return nil, nsq.NewClientErr("E_MISSING_PARAMS", "insufficient number of params")
+	}
+
+	idStr := params[1]
+	timeoutMs, err := strconv.Atoi(string(params[2]))
+	if err != nil {
+		return nil, nsq.NewClientErr("E_INVALID", fmt.Sprintf("could not parse timeout %s", params[2]))
+	}
+	timeoutDuration := time.Duration(timeoutMs) * time.Millisecond
+
+	if timeoutDuration < 0 || timeoutDuration > maxTimeout {
+		return nil, nsq.NewClientErr("E_INVALID", fmt.Sprintf("timeout %d out of range", timeoutDuration))
+	}
+
+	err = client.Channel.RequeueMessage(client, idStr, timeoutDuration)
+	if err != nil {
+		return nil, nsq.NewClientErr("E_REQ_FAILED", err.Error())
+	}
+
+	client.RequeuedMessage()
+
+	return nil, nil
+}
+
+func (p *ProtocolV2) CLS(client *ClientV2, params [][]byte) ([]byte, error) {
+	if atomic.LoadInt32(&client.State) != nsq.StateSubscribed {
+		return nil, nsq.NewClientErr("E_INVALID", "client not subscribed")
+	}
+
+	if len(params) > 1 {
+		return nil, nsq.NewClientErr("E_MISSING_PARAMS", "insufficient number of params")
+	}
+
+	client.StartClose()
+
+	return []byte("CLOSE_WAIT"), nil
+}
+
+func (p *ProtocolV2) NOP(client *ClientV2, params [][]byte) ([]byte, error) {
+	return nil, nil
+}
+
+// IDENTIFY negotiates the client's output buffering and whether it receives
+// message attributes (and sets its user agent), it must be sent before SUB
+func (p *ProtocolV2) IDENTIFY(client *ClientV2, params [][]byte) ([]byte, error) {
+	if atomic.LoadInt32(&client.State) != nsq.StateInit {
+		return nil, nsq.NewClientErr("E_INVALID", "cannot IDENTIFY in current state")
+	}
+
+	var bodyLen int32
+	err := binary.Read(client.Reader, binary.BigEndian, &bodyLen)
+	if err != nil {
+		return nil, nsq.NewClientErr("E_BAD_BODY", err.Error())
+	}
+	if bodyLen <= 0 || bodyLen > maxIdentifyBodyLength {
+		return nil, nsq.NewClientErr("E_BAD_BODY", fmt.Sprintf("body length %d out of range", bodyLen))
+	}
+
+	body := make([]byte, bodyLen)
+	_, err = io.ReadFull(client.Reader, body)
+	if err != nil {
+		return nil, nsq.NewClientErr("E_BAD_BODY", err.Error())
+	}
+
+	// 0 (or a missing field) keeps the server's default, -1 disables buffering
+	var data struct {
+		OutputBufferSize    int    `json:"output_buffer_size"`
+		OutputBufferTimeout int    `json:"output_buffer_timeout"` // ms
+		UserAgent           string `json:"user_agent"`
+		MessageAttributes   bool   `json:"message_attributes"`
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return nil, nsq.NewClientErr("E_BAD_BODY", fmt.Sprintf("could not parse IDENTIFY body - %s", err.Error()))
+	}
+
+	size := int(p.nsqd.options.OutputBufferSize)
+	switch {
+	case data.OutputBufferSize == -1:
+		size = 0
+	case data.OutputBufferSize == 0:
+	case data.OutputBufferSize < minOutputBufferSize || int64(data.OutputBufferSize) > p.nsqd.options.MaxOutputBufferSize:
+		return nil, nsq.NewClientErr("E_BAD_BODY", fmt.Sprintf("output_buffer_size %d must be between %d and %d",
+			data.OutputBufferSize, minOutputBufferSize, p.nsqd.options.MaxOutputBufferSize))
+	default:
+		size = data.OutputBufferSize
+	}
+
+	timeout := p.nsqd.options.OutputBufferTimeout
+	maxTimeoutMs := int(p.nsqd.options.MaxOutputBufferTimeout / time.Millisecond)
+	switch {
+	case data.OutputBufferTimeout == -1:
+		timeout = 0
+	case data.OutputBufferTimeout == 0:
+	case data.OutputBufferTimeout < 1 || data.OutputBufferTimeout > maxTimeoutMs:
+		return nil, nsq.NewClientErr("E_BAD_BODY", fmt.Sprintf("output_buffer_timeout %d must be between 1 and %d",
+			data.OutputBufferTimeout, maxTimeoutMs))
+	default:
+		timeout = time.Duration(data.OutputBufferTimeout) * time.Millisecond
+	}
+
+	if len(data.UserAgent) > maxUserAgentLength {
+		return nil, nsq.NewClientErr("E_BAD_BODY", fmt.Sprintf("user_agent longer than %d", maxUserAgentLength))
+	}
+
+	err = client.SetOutputBuffer(size, timeout)
+	if err != nil {
+		return nil, nsq.NewClientErr("E_INVALID", err.Error())
+	}
+	client.UserAgent = data.UserAgent
+	client.MessageAttributes = data.MessageAttributes
+
+	return []byte("OK"), nil
+}
+
+func (p *ProtocolV2) PUB(client *ClientV2, params [][]byte) ([]byte, error) {
+	var err error
+
+	if len(params) < 2 {
+		return nil, nsq.NewClientErr("E_MISSING_PARAMS", "insufficient number of parameters")
+	}
+
+	topicName := string(params[1])
+	if !nsq.IsValidTopicName(topicName) {
+		return nil, nsq.NewClientErr("E_BAD_TOPIC", fmt.Sprintf("topic name '%s' is not valid", topicName))
+	}
+
+	// the optional params are the attributes (prefixed with their name)
+	// and a partition key
+	var key []byte
+	var replyTo, correlationId string
+	for _, param := range params[2:] {
+		switch {
+		case bytes.HasPrefix(param, replyToParam):
+			replyTo = string(param[len(replyToParam):])
+			if !nsq.IsValidTopicName(replyTo) {
+				return nil, nsq.NewClientErr("E_BAD_ATTRIBUTE", fmt.Sprintf("reply_to '%s' is not a valid topic name", replyTo))
+			}
+		case bytes.HasPrefix(param, correlationIdParam):
+			correlationId = string(param[len(correlationIdParam):])
+			if len(correlationId) > nsq.MaxAttributeLength {
+				return nil, nsq.NewClientErr("E_BAD_ATTRIBUTE", fmt.Sprintf("correlation_id length %d exceeds %d",
+					len(correlationId), nsq.MaxAttributeLength))
+			}
+		case key != nil:
+			return nil, nsq.NewClientErr("E_INVALID", "only one key may be given")
+		default:
+			if len(param) > maxKeyLength {
+				return nil, nsq.NewClientErr("E_BAD_KEY", fmt.Sprintf("key length %d exceeds %d", len(param), maxKeyLength))
+			}
+			// params point into the read buffer
+			key = append([]byte(nil), param...)
+		}
+	}
+
+	var bodyLen int32
+	err = binary.Read(client.Reader, binary.BigEndian, &bodyLen)
+	if err != nil {
+		return nil, nsq.NewClientErr("E_BAD_BODY", err.Error())
+	}
+
+	messageBody := make([]byte, bodyLen)
+	_, err = io.ReadFull(client.Reader, messageBody)
+	if err != nil {
+		return nil, nsq.NewClientErr("E_BAD_BODY", err.Error())
+	}
+
+	if p.nsqd.IsDiskFull() {
+		return nil, nsq.NewClientErr("E_DISK_FULL", "nsqd is low on disk space")
+	}
+
+	if p.nsqd.HasWorkerIdConflict() {
+		return nil, nsq.NewClientErr("E_WORKER_ID_CONFLICT", fmt.Sprintf("worker id %d is in use by another nsqd", p.nsqd.workerId))
+	}
+
+	topic := p.nsqd.GetPublishTopic(topicName)
+	if topic == nil {
+		// nobody is subscribed to this ephemeral topic
+		return []byte("OK"), nil
+	}
+	msg := nsq.NewMessage(<-p.nsqd.idChan, messageBody)
+	msg.Key = key
+	msg.ReplyTo = replyTo
+	msg.CorrelationId = correlationId
+	err = topic.PutMessage(msg)
+	if err == ErrTopicFull {
+		return nil, nsq.NewClientErr("E_TOPIC_FULL", fmt.Sprintf("topic '%s' is at its max depth", topicName))
+	}
+	if err != nil {
+		return nil, nsq.NewClientErr("E_PUT_FAILED", err.Error())
+	}
+
+	return []byte("OK"), nil
+}
@@ -0,0 +1,126 @@
+package nsqd
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the relative error of the quantiles estimated by a durationSketch
+const sketchAccuracy = 0.01
+
+var sketchGamma = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+var sketchLogGamma = math.Log(sketchGamma)
+
+// durationSketch counts durations in logarithmic buckets (each sketchGamma
+// times wider than the last) so that any quantile can be estimated to within
+// sketchAccuracy of the real value, in memory proportional to the spread of
+// the durations rather than their number (see DDSketch)
+type durationSketch struct {
+	counts map[int]uint64
+	count  uint64
+}
+
+func newDurationSketch() *durationSketch {
+	return &durationSketch{counts: make(map[int]uint64)}
+}
+
+func (s *durationSketch) insert(d time.Duration) {
+	if d < 1 {
+		d = 1
+	}
+	s.counts[int(math.Ceil(math.Log(float64(d))/sketchLogGamma))]++
+	s.count++
+}
+
+// quantiles returns the estimated value of each quantile (0 < q <= 1) of the
+// durations in all of the sketches
+func quantiles(sketches []*durationSketch, qs []float64) []time.Duration {
+	var count uint64
+	counts := make(map[int]uint64)
+	for _, s := range sketches {
+		for bucket, n := range s.counts {
+			counts[bucket] += n
+		}
+		count += s.count
+	}
+
+	buckets := make([]int, 0, len(counts))
+	for bucket := range counts {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+
+	values := make([]time.Duration, len(qs))
+	if count == 0 {
+		return values
+	}
+	for i, q := range qs {
+		rank := uint64(q * float64(count-1))
+		var seen uint64
+		for _, bucket := range buckets {
+			seen += counts[bucket]
+			if seen > rank {
+				// the middle of the bucket (relative to its bounds)
+				values[i] = time.Duration(2 * math.Pow(sketchGamma, float64(bucket)) / (sketchGamma + 1))
+				break
+			}
+		}
+	}
+	return values
+}
+
+// Quantile estimates quantiles of the durations inserted over (roughly) the
+// last window, the durations are kept in two sketches each covering half of
+// the window so a result covers between half of it and all of it
+type Quantile struct {
+	sync.Mutex
+	window   time.Duration
+	current  *durationSketch
+	previous *durationSketch
+	rotated  time.Time
+}
+
+func NewQuantile(window time.Duration) *Quantile {
+	return &Quantile{
+		window:   window,
+		current:  newDurationSketch(),
+		previous: newDurationSketch(),
+		rotated:  time.Now(),
+	}
+}
+
+func (q *Quantile) Insert(d time.Duration) {
+	q.Lock()
+	q.rotate(time.Now())
+	q.current.insert(d)
+	q.Unlock()
+}
+
+// Result returns the number of durations in the window and the
+// estimated value of each quantile
+func (q *Quantile) Result(qs []float64) (uint64, []time.Duration) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.rotate(time.Now())
+	sketches := []*durationSketch{q.previous, q.current}
+	return q.previous.count + q.current.count, quantiles(sketches, qs)
+}
+
+// rotate drops the durations older than the window, this expects the
+// caller to handle locking
+func (q *Quantile) rotate(now time.Time) {
+	elapsed := now.Sub(q.rotated)
+	switch {
+	case elapsed >= q.window:
+		q.previous = newDurationSketch()
+		q.current = newDurationSketch()
+		q.rotated = now
+	case elapsed >= q.window/2:
+		q.previous = q.current
+		q.current = newDurationSketch()
+		q.rotated = q.rotated.Add(q.window / 2)
+	}
+}
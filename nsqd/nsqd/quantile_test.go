@@ -0,0 +1,49 @@
+package nsqd
+
+import (
+	"github.com/bmizerany/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestQuantile(t *testing.T) {
+	q := NewQuantile(time.Minute)
+	count, values := q.Result([]float64{0.5})
+	assert.Equal(t, count, uint64(0))
+	assert.Equal(t, values[0], time.Duration(0))
+
+	for i := 1; i <= 10000; i++ {
+		q.Insert(time.Duration(i) * time.Millisecond)
+	}
+
+	percentiles := []float64{0.5, 0.95, 0.99, 1}
+	count, values = q.Result(percentiles)
+	assert.Equal(t, count, uint64(10000))
+	for i, percentile := range percentiles {
+		expected := percentile * 10000 * float64(time.Millisecond)
+		if math.Abs(float64(values[i])-expected) > expected*sketchAccuracy {
+			t.Fatalf("p%v is %s, expected %s", percentile*100, values[i], time.Duration(expected))
+		}
+	}
+}
+
+func TestQuantileWindow(t *testing.T) {
+	q := NewQuantile(time.Minute)
+	q.Insert(time.Second)
+
+	// after half of the window the durations are still included
+	q.rotated = q.rotated.Add(-30 * time.Second)
+	q.Insert(time.Second)
+	count, _ := q.Result([]float64{0.5})
+	assert.Equal(t, count, uint64(2))
+
+	// then they're dropped half a window at a time
+	q.rotated = q.rotated.Add(-30 * time.Second)
+	count, _ = q.Result([]float64{0.5})
+	assert.Equal(t, count, uint64(1))
+
+	q.rotated = q.rotated.Add(-time.Minute)
+	count, _ = q.Result([]float64{0.5})
+	assert.Equal(t, count, uint64(0))
+}
@@ -0,0 +1,298 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// keyed messages are written to the backend prefixed with this marker, a
+// 2-byte key length, and the key (the first byte of an un-keyed message is
+// the high byte of its timestamp so it can never be the marker)
+const keyedMessageMarker = 0xff
+
+// messages with attributes (see nsq.Message.EncodeWithAttributes) are written
+// to the backend prefixed with this marker (after any key)
+const attributesMessageMarker = 0xfe
+
+// the maximum length of a message partition key
+const maxKeyLength = 255
+
+// BackendQueue represents the behavior for the secondary message
+// storage system
+type BackendQueue interface {
+	Put([]byte) error
+	ReadChan() chan []byte // this is expected to be an *unbuffered* channel
+	Close() error
+	Depth() int64
+	DepthBytes() int64
+	Peek() []byte // the data ReadChan() will send next (nil if it hasn't been read yet)
+	Err() error   // a *BackendError if the most recent write or read failed
+	Empty() error
+	Sync() error
+}
+
+// BackendError is the error of a failed BackendQueue write or read (a
+// read that fails means the queue is corrupt)
+type BackendError struct {
+	Op  string // "write" or "read"
+	Err error
+}
+
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("%s failed - %s", e.Op, e.Err.Error())
+}
+
+type Queue interface {
+	MemoryChan() chan *nsq.Message
+	BackendQueue() BackendQueue
+	InFlight() map[string]*Timeout
+	Deferred() map[string]*Timeout
+	MemoryDequeued(msg *nsq.Message) // called for every message read from MemoryChan()
+}
+
+// depthLimit is the maximum depth of a queue in messages and bytes
+// (0 means unlimited)
+type depthLimit struct {
+	maxDepth int64
+	maxBytes int64
+}
+
+func (l depthLimit) exceeded(depth int64, depthBytes int64) bool {
+	return (l.maxDepth > 0 && depth >= l.maxDepth) || (l.maxBytes > 0 && depthBytes >= l.maxBytes)
+}
+
+// messageSize returns the number of bytes a message occupies when encoded
+func messageSize(msg *nsq.Message) int64 {
+	// id + timestamp + attempts + body
+	return int64(nsq.MsgIdLength + 8 + 2 + len(msg.Body) + len(msg.Key) +
+		len(msg.ReplyTo) + len(msg.CorrelationId))
+}
+
+// messageTimestampNano returns when a message was published in nanoseconds
+// (to the second for a message decoded from the backend)
+func messageTimestampNano(msg *nsq.Message) int64 {
+	if msg.TimestampNano > 0 {
+		return msg.TimestampNano
+	}
+	return msg.Timestamp * int64(time.Second)
+}
+
+// DropOldest discards the message at the head of the queue (memory first,
+// then backend) returning false if there was nothing immediately available
+//
+// the discarded message is returned (nil if it couldn't be decoded)
+func DropOldest(q Queue) (*nsq.Message, bool) {
+	select {
+	case msg := <-q.MemoryChan():
+		q.MemoryDequeued(msg)
+		return msg, true
+	default:
+	}
+
+	select {
+	case data := <-q.BackendQueue().ReadChan():
+		msg, err := decodeBackendMessage(data)
+		if err != nil {
+			return nil, true
+		}
+		return msg, true
+	default:
+	}
+
+	return nil, false
+}
+
+func EmptyQueue(q Queue) error {
+	for {
+		select {
+		case msg := <-q.MemoryChan():
+			q.MemoryDequeued(msg)
+		default:
+			goto disk
+		}
+	}
+
+disk:
+	return q.BackendQueue().Empty()
+}
+
+func FlushQueue(q Queue) error {
+	var msgBuf bytes.Buffer
+
+	for {
+		select {
+		case msg := <-q.MemoryChan():
+			q.MemoryDequeued(msg)
+			err := WriteMessageToBackend(&msgBuf, msg, q)
+			if err != nil {
+				log.Printf("ERROR: failed to write message to backend - %s", err.Error())
+			}
+		default:
+			goto finish
+		}
+	}
+
+finish:
+	for _, item := range q.InFlight() {
+		msg := item.Value.(*inFlightMessage).msg
+		err := WriteMessageToBackend(&msgBuf, msg, q)
+		if err != nil {
+			log.Printf("ERROR: failed to write message to backend - %s", err.Error())
+		}
+	}
+
+	for _, item := range q.Deferred() {
+		msg := item.Value.(*nsq.Message)
+		err := WriteMessageToBackend(&msgBuf, msg, q)
+		if err != nil {
+			log.Printf("ERROR: failed to write message to backend - %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+func WriteMessageToBackend(buf *bytes.Buffer, msg *nsq.Message, q Queue) error {
+	buf.Reset()
+	err := encodeBackendMessage(buf, msg)
+	if err != nil {
+		return err
+	}
+	err = q.BackendQueue().Put(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// encodeBackendMessage serializes a message (including its key and
+// attributes) for storage
+func encodeBackendMessage(buf *bytes.Buffer, msg *nsq.Message) error {
+	if len(msg.Key) > 0 {
+		buf.WriteByte(keyedMessageMarker)
+		err := binary.Write(buf, binary.BigEndian, uint16(len(msg.Key)))
+		if err != nil {
+			return err
+		}
+		buf.Write(msg.Key)
+	}
+	if msg.HasAttributes() {
+		buf.WriteByte(attributesMessageMarker)
+		return msg.EncodeWithAttributes(buf)
+	}
+	return msg.Encode(buf)
+}
+
+// decodeBackendMessage is the inverse of encodeBackendMessage
+func decodeBackendMessage(data []byte) (*nsq.Message, error) {
+	var key []byte
+	if len(data) > 0 && data[0] == keyedMessageMarker {
+		if len(data) < 3 {
+			return nil, errors.New("invalid keyed message")
+		}
+		keyLen := int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) < 3+keyLen {
+			return nil, errors.New("invalid keyed message")
+		}
+		key = data[3 : 3+keyLen]
+		data = data[3+keyLen:]
+	}
+
+	var msg *nsq.Message
+	var err error
+	if len(data) > 0 && data[0] == attributesMessageMarker {
+		msg, err = nsq.DecodeMessageWithAttributes(data[1:])
+	} else {
+		msg, err = nsq.DecodeMessage(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	msg.Key = key
+	return msg, nil
+}
+
+// backendMessageTimestamp returns the timestamp of a message encoded for the
+// backend without decoding it
+func backendMessageTimestamp(data []byte) (int64, error) {
+	if len(data) > 0 && data[0] == keyedMessageMarker {
+		if len(data) < 3 {
+			return 0, errors.New("invalid keyed message")
+		}
+		keyLen := int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) < 3+keyLen {
+			return 0, errors.New("invalid keyed message")
+		}
+		data = data[3+keyLen:]
+	}
+	if len(data) > 0 && data[0] == attributesMessageMarker {
+		data = data[1:]
+	}
+	if len(data) < 8 {
+		return 0, errors.New("invalid message")
+	}
+	return int64(binary.BigEndian.Uint64(data[:8])), nil
+}
+
+// queueAges tracks the timestamp of the oldest message in a FIFO (ie. a
+// channel's memoryMsgChan) as messages are enqueued and dequeued
+//
+// only the messages older than every message enqueued after them are kept
+// (the rest can never be the oldest) so the oldest is always the first
+type queueAges struct {
+	sync.Mutex
+	oldest   []queuedAge
+	enqueued uint64
+	dequeued uint64
+}
+
+type queuedAge struct {
+	seq       uint64
+	timestamp int64
+}
+
+// Enqueued records the timestamp of the message most recently added to the
+// FIFO, it may be called after the message has already been dequeued
+func (q *queueAges) Enqueued(timestamp int64) {
+	q.Lock()
+	defer q.Unlock()
+
+	seq := q.enqueued
+	q.enqueued++
+	if seq < q.dequeued {
+		return
+	}
+	for len(q.oldest) > 0 && q.oldest[len(q.oldest)-1].timestamp >= timestamp {
+		q.oldest = q.oldest[:len(q.oldest)-1]
+	}
+	q.oldest = append(q.oldest, queuedAge{seq, timestamp})
+}
+
+// Dequeued records that the message at the head of the FIFO was removed
+func (q *queueAges) Dequeued() {
+	q.Lock()
+	defer q.Unlock()
+
+	q.dequeued++
+	for len(q.oldest) > 0 && q.oldest[0].seq < q.dequeued {
+		q.oldest = q.oldest[1:]
+	}
+}
+
+// Oldest returns the timestamp of the oldest message in the FIFO (0 when
+// it is empty)
+func (q *queueAges) Oldest() int64 {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.oldest) == 0 {
+		return 0
+	}
+	return q.oldest[0].timestamp
+}
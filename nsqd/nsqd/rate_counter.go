@@ -0,0 +1,72 @@
+package nsqd
+
+import (
+	"sync"
+	"time"
+)
+
+// the window over which rates (ie. a client's finishes per second) are measured
+const rateWindow = 60
+
+// rateCounter counts events in one second buckets over the last rateWindow
+// seconds to give their recent rate
+type rateCounter struct {
+	sync.Mutex
+	buckets [rateWindow]uint64
+	start   int64 // the unix second the counter was created
+	last    int64 // the unix second of the most recent bucket
+}
+
+func newRateCounter() *rateCounter {
+	now := time.Now().Unix()
+	return &rateCounter{start: now, last: now}
+}
+
+func (r *rateCounter) Incr() {
+	r.add(time.Now().Unix(), 1)
+}
+
+// Rate returns the number of events per second over the window (or the
+// time since the counter was created, if shorter)
+func (r *rateCounter) Rate() float64 {
+	return r.rate(time.Now().Unix())
+}
+
+func (r *rateCounter) add(now int64, n uint64) {
+	r.Lock()
+	r.advance(now)
+	r.buckets[now%rateWindow] += n
+	r.Unlock()
+}
+
+func (r *rateCounter) rate(now int64) float64 {
+	r.Lock()
+	defer r.Unlock()
+
+	r.advance(now)
+	var total uint64
+	for _, count := range r.buckets {
+		total += count
+	}
+	seconds := now - r.start + 1
+	if seconds > rateWindow {
+		seconds = rateWindow
+	}
+	return float64(total) / float64(seconds)
+}
+
+// advance clears the buckets of the seconds that have passed since the last
+// one, this expects the caller to handle locking
+func (r *rateCounter) advance(now int64) {
+	if now <= r.last {
+		return
+	}
+	passed := now - r.last
+	if passed > rateWindow {
+		passed = rateWindow
+	}
+	for i := int64(1); i <= passed; i++ {
+		r.buckets[(r.last+i)%rateWindow] = 0
+	}
+	r.last = now
+}
@@ -0,0 +1,24 @@
+package nsqd
+
+import (
+	"github.com/bmizerany/assert"
+	"testing"
+)
+
+func TestRateCounter(t *testing.T) {
+	r := &rateCounter{start: 1000, last: 1000}
+	assert.Equal(t, r.rate(1000), 0.0)
+
+	r.add(1000, 10)
+	r.add(1001, 10)
+	assert.Equal(t, r.rate(1001), 10.0)
+
+	// the rate is measured over the window once the counter is older than it
+	r.add(1000+rateWindow-1, 40)
+	assert.Equal(t, r.rate(1000+rateWindow-1), 1.0)
+
+	// buckets older than the window no longer count
+	assert.Equal(t, r.rate(1000+rateWindow), 50.0/rateWindow)
+	assert.Equal(t, r.rate(1000+rateWindow+1), 40.0/rateWindow)
+	assert.Equal(t, r.rate(1000+3*rateWindow), 0.0)
+}
@@ -0,0 +1,355 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how often (at most) Append will look for expired segments
+const retentionPruneInterval = 10 * time.Second
+
+// RetentionLog is an append-only, segmented, on-disk log of every message
+// published to a topic.
+//
+// Segments are rolled once they exceed maxBytesPerFile and are removed once
+// they were last written more than `window` ago, which bounds how far back
+// a channel can be rewound.
+type RetentionLog struct {
+	sync.Mutex
+
+	name            string
+	dataPath        string
+	window          time.Duration
+	maxBytesPerFile int64
+	syncEvery       int64
+
+	segments   []int64 // file numbers, oldest first (the last one is being written)
+	writePos   int64
+	writeCount int64
+	writeFile  *os.File
+	writeBuf   bytes.Buffer
+	msgBuf     bytes.Buffer
+	lastPrune  time.Time
+	exitFlag   int32
+}
+
+// NewRetentionLog opens (or creates) the retention log for the given name,
+// picking up any segments left over from a previous run
+func NewRetentionLog(name string, dataPath string, window time.Duration, maxBytesPerFile int64, syncEvery int64) *RetentionLog {
+	r := &RetentionLog{
+		name:            name,
+		dataPath:        dataPath,
+		window:          window,
+		maxBytesPerFile: maxBytesPerFile,
+		syncEvery:       syncEvery,
+	}
+
+	err := r.loadSegments()
+	if err != nil {
+		log.Printf("ERROR: retention(%s) failed to load segments - %s", r.name, err.Error())
+	}
+	r.prune(time.Now())
+
+	return r
+}
+
+// Append writes a message to the end of the log
+func (r *RetentionLog) Append(msg *nsq.Message) error {
+	var err error
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.exitFlag == 1 {
+		return errors.New("exiting")
+	}
+
+	if r.writeFile == nil {
+		curFileName := r.fileName(r.segments[len(r.segments)-1])
+		r.writeFile, err = os.OpenFile(curFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.msgBuf.Reset()
+	err = encodeBackendMessage(&r.msgBuf, msg)
+	if err != nil {
+		return err
+	}
+
+	r.writeBuf.Reset()
+	err = binary.Write(&r.writeBuf, binary.BigEndian, int32(r.msgBuf.Len()))
+	if err != nil {
+		return err
+	}
+	r.writeBuf.Write(r.msgBuf.Bytes())
+
+	// only write to the file once
+	_, err = r.writeFile.Write(r.writeBuf.Bytes())
+	if err != nil {
+		r.writeFile.Close()
+		r.writeFile = nil
+		return err
+	}
+	r.writePos += int64(r.writeBuf.Len())
+
+	r.writeCount++
+	if r.writeCount >= r.syncEvery {
+		r.writeCount = 0
+		err = r.writeFile.Sync()
+		if err != nil {
+			log.Printf("ERROR: retention(%s) failed to sync - %s", r.name, err.Error())
+		}
+	}
+
+	if r.writePos > r.maxBytesPerFile {
+		// sync every time we start writing to a new segment
+		err = r.writeFile.Sync()
+		if err != nil {
+			log.Printf("ERROR: retention(%s) failed to sync - %s", r.name, err.Error())
+		}
+		r.writeFile.Close()
+		r.writeFile = nil
+		r.segments = append(r.segments, r.segments[len(r.segments)-1]+1)
+		r.writePos = 0
+	}
+
+	now := time.Now()
+	if now.Sub(r.lastPrune) > retentionPruneInterval {
+		r.prune(now)
+	}
+
+	return nil
+}
+
+// Replay reads every retained message published at or after `since`
+// (a unix timestamp) in the order they were appended and passes a fresh
+// copy of each to the callback, returning the number of messages replayed.
+//
+// Only the segments present when Replay is called are read (up to the
+// current write position) so that concurrent publishes are not blocked.
+func (r *RetentionLog) Replay(since int64, callback func(*nsq.Message) error) (int, error) {
+	r.Lock()
+	if r.exitFlag == 1 {
+		r.Unlock()
+		return 0, errors.New("exiting")
+	}
+	r.prune(time.Now())
+	segments := make([]int64, len(r.segments))
+	copy(segments, r.segments)
+	endPos := r.writePos
+	r.Unlock()
+
+	count := 0
+	sinceTime := time.Unix(since, 0)
+	for i, num := range segments {
+		limit := int64(-1)
+		if i == len(segments)-1 {
+			limit = endPos
+		}
+
+		fn := r.fileName(num)
+		if limit == -1 {
+			// every message in a segment is older than the last time it was written to
+			info, err := os.Stat(fn)
+			if err != nil {
+				if os.IsNotExist(err) {
+					// pruned out from under us
+					continue
+				}
+				return count, err
+			}
+			if info.ModTime().Before(sinceTime) {
+				continue
+			}
+		}
+
+		n, err := r.replaySegment(fn, limit, since, callback)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+func (r *RetentionLog) replaySegment(fileName string, limit int64, since int64, callback func(*nsq.Message) error) (int, error) {
+	var msgSize int32
+	var pos int64
+
+	f, err := os.OpenFile(fileName, os.O_RDONLY, 0600)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	count := 0
+	reader := bufio.NewReader(f)
+	for limit == -1 || pos < limit {
+		err = binary.Read(reader, binary.BigEndian, &msgSize)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+
+		buf := make([]byte, msgSize)
+		_, err = io.ReadFull(reader, buf)
+		if err != nil {
+			return count, err
+		}
+		pos += int64(4 + msgSize)
+
+		msg, err := decodeBackendMessage(buf)
+		if err != nil {
+			log.Printf("ERROR: retention(%s) failed to decode message - %s", r.name, err.Error())
+			continue
+		}
+
+		if msg.Timestamp < since {
+			continue
+		}
+
+		msg.Attempts = 0
+		err = callback(msg)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// Close syncs and closes the active segment
+func (r *RetentionLog) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.exitFlag == 1 {
+		return errors.New("exiting")
+	}
+	r.exitFlag = 1
+
+	log.Printf("RETENTION(%s): closing", r.name)
+
+	if r.writeFile != nil {
+		err := r.writeFile.Sync()
+		r.writeFile.Close()
+		r.writeFile = nil
+		return err
+	}
+
+	return nil
+}
+
+// Delete closes the log and removes all of its segments
+func (r *RetentionLog) Delete() error {
+	r.Close()
+
+	r.Lock()
+	defer r.Unlock()
+
+	log.Printf("RETENTION(%s): deleting", r.name)
+
+	for _, num := range r.segments {
+		err := os.Remove(r.fileName(num))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	r.segments = nil
+
+	return nil
+}
+
+// prune removes segments (other than the one being written to) that have
+// aged out of the retention window
+//
+// this expects the caller to handle locking
+func (r *RetentionLog) prune(now time.Time) {
+	r.lastPrune = now
+	for len(r.segments) > 1 {
+		fn := r.fileName(r.segments[0])
+		info, err := os.Stat(fn)
+		if err == nil && now.Sub(info.ModTime()) <= r.window {
+			break
+		}
+		if err == nil {
+			log.Printf("RETENTION(%s): removing expired segment %s", r.name, fn)
+			err = os.Remove(fn)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("ERROR: retention(%s) failed to remove %s - %s", r.name, fn, err.Error())
+			break
+		}
+		r.segments = r.segments[1:]
+	}
+}
+
+// loadSegments discovers existing segments on disk
+func (r *RetentionLog) loadSegments() error {
+	prefix := r.name + ".retention."
+	fileNames, err := filepath.Glob(path.Join(r.dataPath, prefix+"*.dat"))
+	if err != nil {
+		return err
+	}
+
+	r.segments = make([]int64, 0, len(fileNames))
+	for _, fn := range fileNames {
+		// topic names can include '.' so be strict about what matches
+		numStr := strings.TrimSuffix(strings.TrimPrefix(path.Base(fn), prefix), ".dat")
+		if len(numStr) != 6 {
+			continue
+		}
+		num, err := strconv.ParseInt(numStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		r.segments = append(r.segments, num)
+	}
+	sort.Sort(int64Slice(r.segments))
+
+	if len(r.segments) == 0 {
+		r.segments = append(r.segments, 0)
+		return nil
+	}
+
+	info, err := os.Stat(r.fileName(r.segments[len(r.segments)-1]))
+	if err != nil {
+		return err
+	}
+	r.writePos = info.Size()
+
+	return nil
+}
+
+func (r *RetentionLog) fileName(num int64) string {
+	return fmt.Sprintf(path.Join(r.dataPath, "%s.retention.%06d.dat"), r.name, num)
+}
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
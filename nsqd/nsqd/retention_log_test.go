@@ -0,0 +1,91 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetentionLogReplay(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	name := "test_retention" + strconv.Itoa(int(time.Now().Unix()))
+	r := NewRetentionLog(name, os.TempDir(), time.Hour, 100, 2500)
+	defer r.Delete()
+
+	now := time.Now().Unix()
+	for i := 0; i < 10; i++ {
+		msg := nsq.NewMessage([]byte("0123456789abcdef"), []byte(strconv.Itoa(i)))
+		msg.Timestamp = now - int64(10-i)
+		err := r.Append(msg)
+		assert.Equal(t, err, nil)
+	}
+	assert.Equal(t, len(r.segments) > 1, true)
+
+	bodies := make([]string, 0)
+	count, err := r.Replay(0, func(msg *nsq.Message) error {
+		bodies = append(bodies, string(msg.Body))
+		return nil
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, count, 10)
+	assert.Equal(t, bodies[0], "0")
+	assert.Equal(t, bodies[9], "9")
+
+	count, err = r.Replay(now-3, func(msg *nsq.Message) error { return nil })
+	assert.Equal(t, err, nil)
+	assert.Equal(t, count, 3)
+}
+
+func TestRetentionLogReopen(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	name := "test_retention_reopen" + strconv.Itoa(int(time.Now().Unix()))
+	r := NewRetentionLog(name, os.TempDir(), time.Hour, 100, 2500)
+	for i := 0; i < 5; i++ {
+		err := r.Append(nsq.NewMessage([]byte("0123456789abcdef"), []byte("test")))
+		assert.Equal(t, err, nil)
+	}
+	r.Close()
+
+	r = NewRetentionLog(name, os.TempDir(), time.Hour, 100, 2500)
+	defer r.Delete()
+	err := r.Append(nsq.NewMessage([]byte("0123456789abcdef"), []byte("test")))
+	assert.Equal(t, err, nil)
+
+	count, err := r.Replay(0, func(msg *nsq.Message) error { return nil })
+	assert.Equal(t, err, nil)
+	assert.Equal(t, count, 6)
+}
+
+func TestRetentionLogPrune(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	name := "test_retention_prune" + strconv.Itoa(int(time.Now().Unix()))
+	r := NewRetentionLog(name, os.TempDir(), time.Hour, 10, 2500)
+	defer r.Delete()
+
+	for i := 0; i < 3; i++ {
+		err := r.Append(nsq.NewMessage([]byte("0123456789abcdef"), []byte("test")))
+		assert.Equal(t, err, nil)
+	}
+	assert.Equal(t, len(r.segments), 4)
+
+	// age the first two segments out of the window
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(r.fileName(r.segments[0]), old, old)
+	os.Chtimes(r.fileName(r.segments[1]), old, old)
+
+	count, err := r.Replay(0, func(msg *nsq.Message) error { return nil })
+	assert.Equal(t, err, nil)
+	assert.Equal(t, count, 1)
+	assert.Equal(t, len(r.segments), 2)
+}
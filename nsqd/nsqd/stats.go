@@ -0,0 +1,530 @@
+package nsqd
+
+import (
+	"../../util"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type ClientStats struct {
+	id            int64
+	version       string
+	address       string
+	name          string
+	state         int32
+	inFlightCount int64
+	readyCount    int64
+	messageCount  uint64
+	finishCount   uint64
+	requeueCount  uint64
+	connectTime   time.Time
+	drained       bool
+	weight        int
+	bytesWritten  uint64
+	flushCount    uint64
+	share         float64 // fraction of the messages sent to the channel's current clients
+	userAgent     string
+	finishRate    float64 // per second
+	requeueRate   float64 // per second
+}
+
+type Topics []*Topic
+type TopicsByName struct {
+	Topics
+}
+type Channels []*Channel
+type ChannelsByName struct {
+	Channels
+}
+
+func (t Topics) Len() int                   { return len(t) }
+func (t Topics) Swap(i, j int)              { t[i], t[j] = t[j], t[i] }
+func (c Channels) Len() int                 { return len(c) }
+func (c Channels) Swap(i, j int)            { c[i], c[j] = c[j], c[i] }
+func (t TopicsByName) Less(i, j int) bool   { return t.Topics[i].name < t.Topics[j].name }
+func (c ChannelsByName) Less(i, j int) bool { return c.Channels[i].name < c.Channels[j].name }
+
+type TopicStats struct {
+	TopicName    string         `json:"topic_name"`
+	Channels     []ChannelStats `json:"channels"`
+	Depth        int64          `json:"depth"`
+	BackendDepth int64          `json:"backend_depth"`
+	MessageCount uint64         `json:"message_count"`
+	DepthBytes   int64          `json:"depth_bytes"`
+	RejectCount  uint64         `json:"reject_count"`
+	DropCount    uint64         `json:"drop_count"`
+}
+
+type ChannelStats struct {
+	ChannelName   string        `json:"channel_name"`
+	Depth         int64         `json:"depth"`
+	BackendDepth  int64         `json:"backend_depth"`
+	InFlightCount int           `json:"in_flight_count"`
+	DeferredCount int           `json:"deferred_count"`
+	MessageCount  uint64        `json:"message_count"`
+	RequeueCount  uint64        `json:"requeue_count"`
+	TimeoutCount  uint64        `json:"timeout_count"`
+	DropCount     uint64        `json:"drop_count"`
+	DepthBytes    int64         `json:"depth_bytes"`
+	ClientCount   int           `json:"client_count"`
+	Clients       []ClientStats `json:"clients"` // empty unless clients were included
+	Paused        bool          `json:"paused"`
+	Ordered       bool          `json:"ordered"`
+	Mode          string        `json:"mode"`
+	Dispatch      string        `json:"dispatch"`
+	PendingCount  int64         `json:"pending_count"`
+
+	E2eProcessingLatency *LatencyStats `json:"e2e_processing_latency,omitempty"`
+	InFlightLatency      *LatencyStats `json:"in_flight_latency,omitempty"`
+
+	// how long ago the oldest messages in memory and on disk were published
+	// (ns, 0 when there are none) and how long the depth would take to drain
+	// at the recent finish rate (ns, -1 when nothing was finished recently)
+	OldestMemoryAge  int64   `json:"oldest_memory_age"`
+	OldestBackendAge int64   `json:"oldest_backend_age"`
+	FinishRate       float64 `json:"finish_rate"` // per second
+	DrainETA         int64   `json:"drain_eta"`
+}
+
+// drainETAString formats ChannelStats.DrainETA for the text stats
+func drainETAString(eta int64) string {
+	if eta < 0 {
+		return "unknown"
+	}
+	return time.Duration(eta).String()
+}
+
+// LatencyStats are the estimated percentiles of a latency over
+// Options.LatencyWindow
+type LatencyStats struct {
+	Count       uint64              `json:"count"`
+	Percentiles []LatencyPercentile `json:"percentiles"`
+}
+
+type LatencyPercentile struct {
+	Quantile float64 `json:"quantile"`
+	Value    int64   `json:"value"` // ns
+}
+
+func newLatencyStats(q *Quantile, percentiles []float64) *LatencyStats {
+	count, values := q.Result(percentiles)
+	stats := &LatencyStats{
+		Count:       count,
+		Percentiles: make([]LatencyPercentile, len(percentiles)),
+	}
+	for i, percentile := range percentiles {
+		stats.Percentiles[i] = LatencyPercentile{percentile, int64(values[i])}
+	}
+	return stats
+}
+
+func (s *LatencyStats) String() string {
+	parts := make([]string, len(s.Percentiles))
+	for i, percentile := range s.Percentiles {
+		parts[i] = fmt.Sprintf("p%v: %s", percentile.Quantile*100, time.Duration(percentile.Value))
+	}
+	return fmt.Sprintf("count: %-8d %s", s.Count, strings.Join(parts, " "))
+}
+
+// StatsTotals aggregates the stats of every topic and channel
+type StatsTotals struct {
+	TopicCount    int    `json:"topic_count"`
+	ChannelCount  int    `json:"channel_count"`
+	ClientCount   int    `json:"client_count"`
+	Depth         int64  `json:"depth"`
+	BackendDepth  int64  `json:"backend_depth"`
+	InFlightCount int    `json:"in_flight_count"`
+	DeferredCount int    `json:"deferred_count"`
+	MessageCount  uint64 `json:"message_count"`
+	DepthBytes    int64  `json:"depth_bytes"`
+}
+
+// print out stats for each topic/channel
+//
+// `topic` and `channel` only include the named topic and/or channel,
+// `include_clients=false` leaves out the stats of each client and
+// `aggregate=true` only returns the totals
+func (s *httpServer) statsHandler(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := util.NewReqParams(req)
+	if err != nil {
+		log.Printf("ERROR: failed to parse request params - %s", err.Error())
+		apiError(w, req, 400, "INVALID_REQUEST")
+		return
+	}
+
+	formatString, _ := reqParams.Query("format")
+	jsonFormat := formatString == "json"
+	topicName, _ := reqParams.Query("topic")
+	channelName, _ := reqParams.Query("channel")
+
+	includeClients := true
+	includeClientsStr, err := reqParams.Query("include_clients")
+	if err == nil {
+		includeClients, err = strconv.ParseBool(includeClientsStr)
+		if err != nil {
+			apiError(w, req, 400, "INVALID_ARG_INCLUDE_CLIENTS")
+			return
+		}
+	}
+
+	var aggregate bool
+	aggregateStr, err := reqParams.Query("aggregate")
+	if err == nil {
+		aggregate, err = strconv.ParseBool(aggregateStr)
+		if err != nil {
+			apiError(w, req, 400, "INVALID_ARG_AGGREGATE")
+			return
+		}
+	}
+
+	s.nsqd.RLock()
+	topicCount := len(s.nsqd.topicMap)
+	s.nsqd.RUnlock()
+
+	if !jsonFormat {
+		io.WriteString(w, fmt.Sprintf("nsqd v%s\n\n", util.BINARY_VERSION))
+		io.WriteString(w, fmt.Sprintf("memory: used: %d budget: %d\n", s.nsqd.memoryBudget.Used(), s.nsqd.memoryBudget.Max()))
+		for _, target := range s.nsqd.webhookTargets {
+			io.WriteString(w, fmt.Sprintf("webhook: %s queued: %d delivered: %d failed: %d dropped: %d\n",
+				target.url,
+				len(target.eventChan),
+				atomic.LoadUint64(&target.deliveredCount),
+				atomic.LoadUint64(&target.failedCount),
+				atomic.LoadUint64(&target.droppedCount)))
+		}
+		if s.nsqd.HasWorkerIdConflict() {
+			io.WriteString(w, fmt.Sprintf("WARNING: worker id %d is in use by another nsqd\n", s.nsqd.workerId))
+		}
+		if s.nsqd.options.DiskLowWatermark > 0 {
+			io.WriteString(w, fmt.Sprintf("disk: free: %d full: %t\n", atomic.LoadUint64(&s.nsqd.diskFreeBytes), s.nsqd.IsDiskFull()))
+		}
+	}
+
+	// the v1 API responds with (empty) stats rather than an error
+	if topicCount == 0 && !isV1Request(req) {
+		if jsonFormat {
+			util.ApiResponse(w, 500, "NO_TOPICS", nil)
+		} else {
+			io.WriteString(w, "NO_TOPICS\n")
+		}
+		return
+	}
+
+	topics := s.nsqd.getStats(topicName, channelName, includeClients && !aggregate)
+	totals := getStatsTotals(topics)
+	if aggregate {
+		topics = []TopicStats{}
+	}
+
+	if !jsonFormat {
+		io.WriteString(w, fmt.Sprintf("totals: topics: %d channels: %d clients: %d depth: %d be-depth: %d inflt: %d def: %d msgs: %d\n",
+			totals.TopicCount,
+			totals.ChannelCount,
+			totals.ClientCount,
+			totals.Depth,
+			totals.BackendDepth,
+			totals.InFlightCount,
+			totals.DeferredCount,
+			totals.MessageCount))
+		writeTextStats(w, topics, time.Now())
+		return
+	}
+
+	webhooks := make([]interface{}, len(s.nsqd.webhookTargets))
+	for i, target := range s.nsqd.webhookTargets {
+		webhooks[i] = struct {
+			URL            string `json:"url"`
+			QueuedCount    int    `json:"queued_count"`
+			DeliveredCount uint64 `json:"delivered_count"`
+			FailedCount    uint64 `json:"failed_count"`
+			DroppedCount   uint64 `json:"dropped_count"`
+		}{
+			target.url,
+			len(target.eventChan),
+			atomic.LoadUint64(&target.deliveredCount),
+			atomic.LoadUint64(&target.failedCount),
+			atomic.LoadUint64(&target.droppedCount),
+		}
+	}
+	util.ApiResponse(w, 200, "OK", struct {
+		Topics           []TopicStats  `json:"topics"`
+		Totals           StatsTotals   `json:"totals"`
+		DiskFreeBytes    uint64        `json:"disk_free_bytes"`
+		DiskFull         bool          `json:"disk_full"`
+		MemoryBytes      int64         `json:"memory_bytes"`
+		MemoryBudget     int64         `json:"memory_budget"`
+		WorkerId         int64         `json:"worker_id"`
+		WorkerIdConflict bool          `json:"worker_id_conflict"`
+		Webhooks         []interface{} `json:"webhooks"`
+	}{topics, totals, atomic.LoadUint64(&s.nsqd.diskFreeBytes), s.nsqd.IsDiskFull(), s.nsqd.memoryBudget.Used(), s.nsqd.memoryBudget.Max(),
+		s.nsqd.workerId, s.nsqd.HasWorkerIdConflict(), webhooks})
+}
+
+func writeTextStats(w io.Writer, topics []TopicStats, now time.Time) {
+	for _, t := range topics {
+		io.WriteString(w, fmt.Sprintf("\n[%-15s] depth: %-5d be-depth: %-5d msgs: %-8d rejected: %-5d dropped: %-5d\n",
+			t.TopicName,
+			t.Depth,
+			t.BackendDepth,
+			t.MessageCount,
+			t.RejectCount,
+			t.DropCount))
+		for _, c := range t.Channels {
+			var pausedPrefix string
+			if c.Paused {
+				pausedPrefix = " *P "
+			} else {
+				pausedPrefix = "    "
+			}
+			io.WriteString(w,
+				fmt.Sprintf("%s[%-25s] depth: %-5d be-depth: %-5d inflt: %-4d def: %-4d re-q: %-5d timeout: %-5d msgs: %-8d dropped: %-5d clients: %d\n",
+					pausedPrefix,
+					c.ChannelName,
+					c.Depth,
+					c.BackendDepth,
+					c.InFlightCount,
+					c.DeferredCount,
+					c.RequeueCount,
+					c.TimeoutCount,
+					c.MessageCount,
+					c.DropCount,
+					c.ClientCount))
+			if c.E2eProcessingLatency != nil {
+				io.WriteString(w, fmt.Sprintf("        e2e latency: %s\n", c.E2eProcessingLatency))
+				io.WriteString(w, fmt.Sprintf("        in-flight latency: %s\n", c.InFlightLatency))
+			}
+			if c.Depth > 0 {
+				io.WriteString(w, fmt.Sprintf("        oldest: mem: %s disk: %s fin/s: %.2f drain eta: %s\n",
+					time.Duration(c.OldestMemoryAge),
+					time.Duration(c.OldestBackendAge),
+					c.FinishRate,
+					drainETAString(c.DrainETA)))
+			}
+			for _, clientStats := range c.Clients {
+				duration := now.Sub(clientStats.connectTime).Seconds()
+				_, port, _ := net.SplitHostPort(clientStats.address)
+				io.WriteString(w, fmt.Sprintf("        [%s %-21s] state: %d inflt: %-4d rdy: %-4d fin: %-8d re-q: %-8d msgs: %-8d share: %-4.2f bytes: %-10d flushes: %-8d fin/s: %-6.2f re-q/s: %-6.2f connected: %s\n",
+					clientStats.version,
+					fmt.Sprintf("%s:%s", clientStats.name, port),
+					clientStats.state,
+					clientStats.inFlightCount,
+					clientStats.readyCount,
+					clientStats.finishCount,
+					clientStats.requeueCount,
+					clientStats.messageCount,
+					clientStats.share,
+					clientStats.bytesWritten,
+					clientStats.flushCount,
+					clientStats.finishRate,
+					clientStats.requeueRate,
+					time.Duration(int64(duration))*time.Second, // truncate to the second
+				))
+			}
+		}
+	}
+}
+
+// getStats returns the stats of each topic (or only topicName) and its
+// channels (or only channelName), sorted by name
+//
+// the nsqd is only locked while the topics are listed, each topic and
+// channel is then locked in turn to collect its stats
+func (n *NSQd) getStats(topicName string, channelName string, includeClients bool) []TopicStats {
+	var realTopics []*Topic
+	n.RLock()
+	if topicName != "" {
+		if t, ok := n.topicMap[topicName]; ok {
+			realTopics = append(realTopics, t)
+		}
+	} else {
+		realTopics = make([]*Topic, 0, len(n.topicMap))
+		for _, t := range n.topicMap {
+			realTopics = append(realTopics, t)
+		}
+	}
+	n.RUnlock()
+	sort.Sort(TopicsByName{realTopics})
+
+	topics := make([]TopicStats, 0, len(realTopics))
+	for _, t := range realTopics {
+		t.RLock()
+		var realChannels []*Channel
+		if channelName != "" {
+			c, ok := t.channelMap[channelName]
+			if !ok {
+				t.RUnlock()
+				continue
+			}
+			realChannels = append(realChannels, c)
+		} else {
+			realChannels = make([]*Channel, 0, len(t.channelMap))
+			for _, c := range t.channelMap {
+				realChannels = append(realChannels, c)
+			}
+		}
+		sort.Sort(ChannelsByName{realChannels})
+
+		channels := make([]ChannelStats, len(realChannels))
+		for i, c := range realChannels {
+			c.RLock()
+			channels[i] = c.stats(includeClients)
+			c.RUnlock()
+		}
+
+		topics = append(topics, TopicStats{
+			TopicName:    t.name,
+			Channels:     channels,
+			Depth:        t.Depth(),
+			BackendDepth: t.backend.Depth(),
+			MessageCount: t.messageCount,
+			DepthBytes:   t.DepthBytes(),
+			RejectCount:  t.rejectCount,
+			DropCount:    t.dropCount,
+		})
+		t.RUnlock()
+	}
+	return topics
+}
+
+func getStatsTotals(topics []TopicStats) StatsTotals {
+	var totals StatsTotals
+	for _, t := range topics {
+		totals.TopicCount++
+		totals.Depth += t.Depth
+		totals.BackendDepth += t.BackendDepth
+		totals.MessageCount += t.MessageCount
+		totals.DepthBytes += t.DepthBytes
+		for _, c := range t.Channels {
+			totals.ChannelCount++
+			totals.ClientCount += c.ClientCount
+			totals.Depth += c.Depth
+			totals.BackendDepth += c.BackendDepth
+			totals.InFlightCount += c.InFlightCount
+			totals.DeferredCount += c.DeferredCount
+			totals.DepthBytes += c.DepthBytes
+		}
+	}
+	return totals
+}
+
+// stats returns the channel's stats (with those of each client if
+// includeClients is set)
+//
+// this expects the caller to handle locking
+func (c *Channel) stats(includeClients bool) ChannelStats {
+	clients := []ClientStats{}
+	if includeClients {
+		clients = c.clientStats()
+	}
+	var e2eLatency, inFlightLatency *LatencyStats
+	if c.e2eLatency != nil {
+		e2eLatency = newLatencyStats(c.e2eLatency, c.options.LatencyPercentiles)
+		inFlightLatency = newLatencyStats(c.inFlightLatency, c.options.LatencyPercentiles)
+	}
+	memoryAge, backendAge := c.OldestMessageAge()
+	return ChannelStats{
+		ChannelName:   c.name,
+		Depth:         c.Depth(),
+		BackendDepth:  c.backend.Depth(),
+		InFlightCount: len(c.inFlightMessages),
+		DeferredCount: len(c.deferredMessages),
+		MessageCount:  c.messageCount,
+		RequeueCount:  c.requeueCount,
+		TimeoutCount:  c.timeoutCount,
+		DropCount:     c.dropCount,
+		DepthBytes:    c.DepthBytes(),
+		ClientCount:   len(c.clients),
+		Clients:       clients,
+		Paused:        c.IsPaused(),
+		Ordered:       c.IsOrdered(),
+		Mode:          c.mode,
+		Dispatch:      c.dispatchPolicy,
+		PendingCount:  atomic.LoadInt64(&c.pendingCount),
+
+		E2eProcessingLatency: e2eLatency,
+		InFlightLatency:      inFlightLatency,
+
+		OldestMemoryAge:  int64(memoryAge),
+		OldestBackendAge: int64(backendAge),
+		FinishRate:       c.finishRate.Rate(),
+		DrainETA:         int64(c.DrainETA()),
+	}
+}
+
+// MarshalJSON encodes the stats as clientStatsJSON does
+func (s ClientStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(clientStatsJSON(s))
+}
+
+// clientStatsJSON returns the JSON representation of a client's stats
+func clientStatsJSON(clientStats ClientStats) interface{} {
+	return struct {
+		ID            int64   `json:"id"`
+		Version       string  `json:"version"`
+		RemoteAddress string  `json:"remote_address"`
+		Name          string  `json:"name"`
+		State         int32   `json:"state"`
+		ReadyCount    int64   `json:"ready_count"`
+		InFlightCount int64   `json:"in_flight_count"`
+		MessageCount  uint64  `json:"message_count"`
+		FinishCount   uint64  `json:"finish_count"`
+		RequeueCount  uint64  `json:"requeue_count"`
+		ConnectTime   int64   `json:"connect_ts"`
+		Drained       bool    `json:"drained"`
+		Weight        int     `json:"weight"`
+		Share         float64 `json:"share"`
+		BytesWritten  uint64  `json:"bytes_written"`
+		FlushCount    uint64  `json:"flush_count"`
+		UserAgent     string  `json:"user_agent"`
+		FinishRate    float64 `json:"finish_rate"`
+		RequeueRate   float64 `json:"requeue_rate"`
+	}{
+		clientStats.id,
+		clientStats.version,
+		clientStats.address,
+		clientStats.name,
+		clientStats.state,
+		clientStats.readyCount,
+		clientStats.inFlightCount,
+		clientStats.messageCount,
+		clientStats.finishCount,
+		clientStats.requeueCount,
+		clientStats.connectTime.Unix(),
+		clientStats.drained,
+		clientStats.weight,
+		clientStats.share,
+		clientStats.bytesWritten,
+		clientStats.flushCount,
+		clientStats.userAgent,
+		clientStats.finishRate,
+		clientStats.requeueRate,
+	}
+}
+
+// clientStats returns the stats of each client along with its share
+// of the messages sent to all of the channel's clients
+//
+// this expects the caller to handle locking
+func (c *Channel) clientStats() []ClientStats {
+	var total uint64
+	stats := make([]ClientStats, len(c.clients))
+	for i, client := range c.clients {
+		stats[i] = client.Stats()
+		total += stats[i].messageCount
+	}
+	if total > 0 {
+		for i := range stats {
+			stats[i].share = float64(stats[i].messageCount) / float64(total)
+		}
+	}
+	return stats
+}
@@ -1,8 +1,8 @@
-package main
+package nsqd
 
 import (
-	"../nsq"
-	"../util"
+	"../../nsq"
+	"../../util"
 	"log"
 	"net"
 )
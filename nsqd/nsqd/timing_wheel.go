@@ -0,0 +1,225 @@
+package nsqd
+
+import (
+	"../../util"
+	"log"
+	"sync"
+	"time"
+)
+
+// the resolution and size of the wheel shared by all channels (one
+// revolution is 5.12s, timeouts further away wait a number of rounds)
+const (
+	timingWheelTick    = 10 * time.Millisecond
+	timingWheelSlots   = 512
+	timingWheelWorkers = 4
+)
+
+// TimeoutHandler is notified when a Timeout expires, HandleTimeout is run
+// by the wheel's shared workers so it must not block
+type TimeoutHandler interface {
+	HandleTimeout(t *Timeout)
+}
+
+// Timeout is scheduled on a TimingWheel to notify its handler at (or shortly
+// after) its deadline
+type Timeout struct {
+	Value    interface{}
+	Deadline int64 // unix nano
+
+	handler   TimeoutHandler
+	slot      int
+	rounds    int64
+	prev      *Timeout // the slot's list is linked through the timeouts
+	next      *Timeout // themselves so that scheduling doesn't allocate
+	scheduled bool
+}
+
+func NewTimeout(value interface{}, deadline time.Time, handler TimeoutHandler) *Timeout {
+	return &Timeout{
+		Value:    value,
+		Deadline: deadline.UnixNano(),
+		handler:  handler,
+	}
+}
+
+// TimingWheel schedules the in-flight and deferred timeouts of every channel.
+//
+// It is a hashed wheel of slots, each a list of timeouts, advanced one slot
+// every tick. A timeout further away than one revolution counts down the
+// rounds it still has to wait each time its slot comes around. Adding and
+// removing a timeout are O(1) and a single goroutine wakes up to find those
+// that have expired, their handlers are run by a small pool of workers.
+type TimingWheel struct {
+	sync.Mutex
+
+	tick        time.Duration
+	slots       []*Timeout // the head of each slot's list
+	current     int        // the slot the next tick expires
+	count       int
+	expiredChan chan *Timeout
+	exitChan    chan int
+	waitGroup   util.WaitGroupWrapper
+}
+
+func NewTimingWheel(tick time.Duration, numSlots int, numWorkers int) *TimingWheel {
+	w := &TimingWheel{
+		tick:        tick,
+		slots:       make([]*Timeout, numSlots),
+		expiredChan: make(chan *Timeout, numSlots),
+		exitChan:    make(chan int),
+	}
+
+	w.waitGroup.Wrap(func() { w.ticker() })
+	for i := 0; i < numWorkers; i++ {
+		w.waitGroup.Wrap(func() { w.worker() })
+	}
+
+	return w
+}
+
+// Add schedules a timeout
+func (w *TimingWheel) Add(t *Timeout) {
+	w.Lock()
+	defer w.Unlock()
+	w.schedule(t, time.Now().UnixNano())
+}
+
+// Remove cancels a timeout returning false if it had already expired
+func (w *TimingWheel) Remove(t *Timeout) bool {
+	w.Lock()
+	defer w.Unlock()
+
+	if !t.scheduled {
+		return false
+	}
+	w.unlink(t)
+	return true
+}
+
+// Len returns the number of scheduled timeouts
+func (w *TimingWheel) Len() int {
+	w.Lock()
+	defer w.Unlock()
+	return w.count
+}
+
+// Close stops the wheel, pending timeouts never expire
+func (w *TimingWheel) Close() {
+	close(w.exitChan)
+	w.waitGroup.Wait()
+}
+
+// schedule places a timeout in the slot expired by the tick following
+// its deadline
+//
+// this expects the caller to handle locking
+func (w *TimingWheel) schedule(t *Timeout, now int64) {
+	tick := int64(w.tick)
+	ticks := (t.Deadline - now + tick - 1) / tick
+	if ticks < 1 {
+		ticks = 1
+	}
+
+	numSlots := int64(len(w.slots))
+	t.slot = int((int64(w.current) + ticks - 1) % numSlots)
+	t.rounds = (ticks - 1) / numSlots
+	t.prev = nil
+	t.next = w.slots[t.slot]
+	if t.next != nil {
+		t.next.prev = t
+	}
+	w.slots[t.slot] = t
+	t.scheduled = true
+	w.count++
+}
+
+// unlink removes a timeout from its slot
+//
+// this expects the caller to handle locking
+func (w *TimingWheel) unlink(t *Timeout) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		w.slots[t.slot] = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.prev = nil
+	t.next = nil
+	t.scheduled = false
+	w.count--
+}
+
+// ticker (executed in a goroutine) advances the wheel and hands
+// expired timeouts to the workers
+func (w *TimingWheel) ticker() {
+	var expired []*Timeout
+	var ticks int64
+
+	ticker := time.NewTicker(w.tick)
+	start := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.exitChan:
+			goto exit
+		}
+
+		// catch up on any ticks that were dropped while busy
+		now := time.Now()
+		for target := int64(now.Sub(start) / w.tick); ticks < target; ticks++ {
+			expired = w.advance(now.UnixNano(), expired[:0])
+			for _, t := range expired {
+				select {
+				case w.expiredChan <- t:
+				case <-w.exitChan:
+					goto exit
+				}
+			}
+		}
+	}
+
+exit:
+	ticker.Stop()
+	close(w.expiredChan)
+	log.Printf("TIMINGWHEEL: closing ... ticker")
+}
+
+// advance moves the wheel on by one slot, appending the timeouts that
+// have expired to `expired`
+func (w *TimingWheel) advance(now int64, expired []*Timeout) []*Timeout {
+	w.Lock()
+	defer w.Unlock()
+
+	t := w.slots[w.current]
+	w.current = (w.current + 1) % len(w.slots)
+
+	var next *Timeout
+	for ; t != nil; t = next {
+		next = t.next
+		if t.rounds > 0 {
+			t.rounds--
+			continue
+		}
+
+		w.unlink(t)
+
+		// the first tick after a timeout is added comes around
+		// early (by up to a tick), never expire before the deadline
+		if t.Deadline > now {
+			w.schedule(t, now)
+			continue
+		}
+		expired = append(expired, t)
+	}
+
+	return expired
+}
+
+func (w *TimingWheel) worker() {
+	for t := range w.expiredChan {
+		t.handler.HandleTimeout(t)
+	}
+}
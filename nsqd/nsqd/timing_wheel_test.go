@@ -0,0 +1,60 @@
+package nsqd
+
+import (
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTimingWheel(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	// a revolution is 8ms so the longer timeouts wait several rounds
+	w := NewTimingWheel(time.Millisecond, 8, 1)
+	defer w.Close()
+
+	fired := &testTimeoutHandler{t, make(chan int, 10)}
+	firedChan := fired.firedChan
+
+	start := time.Now()
+	for _, ms := range []int{30, 5, 0, 20} {
+		w.Add(NewTimeout(ms, start.Add(time.Duration(ms)*time.Millisecond), fired))
+	}
+	removed := NewTimeout(10, start.Add(10*time.Millisecond), fired)
+	w.Add(removed)
+	assert.Equal(t, w.Len(), 5)
+	assert.Equal(t, w.Remove(removed), true)
+	assert.Equal(t, w.Remove(removed), false)
+
+	for _, expected := range []int{0, 5, 20, 30} {
+		select {
+		case ms := <-firedChan:
+			assert.Equal(t, ms, expected)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout %d did not expire", expected)
+		}
+	}
+	assert.Equal(t, w.Len(), 0)
+
+	select {
+	case ms := <-firedChan:
+		t.Fatalf("removed timeout %d expired", ms)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+type testTimeoutHandler struct {
+	t         *testing.T
+	firedChan chan int
+}
+
+func (h *testTimeoutHandler) HandleTimeout(timeout *Timeout) {
+	if time.Now().UnixNano() < timeout.Deadline {
+		h.t.Errorf("timeout %d expired before its deadline", timeout.Value.(int))
+	}
+	h.firedChan <- timeout.Value.(int)
+}
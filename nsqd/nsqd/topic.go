@@ -0,0 +1,516 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"../../util"
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Topic struct {
+	sync.RWMutex
+	name               string
+	channelMap         map[string]*Channel
+	backend            BackendQueue
+	retention          *RetentionLog
+	timeouts           *TimingWheel
+	memoryBudget       *MemoryBudget
+	incomingMsgChan    chan *nsq.Message
+	incomingSyncChan   chan *putRequest
+	memoryMsgChan      chan *nsq.Message
+	messagePumpStarter *sync.Once
+	exitChan           chan int
+	waitGroup          util.WaitGroupWrapper
+	exitFlag           int32
+	messageCount       uint64
+	memoryBytes        int64
+	rejectCount        uint64
+	dropCount          uint64
+	limit              depthLimit
+	options            *Options
+	nsqd               *NSQd
+	ephemeralTopic     bool
+	orderedCount       int32 // channels that are ordered, see hasOrderedChannel()
+
+	// when the router started routing the current message (0 while it's
+	// idle), see Liveness()
+	routeStarted int64
+}
+
+var ErrTopicFull = errors.New("topic full")
+
+// putRequest is a message that a publisher is waiting on the router to
+// accept (see --publish-durability)
+type putRequest struct {
+	msg     *nsq.Message
+	errChan chan error
+}
+
+// Topic constructor
+func NewTopic(topicName string, nsqd *NSQd) *Topic {
+	options := nsqd.options
+	topic := &Topic{
+		name:               topicName,
+		channelMap:         make(map[string]*Channel),
+		timeouts:           nsqd.timeouts,
+		memoryBudget:       nsqd.memoryBudget,
+		incomingMsgChan:    make(chan *nsq.Message, 1),
+		incomingSyncChan:   make(chan *putRequest),
+		memoryMsgChan:      make(chan *nsq.Message, options.MemQueueSize),
+		limit:              depthLimit{options.MaxTopicDepth, options.MaxTopicBytes},
+		options:            options,
+		nsqd:               nsqd,
+		exitChan:           make(chan int),
+		messagePumpStarter: new(sync.Once),
+	}
+
+	// like an ephemeral channel, an ephemeral topic is not buffered to disk
+	// (and goes away with its last channel)
+	if strings.HasSuffix(topicName, "#ephemeral") {
+		topic.ephemeralTopic = true
+		topic.backend = NewDummyBackendQueue()
+	} else {
+		topic.backend = NewDiskQueue(topicName, options.DataPath, options.MaxBytesPerFile, options.SyncEvery)
+	}
+
+	window := options.retentionFor(topicName)
+	if window > 0 && !topic.ephemeralTopic {
+		topic.retention = NewRetentionLog(topicName, options.DataPath, window, options.MaxBytesPerFile, options.SyncEvery)
+	}
+
+	topic.waitGroup.Wrap(func() { topic.router() })
+
+	nsqd.postEvent("topic_change", topic)
+	nsqd.postTopologyEvent(eventTopicCreated, topicName, "", nil)
+
+	return topic
+}
+
+func (t *Topic) MemoryChan() chan *nsq.Message {
+	return t.memoryMsgChan
+}
+
+func (t *Topic) BackendQueue() BackendQueue {
+	return t.backend
+}
+
+func (t *Topic) InFlight() map[string]*Timeout {
+	return nil
+}
+
+func (t *Topic) Deferred() map[string]*Timeout {
+	return nil
+}
+
+func (t *Topic) MemoryDequeued(msg *nsq.Message) {
+	size := messageSize(msg)
+	atomic.AddInt64(&t.memoryBytes, -size)
+	t.memoryBudget.Release(size)
+}
+
+// Exiting returns a boolean indicating if this topic is closed/exiting
+func (t *Topic) Exiting() bool {
+	return atomic.LoadInt32(&t.exitFlag) == 1
+}
+
+// GetChannel performs a thread safe operation
+// to return a pointer to a Channel object (potentially new)
+// for the given Topic
+func (t *Topic) GetChannel(channelName string) *Channel {
+	t.Lock()
+	defer t.Unlock()
+	return t.getOrCreateChannel(channelName)
+}
+
+// this expects the caller to handle locking
+func (t *Topic) getOrCreateChannel(channelName string) *Channel {
+	channel, ok := t.channelMap[channelName]
+	if !ok {
+		deleteCallback := func(c *Channel) {
+			t.DeleteExistingChannel(c.name)
+		}
+		channel = NewChannel(t.name, channelName, t.nsqd, deleteCallback)
+		t.channelMap[channelName] = channel
+		channel.attachTopic(&t.orderedCount)
+		log.Printf("TOPIC(%s): new channel(%s)", t.name, channel.name)
+		// start the topic message pump lazily using a `once` on the first channel creation
+		t.messagePumpStarter.Do(func() { t.waitGroup.Wrap(func() { t.messagePump() }) })
+	}
+	return channel
+}
+
+func (t *Topic) GetExistingChannel(channelName string) (*Channel, error) {
+	t.RLock()
+	defer t.RUnlock()
+	channel, ok := t.channelMap[channelName]
+	if !ok {
+		return nil, errors.New("channel does not exist")
+	}
+	return channel, nil
+}
+
+// DeleteExistingChannel removes a channel from the topic only if it exists
+func (t *Topic) DeleteExistingChannel(channelName string) error {
+	t.Lock()
+	channel, ok := t.channelMap[channelName]
+	if !ok {
+		t.Unlock()
+		return errors.New("channel does not exist")
+	}
+	delete(t.channelMap, channelName)
+	channel.detachTopic()
+	// not defered so that we can continue while the channel async closes
+	t.Unlock()
+
+	log.Printf("TOPIC(%s): deleting channel %s", t.name, channel.name)
+
+	// delete empties the channel before closing
+	// (so that we dont leave any messages around)
+	channel.Delete()
+
+	// since we are explicitly deleting a channel (not just at system exit time)
+	// de-register this from the lookupd
+	t.nsqd.postEvent("channel_change", channel)
+	t.nsqd.postTopologyEvent(eventChannelDeleted, t.name, channel.name, nil)
+
+	if t.ephemeralTopic {
+		t.RLock()
+		numChannels := len(t.channelMap)
+		t.RUnlock()
+		if numChannels == 0 {
+			go t.nsqd.DeleteExistingTopic(t.name)
+		}
+	}
+
+	return nil
+}
+
+// PutMessage writes to the appropriate incoming message channel
+// (and the retention log, if enabled)
+//
+// if the topic (or, for the reject policy, any of its channels) is at
+// its max depth the configured policy is applied
+func (t *Topic) PutMessage(msg *nsq.Message) error {
+	if t.Full() {
+		switch t.options.DepthPolicy {
+		case depthPolicyDropNewest:
+			atomic.AddUint64(&t.dropCount, 1)
+			return nil
+		case depthPolicyDropOldest:
+			if _, ok := DropOldest(t); ok {
+				atomic.AddUint64(&t.dropCount, 1)
+			}
+		default:
+			atomic.AddUint64(&t.rejectCount, 1)
+			return ErrTopicFull
+		}
+	} else if t.options.DepthPolicy == depthPolicyReject && t.channelsFull() {
+		// channels can't reject messages the topic has already accepted
+		// so publishers need to be pushed back here
+		atomic.AddUint64(&t.rejectCount, 1)
+		return ErrTopicFull
+	}
+
+	var err error
+	if t.options.PublishDurability == publishDurabilityNone {
+		err = t.put(msg)
+	} else {
+		err = t.putSync(msg)
+	}
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&t.messageCount, 1)
+
+	if t.retention != nil {
+		err = t.retention.Append(msg)
+		if err != nil {
+			// the message was still published, it just can't be replayed
+			log.Printf("TOPIC(%s) ERROR: failed to retain msg(%s) - %s", t.name, msg.Id, err.Error())
+		}
+	}
+	return nil
+}
+
+func (t *Topic) put(msg *nsq.Message) error {
+	t.RLock()
+	defer t.RUnlock()
+	if atomic.LoadInt32(&t.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+	t.incomingMsgChan <- msg
+	return nil
+}
+
+// putSync waits for the router to either accept the message into memory or
+// write it to the backend, returning any error from doing so
+func (t *Topic) putSync(msg *nsq.Message) error {
+	t.RLock()
+	defer t.RUnlock()
+	if atomic.LoadInt32(&t.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+	req := &putRequest{msg, make(chan error, 1)}
+	t.incomingSyncChan <- req
+	return <-req.errChan
+}
+
+// CreateChannelFromEarliest creates a new channel and fills it with every
+// message still in the topic's retention log
+func (t *Topic) CreateChannelFromEarliest(channelName string, idChan chan []byte) (int, error) {
+	if t.retention == nil {
+		return 0, errors.New("retention not enabled")
+	}
+
+	t.Lock()
+	_, ok := t.channelMap[channelName]
+	if ok {
+		t.Unlock()
+		return 0, errors.New("channel already exists")
+	}
+	t.getOrCreateChannel(channelName)
+	t.Unlock()
+
+	return t.RewindChannel(channelName, 0, idChan)
+}
+
+// RewindChannel re-injects every retained message published at or after
+// `since` (unix timestamp) into a single channel's queue.
+//
+// Replayed messages are assigned new IDs (read from idChan) so that they
+// can't collide with the originals if those are still in-flight.
+func (t *Topic) RewindChannel(channelName string, since int64, idChan chan []byte) (int, error) {
+	if t.retention == nil {
+		return 0, errors.New("retention not enabled")
+	}
+
+	channel, err := t.GetExistingChannel(channelName)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("TOPIC(%s): rewinding channel(%s) to %d", t.name, channelName, since)
+
+	return t.retention.Replay(since, func(msg *nsq.Message) error {
+		msg.Id = <-idChan
+		return channel.PutMessage(msg)
+	})
+}
+
+func (t *Topic) Depth() int64 {
+	return int64(len(t.memoryMsgChan)) + t.backend.Depth()
+}
+
+// DepthBytes returns the (approximate) number of bytes queued for this topic
+func (t *Topic) DepthBytes() int64 {
+	return atomic.LoadInt64(&t.memoryBytes) + t.backend.DepthBytes()
+}
+
+// Full returns a boolean indicating if this topic is at its max depth
+func (t *Topic) Full() bool {
+	return t.limit.exceeded(t.Depth(), t.DepthBytes())
+}
+
+// hasOrderedChannel returns true if any channel delivers keyed messages in order
+//
+// it's called by the router so it mustn't take the topic's lock (publishers
+// hold it while they wait on the router)
+func (t *Topic) hasOrderedChannel() bool {
+	return atomic.LoadInt32(&t.orderedCount) > 0
+}
+
+func (t *Topic) channelsFull() bool {
+	t.RLock()
+	defer t.RUnlock()
+	for _, channel := range t.channelMap {
+		if channel.Full() {
+			return true
+		}
+	}
+	return false
+}
+
+// messagePump selects over the in-memory and backend queue and 
+// writes messages to every channel for this topic
+func (t *Topic) messagePump() {
+	var msg *nsq.Message
+	var buf []byte
+	var err error
+
+	for {
+		// do an extra check for exit before we select on all the memory/backend/exitChan
+		// this solves the case where we are closed and something else is writing into
+		// backend. we don't want to reverse that
+		if atomic.LoadInt32(&t.exitFlag) == 1 {
+			goto exit
+		}
+
+		select {
+		case msg = <-t.memoryMsgChan:
+			t.MemoryDequeued(msg)
+		case buf = <-t.backend.ReadChan():
+			msg, err = decodeBackendMessage(buf)
+			if err != nil {
+				log.Printf("ERROR: failed to decode message - %s", err.Error())
+				continue
+			}
+		case <-t.exitChan:
+			goto exit
+		}
+
+		t.RLock()
+		// check if all the channels have been deleted
+		if len(t.channelMap) == 0 {
+			// put this message back on the queue
+			// we need to background because we currently hold the lock
+			go func() {
+				t.put(msg)
+			}()
+
+			// reset the sync.Once
+			t.messagePumpStarter = new(sync.Once)
+
+			t.RUnlock()
+			goto exit
+		}
+
+		for _, channel := range t.channelMap {
+			// copy the message because each channel
+			// needs a unique instance
+			chanMsg := nsq.NewMessage(msg.Id, msg.Body)
+			chanMsg.Timestamp = msg.Timestamp
+			chanMsg.TimestampNano = msg.TimestampNano
+			chanMsg.Key = msg.Key
+			chanMsg.ReplyTo = msg.ReplyTo
+			chanMsg.CorrelationId = msg.CorrelationId
+			err := channel.PutMessage(chanMsg)
+			if err != nil {
+				log.Printf("TOPIC(%s) ERROR: failed to put msg(%s) to channel(%s) - %s", t.name, msg.Id, channel.name, err.Error())
+			}
+		}
+		t.RUnlock()
+	}
+
+exit:
+	log.Printf("TOPIC(%s): closing ... messagePump", t.name)
+}
+
+// router handles muxing of Topic messages including
+// proxying messages to memory or backend
+func (t *Topic) router() {
+	var msgBuf bytes.Buffer
+	for {
+		select {
+		case msg, ok := <-t.incomingMsgChan:
+			if !ok {
+				goto exit
+			}
+			err := t.routeMessage(&msgBuf, msg, false)
+			if err != nil {
+				log.Printf("ERROR: failed to write message to backend - %s", err.Error())
+				// theres not really much we can do at this point, you're certainly
+				// going to lose messages...
+				atomic.AddUint64(&t.dropCount, 1)
+			}
+		case req := <-t.incomingSyncChan:
+			req.errChan <- t.routeMessage(&msgBuf, req.msg, t.options.PublishDurability == publishDurabilityFsync)
+		}
+	}
+
+exit:
+	log.Printf("TOPIC(%s): closing ... router", t.name)
+}
+
+// routeMessage writes a message to memory or, if that (or the node's memory
+// budget) is full, the backend (optionally fsyncing it)
+//
+// keyed messages go to the backend when a channel is ordered so that they
+// are read back out in the order they were published (unless ephemeral,
+// which has no backend to spill to)
+func (t *Topic) routeMessage(msgBuf *bytes.Buffer, msg *nsq.Message, fsync bool) error {
+	atomic.StoreInt64(&t.routeStarted, time.Now().UnixNano())
+	defer atomic.StoreInt64(&t.routeStarted, 0)
+
+	if msg.Key == nil || !t.hasOrderedChannel() || t.ephemeralTopic {
+		size := messageSize(msg)
+		if t.memoryBudget.Reserve(size) {
+			atomic.AddInt64(&t.memoryBytes, size)
+			select {
+			case t.memoryMsgChan <- msg:
+				return nil
+			default:
+			}
+			atomic.AddInt64(&t.memoryBytes, -size)
+			t.memoryBudget.Release(size)
+		}
+	}
+
+	err := WriteMessageToBackend(msgBuf, msg, t)
+	if err != nil {
+		return err
+	}
+	if fsync {
+		return t.backend.Sync()
+	}
+	return nil
+}
+
+// Delete empties the topic and all its channels and closes
+func (t *Topic) Delete() error {
+	EmptyQueue(t)
+	t.Lock()
+	for _, channel := range t.channelMap {
+		delete(t.channelMap, channel.name)
+		channel.detachTopic()
+		channel.Delete()
+	}
+	t.Unlock()
+	err := t.Close()
+	if t.retention != nil {
+		t.retention.Delete()
+	}
+	return err
+}
+
+func (t *Topic) Close() error {
+	if atomic.LoadInt32(&t.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+
+	log.Printf("TOPIC(%s): closing", t.name)
+
+	// initiate exit
+	atomic.StoreInt32(&t.exitFlag, 1)
+
+	close(t.exitChan)
+	t.Lock()
+	close(t.incomingMsgChan)
+	t.Unlock()
+
+	// synchronize the close of router() and messagePump()
+	t.waitGroup.Wait()
+
+	// close all the channels
+	for _, channel := range t.channelMap {
+		err := channel.Close()
+		if err != nil {
+			// we need to continue regardless of error to close all the channels
+			log.Printf("ERROR: channel(%s) close - %s", channel.name, err.Error())
+		}
+	}
+
+	// write anything leftover to disk
+	if len(t.memoryMsgChan) > 0 {
+		log.Printf("TOPIC(%s): flushing %d memory messages to backend", t.name, len(t.memoryMsgChan))
+	}
+	FlushQueue(t)
+	if t.retention != nil {
+		t.retention.Close()
+	}
+	return t.backend.Close()
+}
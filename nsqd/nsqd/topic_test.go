@@ -0,0 +1,288 @@
+package nsqd
+
+import (
+	"../../nsq"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGetTopic(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+
+	topic1 := nsqd.GetTopic("test")
+	assert.NotEqual(t, nil, topic1)
+	assert.Equal(t, "test", topic1.name)
+
+	topic2 := nsqd.GetTopic("test")
+	assert.Equal(t, topic1, topic2)
+
+	topic3 := nsqd.GetTopic("test2")
+	assert.Equal(t, "test2", topic3.name)
+	assert.NotEqual(t, topic2, topic3)
+}
+
+func TestGetChannel(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+
+	topic := nsqd.GetTopic("test")
+
+	channel1 := topic.GetChannel("ch1")
+	assert.NotEqual(t, nil, channel1)
+	assert.Equal(t, "ch1", channel1.name)
+
+	channel2 := topic.GetChannel("ch2")
+
+	assert.Equal(t, channel1, topic.channelMap["ch1"])
+	assert.Equal(t, channel2, topic.channelMap["ch2"])
+}
+
+func TestDeletes(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+
+	topic := nsqd.GetTopic("test")
+
+	channel1 := topic.GetChannel("ch1")
+	assert.NotEqual(t, nil, channel1)
+
+	err := topic.DeleteExistingChannel("ch1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(topic.channelMap))
+
+	channel2 := topic.GetChannel("ch2")
+	assert.NotEqual(t, nil, channel2)
+
+	err = nsqd.DeleteExistingTopic("test")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(topic.channelMap))
+	assert.Equal(t, 0, len(nsqd.topicMap))
+}
+
+func TestDeleteLast(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	nsqd := New(NewOptions())
+	defer nsqd.Exit()
+
+	topic := nsqd.GetTopic("test")
+
+	channel1 := topic.GetChannel("ch1")
+	assert.NotEqual(t, nil, channel1)
+
+	err := topic.DeleteExistingChannel("ch1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(topic.channelMap))
+
+	msg := nsq.NewMessage(<-nsqd.idChan, []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+	err = topic.PutMessage(msg)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, topic.Depth(), int64(1))
+}
+
+func TestTopicDepthLimit(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	options := NewOptions()
+	options.MaxTopicDepth = 2
+	nsqd := New(options)
+	defer nsqd.Exit()
+
+	topicName := "depth_limit" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+	for i := 0; i < 2; i++ {
+		err := topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, []byte("test")))
+		assert.Equal(t, nil, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	err := topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, []byte("test")))
+	assert.Equal(t, ErrTopicFull, err)
+	assert.Equal(t, topic.Depth(), int64(2))
+	assert.Equal(t, topic.rejectCount, uint64(1))
+
+	options.DepthPolicy = depthPolicyDropNewest
+	err = topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, []byte("test")))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, topic.Depth(), int64(2))
+	assert.Equal(t, topic.dropCount, uint64(1))
+
+	options.DepthPolicy = depthPolicyDropOldest
+	err = topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, []byte("newest")))
+	assert.Equal(t, nil, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, topic.Depth(), int64(2))
+	assert.Equal(t, topic.dropCount, uint64(2))
+	assert.Equal(t, topic.DepthBytes(), 2*messageSize(nsq.NewMessage(nil, []byte("test")))+2)
+}
+
+func TestTopicChannelDepthLimit(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	options := NewOptions()
+	options.MaxChannelDepth = 1
+	nsqd := New(options)
+	defer nsqd.Exit()
+
+	topicName := "channel_depth_limit" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+	channel := topic.GetChannel("ch")
+
+	err := topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, []byte("test")))
+	assert.Equal(t, nil, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, channel.Depth(), int64(1))
+
+	err = topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, []byte("test")))
+	assert.Equal(t, ErrTopicFull, err)
+}
+
+func TestTopicPublishDurability(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	options := NewOptions()
+	options.MemQueueSize = 0
+	options.PublishDurability = publishDurabilityFsync
+	nsqd := New(options)
+	defer nsqd.Exit()
+
+	topicName := "publish_durability" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+
+	// returns only once the message has been written to the backend
+	err := topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, []byte("test")))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, topic.backend.Depth(), int64(1))
+
+	// backend errors are returned to the publisher
+	backend := topic.backend
+	backend.Close()
+	err = topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, []byte("test")))
+	assert.NotEqual(t, nil, err)
+
+	// the DiskQueue can't be closed twice
+	topic.backend = NewDummyBackendQueue()
+	os.Remove(backend.(*DiskQueue).metaDataFileName())
+	os.Remove(backend.(*DiskQueue).fileName(0))
+}
+
+func BenchmarkTopicPut(b *testing.B) {
+	b.StopTimer()
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+	topicName := "bench_topic_put" + strconv.Itoa(b.N)
+	options := NewOptions()
+	options.MemQueueSize = int64(b.N)
+	nsqd := New(options)
+	defer nsqd.Exit()
+	b.StartTimer()
+
+	for i := 0; i <= b.N; i++ {
+		topic := nsqd.GetTopic(topicName)
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+		topic.PutMessage(msg)
+	}
+}
+
+func BenchmarkTopicToChannelPut(b *testing.B) {
+	b.StopTimer()
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+	topicName := "bench_topic_to_channel_put" + strconv.Itoa(b.N)
+	channelName := "bench"
+	options := NewOptions()
+	options.MemQueueSize = int64(b.N)
+	nsqd := New(options)
+	defer nsqd.Exit()
+	channel := nsqd.GetTopic(topicName).GetChannel(channelName)
+	b.StartTimer()
+
+	for i := 0; i <= b.N; i++ {
+		topic := nsqd.GetTopic(topicName)
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+		topic.PutMessage(msg)
+	}
+
+	for {
+		if len(channel.memoryMsgChan) == b.N {
+			break
+		}
+		runtime.Gosched()
+	}
+}
+
+// benchmarkTopicFanOut publishes b.N messages to a topic with `channels`
+// channels and waits until every channel has queued all of them
+func benchmarkTopicFanOut(b *testing.B, channels int, bodySize int, memQueueSize int64) {
+	b.StopTimer()
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+	topicName := "bench_topic_fan_out" + strconv.Itoa(channels) + "_" + strconv.Itoa(b.N) + "_" + strconv.Itoa(int(time.Now().Unix()))
+	options := NewOptions()
+	options.DataPath = os.TempDir()
+	options.MemQueueSize = memQueueSize
+	nsqd := New(options)
+	defer nsqd.Exit()
+	topic := nsqd.GetTopic(topicName)
+	defer nsqd.DeleteExistingTopic(topicName)
+	channelList := make([]*Channel, channels)
+	for i := range channelList {
+		channelList[i] = topic.GetChannel("ch" + strconv.Itoa(i))
+	}
+	body := make([]byte, bodySize)
+	b.SetBytes(int64(bodySize))
+	b.ReportAllocs()
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, body))
+	}
+
+	for _, channel := range channelList {
+		for channel.Depth() != int64(b.N) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	b.StopTimer()
+}
+
+func BenchmarkTopicFanOut1(b *testing.B) {
+	benchmarkTopicFanOut(b, 1, 100, int64(b.N))
+}
+
+func BenchmarkTopicFanOut20(b *testing.B) {
+	benchmarkTopicFanOut(b, 20, 100, int64(b.N))
+}
+
+// every message is written to each channel's backend
+func BenchmarkTopicFanOutDisk1(b *testing.B) {
+	benchmarkTopicFanOut(b, 1, 4096, 0)
+}
+
+func BenchmarkTopicFanOutDisk20(b *testing.B) {
+	benchmarkTopicFanOut(b, 20, 4096, 0)
+}
@@ -0,0 +1,202 @@
+package nsqd
+
+import (
+	"../../util"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/bitly/go-notify"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// the types of TopologyEvent
+const (
+	eventTopicCreated       = "topic_created"
+	eventTopicDeleted       = "topic_deleted"
+	eventChannelCreated     = "channel_created"
+	eventChannelDeleted     = "channel_deleted"
+	eventChannelPaused      = "channel_paused"
+	eventChannelUnPaused    = "channel_unpaused"
+	eventChannelEmptied     = "channel_emptied"
+	eventClientConnected    = "client_connected"
+	eventClientDisconnected = "client_disconnected"
+)
+
+const (
+	webhookTimeout    = 5 * time.Second
+	webhookRetryDelay = 250 * time.Millisecond // doubled after each failed attempt
+)
+
+// TopologyEvent describes a change to a topic, channel or a channel's clients,
+// they are posted (via go-notify) as "topology_event" and delivered to
+// the --webhook-url targets
+type TopologyEvent struct {
+	Type          string `json:"type"`
+	Timestamp     int64  `json:"timestamp"` // unix nano, events can be delivered out of order
+	Node          string `json:"node"`
+	Topic         string `json:"topic"`
+	Channel       string `json:"channel,omitempty"`
+	ClientID      int64  `json:"client_id,omitempty"`
+	ClientAddress string `json:"client_address,omitempty"`
+	ClientName    string `json:"client_name,omitempty"`
+
+	client Consumer // the client fields are filled in when delivered
+}
+
+func (n *NSQd) postTopologyEvent(eventType string, topicName string, channelName string, client Consumer) {
+	event := &TopologyEvent{
+		Type:      eventType,
+		Timestamp: time.Now().UnixNano(),
+		Topic:     topicName,
+		Channel:   channelName,
+		client:    client,
+	}
+	n.postEvent("topology_event", event)
+}
+
+// webhookTarget is an URL events are POSTed to (one at a time, in the
+// order they were received) from a bounded queue
+type webhookTarget struct {
+	url       string
+	eventChan chan []byte
+
+	deliveredCount uint64
+	failedCount    uint64 // given up on after --webhook-max-attempts
+	droppedCount   uint64 // the queue was full
+}
+
+func newWebhookTargets(urls []string, queueSize int64) []*webhookTarget {
+	targets := make([]*webhookTarget, len(urls))
+	for i, url := range urls {
+		targets[i] = &webhookTarget{
+			url:       url,
+			eventChan: make(chan []byte, queueSize),
+		}
+	}
+	return targets
+}
+
+// webhookLoop queues every TopologyEvent (received on eventChan)
+// for each of the webhook targets
+func (n *NSQd) webhookLoop(eventChan chan interface{}) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("ERROR: failed to get hostname - %s", err.Error())
+	}
+	node := hostname
+	if n.tcpAddr != nil {
+		node = net.JoinHostPort(hostname, strconv.Itoa(n.tcpAddr.Port))
+	}
+
+	var senders util.WaitGroupWrapper
+	for _, target := range n.webhookTargets {
+		t := target
+		senders.Wrap(func() { n.webhookSender(t) })
+	}
+
+	for {
+		select {
+		case obj := <-eventChan:
+			event := obj.(*TopologyEvent)
+			event.Node = node
+			if event.client != nil {
+				stats := event.client.Stats()
+				event.ClientID = stats.id
+				event.ClientAddress = stats.address
+				event.ClientName = stats.name
+			}
+			body, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("ERROR: failed to marshal event %+v - %s", event, err.Error())
+				continue
+			}
+			for _, target := range n.webhookTargets {
+				select {
+				case target.eventChan <- body:
+				default:
+					atomic.AddUint64(&target.droppedCount, 1)
+					log.Printf("WEBHOOK(%s): ERROR queue full, dropping %s event", target.url, event.Type)
+				}
+			}
+		case <-n.exitChan:
+			goto exit
+		}
+	}
+
+exit:
+	log.Printf("WEBHOOK: closing")
+	// a pending Post holds the lock Stop needs until its event is received
+	go func() {
+		for _ = range eventChan {
+		}
+	}()
+	notify.Stop(n.eventName("topology_event"), eventChan)
+	senders.Wait()
+}
+
+// webhookSender delivers the events queued for a target, retrying
+// (with an increasing delay) up to --webhook-max-attempts times
+func (n *NSQd) webhookSender(target *webhookTarget) {
+	httpclient := &http.Client{Timeout: webhookTimeout}
+
+	for {
+		var body []byte
+		select {
+		case body = <-target.eventChan:
+		case <-n.exitChan:
+			goto exit
+		}
+
+		delay := webhookRetryDelay
+		for attempt := 1; ; attempt++ {
+			err := postWebhook(httpclient, target.url, body)
+			if err == nil {
+				atomic.AddUint64(&target.deliveredCount, 1)
+				break
+			}
+			if attempt >= n.options.WebhookMaxAttempts {
+				atomic.AddUint64(&target.failedCount, 1)
+				log.Printf("WEBHOOK(%s): ERROR giving up after %d attempts - %s", target.url, attempt, err.Error())
+				break
+			}
+			log.Printf("WEBHOOK(%s): ERROR attempt %d failed, retrying in %s - %s", target.url, attempt, delay, err.Error())
+
+			select {
+			case <-time.After(delay):
+			case <-n.exitChan:
+				goto exit
+			}
+			delay *= 2
+		}
+	}
+
+exit:
+	if len(target.eventChan) > 0 {
+		log.Printf("WEBHOOK(%s): dropping %d queued events", target.url, len(target.eventChan))
+	}
+}
+
+func postWebhook(httpclient *http.Client, url string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("nsqd v%s", util.BINARY_VERSION))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpclient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("got status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package nsqd
+
+import (
+	"encoding/json"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	// the first attempt fails so that every event is delivered in order
+	// after a retry
+	var requests int32
+	eventChan := make(chan TopologyEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(500)
+			return
+		}
+		var event TopologyEvent
+		body, _ := ioutil.ReadAll(req.Body)
+		err := json.Unmarshal(body, &event)
+		assert.Equal(t, err, nil)
+		eventChan <- event
+	}))
+	defer server.Close()
+
+	options := NewOptions()
+	options.WebhookURLs = []string{server.URL}
+	_, _, nsqd := mustStartNSQd(options)
+	defer nsqd.Exit()
+
+	topicName := "test_webhooks" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	received := receiveEvent(t, eventChan)
+	assert.Equal(t, received.Type, eventTopicCreated)
+	assert.Equal(t, received.Topic, topicName)
+
+	channel := topic.GetChannel("ch")
+	received = receiveEvent(t, eventChan)
+	assert.Equal(t, received.Type, eventChannelCreated)
+	assert.Equal(t, received.Channel, "ch")
+
+	channel.Pause()
+	assert.Equal(t, receiveEvent(t, eventChan).Type, eventChannelPaused)
+
+	nsqd.DeleteExistingTopic(topicName)
+	assert.Equal(t, receiveEvent(t, eventChan).Type, eventTopicDeleted)
+
+	// the handler sees the last event before its delivery is counted
+	target := nsqd.webhookTargets[0]
+	for atomic.LoadUint64(&target.deliveredCount) != 4 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, atomic.LoadUint64(&target.failedCount), uint64(0))
+}
+
+func receiveEvent(t *testing.T, eventChan chan TopologyEvent) TopologyEvent {
+	select {
+	case event := <-eventChan:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+	return TopologyEvent{}
+}
@@ -1,12 +1,11 @@
 package main
 
 import (
-	"../nsq"
 	"../util"
+	"./nsqlookupd"
 	"flag"
 	"fmt"
 	"log"
-	"net"
 	"os"
 	"os/signal"
 )
@@ -15,12 +14,12 @@ var (
 	showVersion = flag.Bool("version", false, "print version string")
 	tcpAddress  = flag.String("tcp-address", "0.0.0.0:4160", "<addr>:<port> to listen on for TCP clients")
 	httpAddress = flag.String("http-address", "0.0.0.0:4161", "<addr>:<port> to listen on for HTTP clients")
+	tcpSocket   = flag.String("tcp-socket", "", "path of a unix socket to listen on for TCP clients")
+	httpSocket  = flag.String("http-socket", "", "path of a unix socket to listen on for HTTP clients")
 	debugMode   = flag.Bool("debug", false, "enable debug mode")
+	config      = flag.String("config", "", "path to a JSON config file")
 )
 
-var protocols = map[int32]nsq.Protocol{}
-var lookupd *NSQLookupd
-
 func main() {
 	flag.Parse()
 
@@ -30,6 +29,14 @@ func main() {
 		return
 	}
 
+	// none of nsqlookupd's settings can be changed at runtime, so there's no reload
+	if *config != "" {
+		err := util.NewConfigFile(*config, flag.CommandLine).Load()
+		if err != nil {
+			log.Fatalf("FATAL: failed to load config - %s", err.Error())
+		}
+	}
+
 	// os.Signal 表示系统信号
 	signalChan := make(chan os.Signal, 1)
 	exitChan := make(chan int)
@@ -42,22 +49,18 @@ func main() {
 	// 捕获 SIGINT 信号
 	signal.Notify(signalChan, os.Interrupt)
 
-	tcpAddr, err := net.ResolveTCPAddr("tcp", *tcpAddress)
-	if err != nil {
-		log.Fatal(err)
-	}
+	log.Printf("nsqlookupd v%s", util.BINARY_VERSION)
 
-	httpAddr, err := net.ResolveTCPAddr("tcp", *httpAddress)
+	options := nsqlookupd.NewOptions()
+	options.TCPAddress = *tcpAddress
+	options.HTTPAddress = *httpAddress
+	options.TCPSocket = *tcpSocket
+	options.HTTPSocket = *httpSocket
+	lookupd := nsqlookupd.New(options)
+	err := lookupd.Start()
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("FATAL: %s", err.Error())
 	}
-
-	log.Printf("nsqlookupd v%s", util.BINARY_VERSION)
-
-	lookupd = NewNSQLookupd()
-	lookupd.tcpAddr = tcpAddr
-	lookupd.httpAddr = httpAddr
-	lookupd.Main()
 	<-exitChan
 	lookupd.Exit()
 }
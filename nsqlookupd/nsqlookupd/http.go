@@ -1,7 +1,7 @@
-package main
+package nsqlookupd
 
 import (
-	"../util"
+	"../../util"
 	"io"
 	"log"
 	"net"
@@ -9,17 +9,26 @@ import (
 	"strings"
 )
 
-func httpServer(listener net.Listener) {
+// httpServer serves the HTTP API of an NSQLookupd
+type httpServer struct {
+	nsqlookupd *NSQLookupd
+}
+
+func newHTTPServer(nsqlookupd *NSQLookupd) *httpServer {
+	return &httpServer{nsqlookupd: nsqlookupd}
+}
+
+func (s *httpServer) serve(listener net.Listener) {
 	log.Printf("HTTP: listening on %s", listener.Addr().String())
 
 	handler := http.NewServeMux()
-	handler.HandleFunc("/ping", pingHandler)
-	handler.HandleFunc("/lookup", lookupHandler)
-	handler.HandleFunc("/topics", topicsHandler)
-	handler.HandleFunc("/nodes", nodesHandler)
-	handler.HandleFunc("/delete_topic", deleteTopicHandler)
-	handler.HandleFunc("/delete_channel", deleteChannelHandler)
-	handler.HandleFunc("/info", infoHandler)
+	handler.HandleFunc("/ping", s.pingHandler)
+	handler.HandleFunc("/lookup", s.lookupHandler)
+	handler.HandleFunc("/topics", s.topicsHandler)
+	handler.HandleFunc("/nodes", s.nodesHandler)
+	handler.HandleFunc("/delete_topic", s.deleteTopicHandler)
+	handler.HandleFunc("/delete_channel", s.deleteChannelHandler)
+	handler.HandleFunc("/info", s.infoHandler)
 
 	server := &http.Server{
 		Handler: handler,
@@ -33,21 +42,21 @@ func httpServer(listener net.Listener) {
 	log.Printf("HTTP: closing %s", listener.Addr().String())
 }
 
-func pingHandler(w http.ResponseWriter, req *http.Request) {
+func (s *httpServer) pingHandler(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Length", "2")
 	io.WriteString(w, "OK")
 }
 
-func topicsHandler(w http.ResponseWriter, req *http.Request) {
+func (s *httpServer) topicsHandler(w http.ResponseWriter, req *http.Request) {
 	// 寻找所有的 topic
-	topics := lookupd.DB.FindRegistrations("topic", "*", "").Keys()
+	topics := s.nsqlookupd.DB.FindRegistrations("topic", "*", "").Keys()
 	data := make(map[string]interface{})
 	data["topics"] = topics
 	util.ApiResponse(w, 200, "OK", data)
 }
 
 // 通过 topic 找到 channels 和 producers
-func lookupHandler(w http.ResponseWriter, req *http.Request) {
+func (s *httpServer) lookupHandler(w http.ResponseWriter, req *http.Request) {
 	reqParams, err := util.NewReqParams(req)
 	if err != nil {
 		util.ApiResponse(w, 500, "INVALID_REQUEST", nil)
@@ -60,15 +69,15 @@ func lookupHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	registration := lookupd.DB.FindRegistrations("topic", topicName, "")
+	registration := s.nsqlookupd.DB.FindRegistrations("topic", topicName, "")
 
 	if len(registration) == 0 {
 		util.ApiResponse(w, 500, "INVALID_ARG_TOPIC", nil)
 		return
 	}
 
-	channels := lookupd.DB.FindRegistrations("channel", topicName, "*").SubKeys()
-	producers := lookupd.DB.FindProducers("topic", topicName, "").CurrentProducers()
+	channels := s.nsqlookupd.DB.FindRegistrations("channel", topicName, "*").SubKeys()
+	producers := s.nsqlookupd.DB.FindProducers("topic", topicName, "").CurrentProducers()
 	data := make(map[string]interface{})
 	data["channels"] = channels
 	data["producers"] = producers
@@ -76,7 +85,7 @@ func lookupHandler(w http.ResponseWriter, req *http.Request) {
 }
 
 // 删除 topic 以及对应的 channel
-func deleteTopicHandler(w http.ResponseWriter, req *http.Request) {
+func (s *httpServer) deleteTopicHandler(w http.ResponseWriter, req *http.Request) {
 	reqParams, err := util.NewReqParams(req)
 	if err != nil {
 		util.ApiResponse(w, 500, "INVALID_REQUEST", nil)
@@ -90,23 +99,23 @@ func deleteTopicHandler(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// 删除此 topic 对应的 channel
-	registrations := lookupd.DB.FindRegistrations("channel", topicName, "*")
+	registrations := s.nsqlookupd.DB.FindRegistrations("channel", topicName, "*")
 	for _, registration := range registrations {
 		log.Printf("DB: removing channel(%s) from topic(%s)", registration.SubKey, topicName)
-		lookupd.DB.RemoveRegistration(*registration)
+		s.nsqlookupd.DB.RemoveRegistration(*registration)
 	}
 
 	// 删除 topic
-	registrations = lookupd.DB.FindRegistrations("topic", topicName, "")
+	registrations = s.nsqlookupd.DB.FindRegistrations("topic", topicName, "")
 	for _, registration := range registrations {
 		log.Printf("DB: removing topic(%s)", topicName)
-		lookupd.DB.RemoveRegistration(*registration)
+		s.nsqlookupd.DB.RemoveRegistration(*registration)
 	}
 
 	util.ApiResponse(w, 200, "OK", nil)
 }
 
-func deleteChannelHandler(w http.ResponseWriter, req *http.Request) {
+func (s *httpServer) deleteChannelHandler(w http.ResponseWriter, req *http.Request) {
 	reqParams, err := util.NewReqParams(req)
 	if err != nil {
 		util.ApiResponse(w, 500, "INVALID_REQUEST", nil)
@@ -119,7 +128,7 @@ func deleteChannelHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	registrations := lookupd.DB.FindRegistrations("channel", topicName, channelName)
+	registrations := s.nsqlookupd.DB.FindRegistrations("channel", topicName, channelName)
 	if len(registrations) == 0 {
 		util.ApiResponse(w, 404, "NOT_FOUND", nil)
 		return
@@ -127,7 +136,7 @@ func deleteChannelHandler(w http.ResponseWriter, req *http.Request) {
 
 	log.Printf("DB: removing channel(%s) from topic(%s)", channelName, topicName)
 	for _, registration := range registrations {
-		lookupd.DB.RemoveRegistration(*registration)
+		s.nsqlookupd.DB.RemoveRegistration(*registration)
 	}
 
 	util.ApiResponse(w, 200, "OK", nil)
@@ -143,8 +152,8 @@ type producerTopic struct {
 }
 
 // 返回所有的 client 的信息
-func nodesHandler(w http.ResponseWriter, req *http.Request) {
-	producers := lookupd.DB.FindProducers("client", "", "")
+func (s *httpServer) nodesHandler(w http.ResponseWriter, req *http.Request) {
+	producers := s.nsqlookupd.DB.FindProducers("client", "", "")
 	producerTopics := make([]*producerTopic, len(producers))
 	for i, p := range producers {
 		producerTopics[i] = &producerTopic{
@@ -152,7 +161,7 @@ func nodesHandler(w http.ResponseWriter, req *http.Request) {
 			TcpPort:  p.TcpPort,
 			HttpPort: p.HttpPort,
 			Version:  p.Version,
-			Topics:   lookupd.DB.LookupRegistrations(p).Filter("topic", "*", "").Keys(),
+			Topics:   s.nsqlookupd.DB.LookupRegistrations(p).Filter("topic", "*", "").Keys(),
 		}
 	}
 
@@ -161,7 +170,7 @@ func nodesHandler(w http.ResponseWriter, req *http.Request) {
 	util.ApiResponse(w, 200, "OK", data)
 }
 
-func infoHandler(w http.ResponseWriter, req *http.Request) {
+func (s *httpServer) infoHandler(w http.ResponseWriter, req *http.Request) {
 	util.ApiResponse(w, 200, "OK", struct {
 		Version string `json:"version"`
 	}{
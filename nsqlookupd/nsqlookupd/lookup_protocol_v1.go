@@ -1,8 +1,8 @@
-package main
+package nsqlookupd
 
 import (
-	"../nsq"
-	"../util"
+	"../../nsq"
+	"../../util"
 	"bufio"
 	"bytes"
 	"encoding/binary"
@@ -19,16 +19,16 @@ import (
 
 type LookupProtocolV1 struct {
 	nsq.Protocol
+	nsqlookupd *NSQLookupd
 }
 
+// BigEndian client byte sequence "  V1"
+var protocolV1Magic int32
+
 // v1 版本
 func init() {
-	// BigEndian client byte sequence "  V1"
-	var magicInt int32
 	buf := bytes.NewBuffer([]byte(nsq.MagicV1))
-	binary.Read(buf, binary.BigEndian, &magicInt)
-	// protocols 赋值
-	protocols[magicInt] = &LookupProtocolV1{}
+	binary.Read(buf, binary.BigEndian, &protocolV1Magic)
 }
 
 // 各自client是不同的 IOLoop，负责自己 tcp 循环的函数
@@ -70,10 +70,10 @@ func (p *LookupProtocolV1) IOLoop(conn net.Conn) error {
 
 	log.Printf("CLIENT(%s): closing", client)
 	if client.Producer != nil {
-		lookupd.DB.Remove(Registration{"client", "", ""}, client.Producer)
-		registrations := lookupd.DB.LookupRegistrations(client.Producer)
+		p.nsqlookupd.DB.Remove(Registration{"client", "", ""}, client.Producer)
+		registrations := p.nsqlookupd.DB.LookupRegistrations(client.Producer)
 		for _, r := range registrations {
-			lookupd.DB.Remove(*r, client.Producer)
+			p.nsqlookupd.DB.Remove(*r, client.Producer)
 		}
 	}
 	return err
@@ -132,11 +132,11 @@ func (p *LookupProtocolV1) REGISTER(client *ClientV1, reader *bufio.Reader, para
 	if channel != "" {
 		log.Printf("DB: client(%s) added registration for channel:%s in topic:%s", client, channel, topic)
 		key := Registration{"channel", topic, channel}
-		lookupd.DB.Add(key, client.Producer)
+		p.nsqlookupd.DB.Add(key, client.Producer)
 	}
 	log.Printf("DB: client(%s) added registration for topic:%s", client, topic)
 	key := Registration{"topic", topic, ""}
-	lookupd.DB.Add(key, client.Producer)
+	p.nsqlookupd.DB.Add(key, client.Producer)
 
 	return []byte("OK"), nil
 }
@@ -155,10 +155,10 @@ func (p *LookupProtocolV1) UNREGISTER(client *ClientV1, reader *bufio.Reader, pa
 	if channel != "" {
 		log.Printf("DB: client(%s) removed registration for channel:%s in topic:%s", client, channel, topic)
 		key := Registration{"channel", topic, channel}
-		producers := lookupd.DB.Remove(key, client.Producer)
+		producers := p.nsqlookupd.DB.Remove(key, client.Producer)
 		// for ephemeral channels, remove the channel as well if it has no producers
 		if producers == 0 && strings.HasSuffix(channel, "#ephemeral") {
-			lookupd.DB.RemoveRegistration(key)
+			p.nsqlookupd.DB.RemoveRegistration(key)
 		}
 	}
 
@@ -217,7 +217,7 @@ func (p *LookupProtocolV1) ANNOUNCE_OLD(client *ClientV1, reader *bufio.Reader,
 		}
 		log.Printf("CLIENT(%s): registered TCP:%d HTTP:%d address:%s",
 			client, tcpPort, httpPort, client.Producer.Address)
-		lookupd.DB.Add(Registration{"client", "", ""}, client.Producer)
+		p.nsqlookupd.DB.Add(Registration{"client", "", ""}, client.Producer)
 	}
 
 	var key Registration
@@ -225,12 +225,12 @@ func (p *LookupProtocolV1) ANNOUNCE_OLD(client *ClientV1, reader *bufio.Reader,
 	if channel != "" {
 		log.Printf("DB: client(%s) added registration for channel:%s in topic:%s", client, channel, topic)
 		key = Registration{"channel", topic, channel}
-		lookupd.DB.Add(key, client.Producer)
+		p.nsqlookupd.DB.Add(key, client.Producer)
 	}
 
 	log.Printf("DB: client(%s) added registration for topic:%s", client, topic)
 	key = Registration{"topic", topic, ""}
-	lookupd.DB.Add(key, client.Producer)
+	p.nsqlookupd.DB.Add(key, client.Producer)
 
 	return []byte("OK"), nil
 }
@@ -264,8 +264,22 @@ func (p *LookupProtocolV1) IDENTIFY(client *ClientV1, reader *bufio.Reader, para
 	}
 	producer.LastUpdate = time.Now()
 
+	// worker_id is optional (for older nsqd) so check if it was present
+	var workerId struct {
+		WorkerId *int64 `json:"worker_id"`
+	}
+	json.Unmarshal(body, &workerId)
+	producer.hasWorkerId = workerId.WorkerId != nil
+
+	// nsqd generates message IDs from its worker id, two sharing one
+	// can produce duplicate IDs
+	conflict := p.nsqlookupd.DB.AddUniqueWorker(Registration{"client", "", ""}, &producer)
+	if conflict != nil {
+		log.Printf("ERROR: CLIENT(%s) worker id %d is in use by %s", client.RemoteAddr(), producer.WorkerId, conflict)
+		return nil, nsq.NewClientErr("E_WORKER_ID_CONFLICT",
+			fmt.Sprintf("worker id %d is in use by %s", producer.WorkerId, conflict))
+	}
 	client.Producer = &producer
-	lookupd.DB.Add(Registration{"client", "", ""}, client.Producer)
 	log.Printf("CLIENT(%s) registered TCP:%d HTTP:%d address:%s",
 		client.RemoteAddr(),
 		producer.TcpPort,
@@ -274,8 +288,15 @@ func (p *LookupProtocolV1) IDENTIFY(client *ClientV1, reader *bufio.Reader, para
 
 	// build a response
 	data := make(map[string]interface{})
-	data["tcp_port"] = lookupd.tcpAddr.Port
-	data["http_port"] = lookupd.httpAddr.Port
+	// 0 when only listening on a unix socket
+	data["tcp_port"] = 0
+	if tcpAddr := p.nsqlookupd.TCPAddr(); tcpAddr != nil {
+		data["tcp_port"] = tcpAddr.Port
+	}
+	data["http_port"] = 0
+	if httpAddr := p.nsqlookupd.HTTPAddr(); httpAddr != nil {
+		data["http_port"] = httpAddr.Port
+	}
 	data["version"] = util.BINARY_VERSION
 	hostname, err := os.Hostname()
 	if err != nil {
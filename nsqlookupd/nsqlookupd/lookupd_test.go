@@ -1,7 +1,7 @@
-package main
+package nsqlookupd
 
 import (
-	"../nsq"
+	"../../nsq"
 	"fmt"
 	"github.com/bmizerany/assert"
 	"io/ioutil"
@@ -12,17 +12,16 @@ import (
 	"time"
 )
 
-func mustStartLookupd() (*net.TCPAddr, *net.TCPAddr) {
-	tcpAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:0")
-	httpAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:0")
-	lookupd = NewNSQLookupd()
-	lookupd.tcpAddr = tcpAddr
-	lookupd.httpAddr = httpAddr
-	lookupd.Main()
-
-	tcpAddr = lookupd.tcpListener.Addr().(*net.TCPAddr)
-	httpAddr = lookupd.httpListener.Addr().(*net.TCPAddr)
-	return tcpAddr, httpAddr
+func mustStartLookupd() (*net.TCPAddr, *net.TCPAddr, *NSQLookupd) {
+	options := NewOptions()
+	options.TCPAddress = "127.0.0.1:0"
+	options.HTTPAddress = "127.0.0.1:0"
+	lookupd := New(options)
+	err := lookupd.Start()
+	if err != nil {
+		panic(err)
+	}
+	return lookupd.TCPAddr(), lookupd.HTTPAddr(), lookupd
 }
 
 func mustConnectLookupd(t *testing.T, tcpAddr *net.TCPAddr) net.Conn {
@@ -38,7 +37,7 @@ func TestBasicLookupd(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 	defer log.SetOutput(os.Stdout)
 
-	tcpAddr, httpAddr := mustStartLookupd()
+	tcpAddr, httpAddr, lookupd := mustStartLookupd()
 	defer lookupd.Exit()
 
 	topics := lookupd.DB.FindRegistrations("topic", "*", "*")
@@ -71,7 +70,7 @@ func TestBasicLookupd(t *testing.T) {
 	topicName = "connectmsg"
 	tcpPort := 5000
 	httpPort := 5555
-	cmd := nsq.Identify("fake-version", tcpPort, httpPort, "ip.address")
+	cmd := nsq.Identify("fake-version", tcpPort, httpPort, "ip.address", 1)
 	log.Printf("cmd is %s", string(cmd.Body))
 	err = nsq.SendCommand(conn, cmd)
 	assert.Equal(t, err, nil)
@@ -147,3 +146,30 @@ func TestBasicLookupd(t *testing.T) {
 	assert.Equal(t, len(returnedProducers), 0)
 
 }
+
+// ensure a nsqd is refused if another is using its worker id
+func TestWorkerIdConflict(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	tcpAddr, _, lookupd := mustStartLookupd()
+	defer lookupd.Exit()
+
+	identify := func(address string, workerId int64) []byte {
+		conn := mustConnectLookupd(t, tcpAddr)
+		err := nsq.SendCommand(conn, nsq.Identify("fake-version", 4150, 4151, address, workerId))
+		assert.Equal(t, err, nil)
+		resp, err := nsq.ReadResponse(conn)
+		assert.Equal(t, err, nil)
+		return resp
+	}
+
+	assert.NotEqual(t, identify("first.hostname", 7), []byte("E_WORKER_ID_CONFLICT"))
+	assert.Equal(t, identify("second.hostname", 7), []byte("E_WORKER_ID_CONFLICT"))
+	// the same nsqd reconnecting isn't a conflict
+	assert.NotEqual(t, identify("first.hostname", 7), []byte("E_WORKER_ID_CONFLICT"))
+	assert.NotEqual(t, identify("second.hostname", 8), []byte("E_WORKER_ID_CONFLICT"))
+
+	producers := lookupd.DB.FindProducers("client", "", "")
+	assert.Equal(t, len(producers), 3)
+}
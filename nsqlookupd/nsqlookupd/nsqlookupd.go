@@ -0,0 +1,125 @@
+package nsqlookupd
+
+import (
+	"../../nsq"
+	"../../util"
+	"errors"
+	"net"
+	"sync"
+)
+
+type NSQLookupd struct {
+	sync.RWMutex
+	options       *Options
+	tcpAddr       *net.TCPAddr
+	httpAddr      *net.TCPAddr
+	tcpListeners  []net.Listener
+	httpListeners []net.Listener
+	waitGroup     util.WaitGroupWrapper
+	// 注册 topic
+	DB *RegistrationDB
+}
+
+type Options struct {
+	TCPAddress  string // <addr>:<port> to listen on, port 0 picks a free port (see TCPAddr())
+	HTTPAddress string
+	TCPSocket   string // path of a unix socket to listen on (in addition to, or instead of, TCPAddress)
+	HTTPSocket  string
+}
+
+// NewOptions returns the default options (those of the nsqlookupd binary)
+func NewOptions() *Options {
+	return &Options{
+		TCPAddress:  "0.0.0.0:4160",
+		HTTPAddress: "0.0.0.0:4161",
+	}
+}
+
+func New(options *Options) *NSQLookupd {
+	return &NSQLookupd{
+		options: options,
+		DB:      NewRegistrationDB(),
+	}
+}
+
+// Start listens on the TCP and HTTP addresses and starts serving clients,
+// an error is returned if either can't be listened on
+func (l *NSQLookupd) Start() error {
+	if l.options.TCPAddress == "" && l.options.TCPSocket == "" {
+		return errors.New("a tcp address or socket is required")
+	}
+	if l.options.HTTPAddress == "" && l.options.HTTPSocket == "" {
+		return errors.New("an http address or socket is required")
+	}
+
+	tcpListeners, err := util.Listen(l.options.TCPAddress, l.options.TCPSocket)
+	if err != nil {
+		return err
+	}
+	httpListeners, err := util.Listen(l.options.HTTPAddress, l.options.HTTPSocket)
+	if err != nil {
+		for _, listener := range tcpListeners {
+			listener.Close()
+		}
+		return err
+	}
+	l.Lock()
+	l.tcpListeners = tcpListeners
+	l.httpListeners = httpListeners
+	// the addresses actually listened on (when given port 0)
+	for _, listener := range tcpListeners {
+		if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+			l.tcpAddr = addr
+		}
+	}
+	for _, listener := range httpListeners {
+		if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+			l.httpAddr = addr
+		}
+	}
+	l.Unlock()
+
+	protocols := map[int32]nsq.Protocol{
+		protocolV1Magic: &LookupProtocolV1{nsqlookupd: l},
+	}
+	for _, listener := range tcpListeners {
+		tcpListener := listener
+		l.waitGroup.Wrap(func() { util.TcpServer(tcpListener, &TcpProtocol{protocols: protocols}) })
+	}
+	httpServer := newHTTPServer(l)
+	for _, listener := range httpListeners {
+		httpListener := listener
+		l.waitGroup.Wrap(func() { httpServer.serve(httpListener) })
+	}
+
+	return nil
+}
+
+// TCPAddr returns the address nsqlookupd is listening on for TCP clients
+// (nil until started, or when only listening on a unix socket)
+func (l *NSQLookupd) TCPAddr() *net.TCPAddr {
+	l.RLock()
+	defer l.RUnlock()
+	return l.tcpAddr
+}
+
+// HTTPAddr returns the address nsqlookupd is listening on for HTTP clients
+// (nil until started, or when only listening on a unix socket)
+func (l *NSQLookupd) HTTPAddr() *net.TCPAddr {
+	l.RLock()
+	defer l.RUnlock()
+	return l.httpAddr
+}
+
+func (l *NSQLookupd) Exit() {
+
+	for _, listener := range l.tcpListeners {
+		listener.Close()
+	}
+
+	for _, listener := range l.httpListeners {
+		listener.Close()
+	}
+	l.waitGroup.Wait()
+
+}
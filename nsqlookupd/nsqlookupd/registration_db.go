@@ -1,4 +1,4 @@
-package main
+package nsqlookupd
 
 import (
 	"fmt"
@@ -29,7 +29,12 @@ type Producer struct {
 	TcpPort    int       `json:"tcp_port"`
 	HttpPort   int       `json:"http_port"`
 	Version    string    `json:"version"`
+	WorkerId   int64     `json:"worker_id"`
 	LastUpdate time.Time `json:"-"`
+
+	// producers that announce (rather than IDENTIFY) or are older than
+	// worker id registration can't be checked for conflicts
+	hasWorkerId bool
 }
 
 type Producers []*Producer
@@ -61,6 +66,29 @@ func (r *RegistrationDB) Add(k Registration, p *Producer) {
 	}
 }
 
+// add a producer to a registration unless another producer (at a different
+// address) registered with the same worker id, returning that producer
+func (r *RegistrationDB) AddUniqueWorker(k Registration, p *Producer) *Producer {
+	r.Lock()
+	defer r.Unlock()
+	producers := r.registrationMap[k]
+	if p.hasWorkerId {
+		for _, producer := range producers {
+			if producer.hasWorkerId && producer.WorkerId == p.WorkerId &&
+				(producer.Address != p.Address || producer.TcpPort != p.TcpPort) {
+				return producer
+			}
+		}
+	}
+	for _, producer := range producers {
+		if producer.producerId == p.producerId {
+			return nil
+		}
+	}
+	r.registrationMap[k] = append(producers, p)
+	return nil
+}
+
 // remove a producer from a registration
 func (r *RegistrationDB) Remove(k Registration, p *Producer) int {
 	r.Lock()
@@ -1,4 +1,4 @@
-package main
+package nsqlookupd
 
 import (
 	"github.com/bmizerany/assert"
@@ -14,9 +14,9 @@ func TestRegistrationDB(t *testing.T) {
 	defer log.SetOutput(os.Stdout)
 
 	beginningOfTime := time.Unix(1348797047, 0)
-	p1 := &Producer{"1", "addr", 1, 2, "v1", beginningOfTime}
-	p2 := &Producer{"2", "addr", 2, 3, "v1", beginningOfTime}
-	p3 := &Producer{"3", "addr", 3, 4, "v1", beginningOfTime}
+	p1 := &Producer{"1", "addr", 1, 2, "v1", 0, beginningOfTime, false}
+	p2 := &Producer{"2", "addr", 2, 3, "v1", 0, beginningOfTime, false}
+	p3 := &Producer{"3", "addr", 3, 4, "v1", 0, beginningOfTime, false}
 
 	db := NewRegistrationDB()
 	db.Add(Registration{"c", "a", ""}, p1)
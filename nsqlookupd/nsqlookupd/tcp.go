@@ -1,8 +1,8 @@
-package main
+package nsqlookupd
 
 import (
-	"../nsq"
-	"../util"
+	"../../nsq"
+	"../../util"
 	"log"
 	"net"
 )
@@ -9,7 +9,7 @@ if [ "$HOME" == "/home/travis" ]; then
     cd ~/builds/$GITHUB_USER/nsq
 fi
 
-for dir in nsqd nsqlookupd util/pqueue; do
+for dir in nsqd/nsqd nsqlookupd/nsqlookupd util util/pqueue; do
     echo "testing $dir"
     pushd $dir >/dev/null
     go test -test.v -timeout 15s
@@ -0,0 +1,134 @@
+package util
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// ConfigFile maps a JSON config file onto the flags of a FlagSet, keys are
+// flag names and values are strings, numbers, booleans or (for flags that
+// may be given multiple times) arrays:
+//
+//	{"mem-queue-size": 10000, "lookupd-tcp-address": ["10.0.0.1:4160"]}
+//
+// flags given on the command line take precedence over the file
+type ConfigFile struct {
+	fileName string
+	flagSet  *flag.FlagSet
+	cmdline  map[string]bool
+}
+
+// NewConfigFile must be called once flagSet has been parsed
+func NewConfigFile(fileName string, flagSet *flag.FlagSet) *ConfigFile {
+	c := &ConfigFile{
+		fileName: fileName,
+		flagSet:  flagSet,
+		cmdline:  make(map[string]bool),
+	}
+	flagSet.Visit(func(f *flag.Flag) {
+		c.cmdline[f.Name] = true
+	})
+	return c
+}
+
+// Load sets every flag in the config file
+func (c *ConfigFile) Load() error {
+	config, err := c.read()
+	if err != nil {
+		return err
+	}
+
+	for name, value := range config {
+		if c.cmdline[name] {
+			continue
+		}
+		err := c.set(name, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reload re-reads the config file and sets the given flags, reverting
+// those no longer in the file to their default
+func (c *ConfigFile) Reload(names []string) error {
+	config, err := c.read()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if c.cmdline[name] {
+			continue
+		}
+		f := c.flagSet.Lookup(name)
+		if a, ok := f.Value.(*StringArray); ok {
+			*a = nil
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		value, ok := config[name]
+		if !ok {
+			continue
+		}
+		err := c.set(name, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ConfigFile) read() (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(c.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var config map[string]interface{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s - %s", c.fileName, err.Error())
+	}
+
+	for name := range config {
+		if c.flagSet.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown option %q in %s", name, c.fileName)
+		}
+	}
+	return config, nil
+}
+
+func (c *ConfigFile) set(name string, value interface{}) error {
+	values, ok := value.([]interface{})
+	if ok {
+		if _, isArray := c.flagSet.Lookup(name).Value.(*StringArray); !isArray {
+			return fmt.Errorf("option %q in %s does not take multiple values", name, c.fileName)
+		}
+	} else {
+		values = []interface{}{value}
+	}
+
+	for _, v := range values {
+		var s string
+		switch v.(type) {
+		case string:
+			s = v.(string)
+		case float64:
+			s = strconv.FormatFloat(v.(float64), 'f', -1, 64)
+		case bool:
+			s = strconv.FormatBool(v.(bool))
+		default:
+			return fmt.Errorf("invalid value %v for option %q in %s", v, name, c.fileName)
+		}
+		err := c.flagSet.Set(name, s)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for option %q in %s - %s", s, name, c.fileName, err.Error())
+		}
+	}
+	return nil
+}
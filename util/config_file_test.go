@@ -0,0 +1,66 @@
+package util
+
+import (
+	"flag"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, fileName string, data string) {
+	err := ioutil.WriteFile(fileName, []byte(data), 0600)
+	assert.Equal(t, err, nil)
+}
+
+func TestConfigFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	assert.Equal(t, err, nil)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	size := flagSet.Int64("mem-queue-size", 10000, "")
+	timeout := flagSet.Duration("timeout", time.Second, "")
+	verbose := flagSet.Bool("verbose", false, "")
+	name := flagSet.String("name", "", "")
+	addrs := StringArray{}
+	flagSet.Var(&addrs, "address", "")
+
+	err = flagSet.Parse([]string{"--name=cmdline"})
+	assert.Equal(t, err, nil)
+
+	writeConfigFile(t, f.Name(), `{"mem-queue-size": 100, "timeout": "5s", "verbose": true,
+		"name": "config", "address": ["a:1", "b:2"]}`)
+	configFile := NewConfigFile(f.Name(), flagSet)
+	err = configFile.Load()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *size, int64(100))
+	assert.Equal(t, *timeout, 5*time.Second)
+	assert.Equal(t, *verbose, true)
+	assert.Equal(t, *name, "cmdline")
+	assert.Equal(t, []string(addrs), []string{"a:1", "b:2"})
+
+	// removed options revert to their default
+	writeConfigFile(t, f.Name(), `{"mem-queue-size": 200, "address": ["c:3"], "name": "reload"}`)
+	err = configFile.Reload([]string{"mem-queue-size", "verbose", "address", "name"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *size, int64(200))
+	assert.Equal(t, *timeout, 5*time.Second)
+	assert.Equal(t, *verbose, false)
+	assert.Equal(t, *name, "cmdline")
+	assert.Equal(t, []string(addrs), []string{"c:3"})
+
+	writeConfigFile(t, f.Name(), `{"unknown": 1}`)
+	assert.NotEqual(t, configFile.Load(), nil)
+
+	writeConfigFile(t, f.Name(), `{"mem-queue-size": "lots"}`)
+	assert.NotEqual(t, configFile.Load(), nil)
+
+	writeConfigFile(t, f.Name(), `{"mem-queue-size": [1, 2]}`)
+	assert.NotEqual(t, configFile.Load(), nil)
+
+	writeConfigFile(t, f.Name(), `{"mem-queue-size": 1`)
+	assert.NotEqual(t, configFile.Load(), nil)
+}
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+)
+
+// Listen listens on a TCP address and/or a unix socket path (either can be
+// empty), if either fails the other is closed
+func Listen(tcpAddress string, socketPath string) ([]net.Listener, error) {
+	var listeners []net.Listener
+
+	if tcpAddress != "" {
+		listener, err := net.Listen("tcp", tcpAddress)
+		if err != nil {
+			return nil, fmt.Errorf("listen (%s) failed - %s", tcpAddress, err.Error())
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if socketPath != "" {
+		listener, err := ListenUnix(socketPath)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("listen (%s) failed - %s", socketPath, err.Error())
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// ListenUnix listens on a unix socket, replacing a socket file left behind
+// by a process that didn't exit cleanly
+//
+// unix socket clients are anonymous so the connections it accepts have a
+// RemoteAddr of <path>:<n> to tell them apart
+func ListenUnix(path string) (net.Listener, error) {
+	fi, err := os.Stat(path)
+	if err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &unixListener{Listener: listener, path: path}, nil
+}
+
+type unixListener struct {
+	net.Listener
+	path      string
+	connCount uint64
+}
+
+func (l *unixListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	n := atomic.AddUint64(&l.connCount, 1)
+	remoteAddr := &net.UnixAddr{Name: fmt.Sprintf("%s:%d", l.path, n), Net: "unix"}
+	return &unixConn{Conn: conn, remoteAddr: remoteAddr}, nil
+}
+
+type unixConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (c *unixConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
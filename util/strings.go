@@ -16,6 +16,16 @@ func StringAdd(s []string, a string) []string {
 
 }
 
+// StringIndex returns the index of a in s, or -1 if it isn't present
+func StringIndex(s []string, a string) int {
+	for i, existing := range s {
+		if a == existing {
+			return i
+		}
+	}
+	return -1
+}
+
 func StringUnion(s []string, a []interface{}) []string {
 	o := s
 	for _, entry := range a {
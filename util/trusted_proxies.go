@@ -0,0 +1,54 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// TrustedProxies are the addresses (IPs or CIDR ranges) whose forwarding
+// headers (ie. X-Forwarded-For) are believed, anyone can set them
+type TrustedProxies []*net.IPNet
+
+func NewTrustedProxies(addrs []string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(addrs))
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s", addr)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s", addr)
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies, nil
+}
+
+// Contains returns true if the remote address (<addr>:<port>, as in
+// http.Request.RemoteAddr) is a trusted proxy
+func (p TrustedProxies) Contains(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range p {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
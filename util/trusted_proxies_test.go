@@ -0,0 +1,23 @@
+package util
+
+import (
+	"github.com/bmizerany/assert"
+	"testing"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := NewTrustedProxies([]string{"127.0.0.1", "10.1.0.0/16", "::1"})
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, proxies.Contains("127.0.0.1:4171"), true)
+	assert.Equal(t, proxies.Contains("10.1.2.3:52311"), true)
+	assert.Equal(t, proxies.Contains("[::1]:4171"), true)
+	assert.Equal(t, proxies.Contains("10.2.0.1:52311"), false)
+	assert.Equal(t, proxies.Contains("127.0.0.2:4171"), false)
+	assert.Equal(t, proxies.Contains("invalid"), false)
+
+	_, err = NewTrustedProxies([]string{"localhost"})
+	assert.NotEqual(t, err, nil)
+	_, err = NewTrustedProxies([]string{"10.0.0.0/33"})
+	assert.NotEqual(t, err, nil)
+}